@@ -0,0 +1,366 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+)
+
+// Server is a compiled listener ready to be served
+type Server struct {
+	Name    string
+	Address string
+	Handler http.Handler
+}
+
+// Compiled is a compiled configuration, the health checkers must be started for unhealthy backends to be
+// removed from their pools and stopped when the configuration is replaced
+type Compiled struct {
+	Servers         []*Server
+	HealthCheckers  []proxies.HealthChecker
+	CircuitBreakers []proxies.CircuitBreaker
+	TrafficSplits   []proxies.TrafficSplit
+	transports      []*http.Transport
+}
+
+// compiledBackend is the backend pool and transport shared by every route to a backend
+type compiledBackend struct {
+	pool      proxies.BackendPool
+	transport *http.Transport
+	checker   proxies.HealthChecker
+}
+
+// AdminName is the name of the server that serves the admin address
+const AdminName = "admin"
+
+var strategies = map[string]func(backend *Backend) proxies.SelectionStrategy{
+	"":                 func(*Backend) proxies.SelectionStrategy { return proxies.NewRoundRobinStrategy() },
+	"roundRobin":       func(*Backend) proxies.SelectionStrategy { return proxies.NewRoundRobinStrategy() },
+	"weighted":         func(*Backend) proxies.SelectionStrategy { return proxies.NewWeightedStrategy() },
+	"leastOutstanding": func(*Backend) proxies.SelectionStrategy { return proxies.NewLeastOutstandingStrategy() },
+	"randomTwoChoices": func(*Backend) proxies.SelectionStrategy { return proxies.NewRandomTwoChoicesStrategy() },
+	"consistentHash": func(backend *Backend) proxies.SelectionStrategy {
+		return proxies.NewConsistentHashStrategy(backend.HashOn.key())
+	},
+}
+
+var bodyEncodings = map[string]proxies.BodyEncoding{
+	"":         proxies.ReencodeBody,
+	"reencode": proxies.ReencodeBody,
+	"decode":   proxies.DecodeBody,
+}
+
+func (hashOn *HashOn) key() proxies.HashKey {
+	switch {
+	case hashOn.Header != "":
+		return proxies.HeaderHashKey(hashOn.Header)
+	case hashOn.Cookie != "":
+		return proxies.CookieHashKey(hashOn.Cookie)
+	}
+	return proxies.ClientIPHashKey()
+}
+
+// Compile turns the configuration into reverse proxy handlers, one server for each listener and one for
+// the admin address
+func (config *Config) Compile() (*Compiled, error) {
+	compiled := &Compiled{}
+	backends := map[string]*compiledBackend{}
+	for _, backend := range config.Backends {
+		b, err := backend.compile()
+		if err != nil {
+			return nil, err
+		}
+		backends[backend.Name] = b
+		compiled.transports = append(compiled.transports, b.transport)
+		if b.checker != nil {
+			compiled.HealthCheckers = append(compiled.HealthCheckers, b.checker)
+		}
+	}
+
+	for _, listener := range config.Listeners {
+		handler, err := listener.compile(backends, compiled)
+		if err != nil {
+			return nil, err
+		}
+		compiled.Servers = append(compiled.Servers, &Server{
+			Name:    listener.Name,
+			Address: listener.Address,
+			Handler: handler,
+		})
+	}
+
+	if config.Admin != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/health", proxies.HealthHandler(compiled.HealthCheckers...))
+		mux.Handle("/circuits", proxies.CircuitHandler(compiled.CircuitBreakers...))
+		mux.Handle("/splits", proxies.TrafficSplitHandler(compiled.TrafficSplits...))
+		compiled.Servers = append(compiled.Servers, &Server{
+			Name:    AdminName,
+			Address: config.Admin.Address,
+			Handler: mux,
+		})
+	}
+	return compiled, nil
+}
+
+// Start starts the health checkers
+func (compiled *Compiled) Start() {
+	for _, checker := range compiled.HealthCheckers {
+		checker.Start()
+	}
+}
+
+// Stop stops the health checkers and closes the idle connections of the backend transports
+func (compiled *Compiled) Stop() {
+	for _, checker := range compiled.HealthCheckers {
+		checker.Stop()
+	}
+	for _, transport := range compiled.transports {
+		transport.CloseIdleConnections()
+	}
+}
+
+func (backend *Backend) compile() (*compiledBackend, error) {
+	members := backend.Members
+	if len(members) == 0 {
+		members = []*Member{{URL: backend.URL}}
+	}
+
+	backends := []proxies.Backend{}
+	for _, member := range members {
+		forwardedURL, err := url.Parse(member.URL)
+		if err != nil {
+			return nil, err
+		}
+		weight := 1
+		if member.Weight != nil {
+			weight = *member.Weight
+		}
+		backends = append(backends, proxies.NewBackend(forwardedURL, weight))
+	}
+
+	pool := proxies.NewBackendPool(strategies[backend.Strategy](backend), backends...)
+	if cookie := backend.AffinityCookie; cookie != nil {
+		pool = proxies.NewAffinityPool(pool, proxies.AffinityCookie{
+			Name:   cookie.Name,
+			Path:   cookie.Path,
+			MaxAge: cookie.MaxAge,
+			Secure: cookie.Secure,
+		})
+	}
+
+	compiled := &compiledBackend{
+		pool: pool,
+		transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: backend.SkipSSLValidation},
+			// transports are replaced on reload, so idle connections must not be kept forever
+			IdleConnTimeout: 90 * time.Second,
+		},
+	}
+	if check := backend.HealthCheck; check != nil {
+		compiled.checker = proxies.NewHealthChecker(compiled.pool, proxies.HealthCheck{
+			Path:               check.Path,
+			ExpectedStatus:     check.ExpectedStatus,
+			Interval:           check.Interval,
+			Timeout:            check.Timeout,
+			HealthyThreshold:   check.HealthyThreshold,
+			UnhealthyThreshold: check.UnhealthyThreshold,
+		}, compiled.transport)
+	}
+	return compiled, nil
+}
+
+func (listener *Listener) compile(backends map[string]*compiledBackend, compiled *Compiled) (http.Handler, error) {
+	var notFound http.Handler = http.NotFoundHandler()
+	if listener.NotFound != nil {
+		notFound = listener.NotFound.handler()
+	}
+
+	// routes are grouped by host, routes without a host serve every other host
+	hosts := []string{}
+	routers := map[string]proxies.RouterBuilder{}
+	for _, route := range listener.Routes {
+		host := strings.ToLower(strings.TrimSpace(route.Host))
+		router, ok := routers[host]
+		if !ok {
+			router = proxies.NewRouterBuilder().NotFound(notFound)
+			routers[host] = router
+			hosts = append(hosts, host)
+		}
+
+		handler, err := route.compile(backends, compiled)
+		if err != nil {
+			return nil, fmt.Errorf("route '%s%s': %w", route.Host, route.PathPrefix, err)
+		}
+		router.Handle(route.PathPrefix, handler)
+	}
+
+	defaultHandler := notFound
+	if router, ok := routers[""]; ok {
+		defaultHandler = router.ToRouter(nil)
+	}
+	if len(hosts) == 1 && hosts[0] == "" {
+		return defaultHandler, nil
+	}
+
+	virtualHosts := proxies.NewVirtualHostBuilder().NotFound(defaultHandler)
+	for _, host := range hosts {
+		if host == "" {
+			continue
+		}
+		virtualHosts.HandleHost(host, routers[host].ToRouter(nil))
+	}
+	return virtualHosts.ToHandler(nil), nil
+}
+
+func (route *Route) compile(backends map[string]*compiledBackend, compiled *Compiled) (http.Handler, error) {
+	handler, err := route.proxy(backends[route.Backend], route.DefaultRewrites, route.Request, route.Response, compiled)
+	if err != nil {
+		return nil, err
+	}
+	if route.Split == nil {
+		return handler, nil
+	}
+
+	split := route.Split
+	builder := proxies.NewTrafficSplitBuilder(split.Name)
+	if split.Header != "" {
+		builder = builder.Header(split.Header)
+	}
+
+	remaining := 100
+	for _, variant := range split.Variants {
+		remaining -= variant.Weight
+	}
+	builder = builder.Variant(split.defaultName(), remaining, handler)
+
+	for _, variant := range split.Variants {
+		var condition proxies.RequestCondition
+		if variant.When != nil {
+			var err error
+			if condition, err = variant.When.requestCondition(); err != nil {
+				return nil, err
+			}
+		}
+		variantHandler, err := route.proxy(backends[variant.Backend], variant.DefaultRewrites, variant.Request, variant.Response, compiled)
+		if err != nil {
+			return nil, err
+		}
+		builder = builder.VariantIf(variant.Name, variant.Weight, condition, variantHandler)
+	}
+
+	trafficSplit := builder.ToTrafficSplit()
+	compiled.TrafficSplits = append(compiled.TrafficSplits, trafficSplit)
+	return trafficSplit, nil
+}
+
+// proxy creates the reverse proxy that forwards the requests of the route, or of one of its variants, to the backend
+func (route *Route) proxy(backend *compiledBackend, defaultRewrites *bool, request []*Step, response []*Step, compiled *Compiled) (http.Handler, error) {
+	target := &target{
+		pool:       backend.pool,
+		pathPrefix: route.PathPrefix,
+	}
+
+	builder := proxies.NewReverseProxyBuilder()
+	if defaultRewrites == nil || *defaultRewrites {
+		builder = proxies.DefaultPoolRouteConfiguration(builder, target.pool, target.pathPrefix)
+	}
+	for _, step := range request {
+		condition, err := step.When.requestCondition()
+		if err != nil {
+			return nil, err
+		}
+		builder = requestSteps[step.Action].apply(builder, step, target, condition)
+	}
+	for _, step := range response {
+		condition, err := step.When.responseCondition()
+		if err != nil {
+			return nil, err
+		}
+		builder = responseSteps[step.Action].apply(builder, step, target, condition)
+	}
+	if mirror := route.Mirror; mirror != nil {
+		// the url was validated when the configuration was loaded
+		shadowURL, _ := url.Parse(mirror.URL)
+		percentage := 100.0
+		if mirror.Percentage != nil {
+			percentage = *mirror.Percentage
+		}
+		builder = builder.Mirror(shadowURL, percentage, mirror.MaxBodySize)
+	}
+	if encoding, ok := bodyEncodings[route.BodyEncoding]; ok {
+		builder = builder.RewrittenBodyEncoding(encoding)
+	}
+	if len(route.ContentTypes) > 0 {
+		builder = builder.RewriteContentTypes(route.ContentTypes...)
+	}
+	if body := route.RequestBody; body != nil {
+		builder = builder.RequestBodyLimit(body.MaxSize, body.Passthrough)
+	}
+	if response := route.ErrorResponse; response != nil {
+		builder = builder.ErrorHandler(response.errorHandler())
+	}
+
+	var transport http.RoundTripper = backend.transport
+	if route.CircuitBreaker != nil {
+		breaker := proxies.NewCircuitBreaker(transport, proxies.CircuitBreakerSettings{
+			ConsecutiveFailures: route.CircuitBreaker.ConsecutiveFailures,
+			Cooldown:            route.CircuitBreaker.Cooldown,
+			HalfOpenRequests:    route.CircuitBreaker.HalfOpenRequests,
+			OnStateChange: func(host string, from proxies.CircuitState, to proxies.CircuitState) {
+				log.Printf("circuit to %s for route '%s%s' changed from %s to %s", host, route.Host, route.PathPrefix, from, to)
+			},
+		})
+		compiled.CircuitBreakers = append(compiled.CircuitBreakers, breaker)
+		transport = breaker
+	}
+	if retry := route.Retry; retry != nil {
+		transport = proxies.NewRetryTransport(transport, proxies.RetryPolicy{
+			MaxAttempts:        retry.MaxAttempts,
+			PerTryTimeout:      retry.PerTryTimeout,
+			Backoff:            retry.Backoff,
+			MaxBackoff:         retry.MaxBackoff,
+			RetryOn:            retry.RetryOn,
+			RetryNonIdempotent: retry.RetryNonIdempotent,
+		})
+	}
+	return builder.Transport(transport).Build()
+}
+
+// defaultName is the name of the variant served by the route itself
+func (split *Split) defaultName() string {
+	if split.Default == "" {
+		return "default"
+	}
+	return split.Default
+}
+
+// errorHandler answers failed requests with the response, a zero status uses the status of the failure
+func (response *Response) errorHandler() proxies.ErrorHandler {
+	header := http.Header{}
+	for name, value := range response.Headers {
+		header.Set(name, value)
+	}
+	return proxies.ErrorResponse{Status: response.Status, Body: response.Body, Header: header}.Handler()
+}
+
+func (response *Response) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range response.Headers {
+			w.Header().Set(name, value)
+		}
+		status := response.Status
+		if status == 0 {
+			status = http.StatusNotFound
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(response.Body))
+	})
+}
@@ -0,0 +1,373 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes the backends, listeners, routes and rewrite pipeline of the proxy
+type Config struct {
+	Backends  []*Backend  `yaml:"backends"`
+	Listeners []*Listener `yaml:"listeners"`
+	Admin     *Admin      `yaml:"admin"`
+	pos       position
+}
+
+// Admin describes the address that serves the health of the backends
+type Admin struct {
+	Address string `yaml:"address"`
+	pos     position
+}
+
+// Backend describes a forwarded url, or a pool of forwarded urls, that routes can send traffic to
+type Backend struct {
+	Name              string          `yaml:"name"`
+	URL               string          `yaml:"url"`
+	Members           []*Member       `yaml:"members"`
+	Strategy          string          `yaml:"strategy"`
+	SkipSSLValidation bool            `yaml:"skipSSLValidation"`
+	HealthCheck       *HealthCheck    `yaml:"healthCheck"`
+	HashOn            *HashOn         `yaml:"hashOn"`
+	AffinityCookie    *AffinityCookie `yaml:"affinityCookie"`
+	pos               position
+}
+
+// HashOn describes the request value the consistentHash strategy hashes on, exactly one value must be set
+type HashOn struct {
+	Header   string `yaml:"header"`
+	Cookie   string `yaml:"cookie"`
+	ClientIP bool   `yaml:"clientIP"`
+	pos      position
+}
+
+// AffinityCookie describes the cookie the proxy issues to pin clients to a backend member
+type AffinityCookie struct {
+	Name   string        `yaml:"name"`
+	Path   string        `yaml:"path"`
+	MaxAge time.Duration `yaml:"maxAge"`
+	Secure bool          `yaml:"secure"`
+	pos    position
+}
+
+// HealthCheck describes how the members of a backend are probed, members that fail the check are not
+// sent traffic until they recover
+type HealthCheck struct {
+	Path               string        `yaml:"path"`
+	ExpectedStatus     int           `yaml:"expectedStatus"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	HealthyThreshold   int           `yaml:"healthyThreshold"`
+	UnhealthyThreshold int           `yaml:"unhealthyThreshold"`
+	pos                position
+}
+
+// Member describes a forwarded url in a backend pool
+type Member struct {
+	URL    string `yaml:"url"`
+	Weight *int   `yaml:"weight"`
+	pos    position
+}
+
+// Listener describes an address the proxy listens on and the routes it serves
+type Listener struct {
+	Name     string    `yaml:"name"`
+	Address  string    `yaml:"address"`
+	Routes   []*Route  `yaml:"routes"`
+	NotFound *Response `yaml:"notFound"`
+	pos      position
+}
+
+// Response describes a static response, a zero status uses the default of the place the response is used
+type Response struct {
+	Status  int               `yaml:"status"`
+	Body    string            `yaml:"body"`
+	Headers map[string]string `yaml:"headers"`
+	pos     position
+}
+
+// Route describes how requests matching a host and path prefix are forwarded to a backend
+type Route struct {
+	Host            string          `yaml:"host"`
+	PathPrefix      string          `yaml:"pathPrefix"`
+	Backend         string          `yaml:"backend"`
+	DefaultRewrites *bool           `yaml:"defaultRewrites"`
+	CircuitBreaker  *CircuitBreaker `yaml:"circuitBreaker"`
+	Retry           *Retry          `yaml:"retry"`
+	Split           *Split          `yaml:"split"`
+	Mirror          *Mirror         `yaml:"mirror"`
+	RequestBody     *RequestBody    `yaml:"requestBody"`
+	ContentTypes    []string        `yaml:"contentTypes"`
+	BodyEncoding    string          `yaml:"bodyEncoding"`
+	Request         []*Step         `yaml:"request"`
+	Response        []*Step         `yaml:"response"`
+	ErrorResponse   *Response       `yaml:"errorResponse"`
+	pos             position
+}
+
+// CircuitBreaker describes when the route stops sending requests to a failing backend member and how
+// it probes for recovery
+type CircuitBreaker struct {
+	ConsecutiveFailures int           `yaml:"consecutiveFailures"`
+	Cooldown            time.Duration `yaml:"cooldown"`
+	HalfOpenRequests    int           `yaml:"halfOpenRequests"`
+	pos                 position
+}
+
+// Split describes how the traffic of a route is shared with other variants, for example a canary release.
+// The route itself is the default variant and receives the percentage of traffic the other variants leave
+type Split struct {
+	Name     string     `yaml:"name"`
+	Header   string     `yaml:"header"`
+	Default  string     `yaml:"default"`
+	Variants []*Variant `yaml:"variants"`
+	pos      position
+}
+
+// Variant describes a backend and rewrite pipeline that receives a percentage of the traffic of a route and
+// every request that matches its condition
+type Variant struct {
+	Name            string     `yaml:"name"`
+	Backend         string     `yaml:"backend"`
+	Weight          int        `yaml:"weight"`
+	When            *Condition `yaml:"when"`
+	DefaultRewrites *bool      `yaml:"defaultRewrites"`
+	Request         []*Step    `yaml:"request"`
+	Response        []*Step    `yaml:"response"`
+	pos             position
+}
+
+// Mirror describes a shadow backend that receives a copy of a percentage of the requests of a route, the
+// responses of the shadow backend are discarded
+type Mirror struct {
+	URL         string   `yaml:"url"`
+	Percentage  *float64 `yaml:"percentage"`
+	MaxBodySize int64    `yaml:"maxBodySize"`
+	pos         position
+}
+
+// RequestBody describes how much of a request body the body rewrites of a route buffer, larger bodies are
+// rejected unless passthrough is set
+type RequestBody struct {
+	MaxSize     int64 `yaml:"maxSize"`
+	Passthrough bool  `yaml:"passthrough"`
+	pos         position
+}
+
+// Retry describes how failed requests are sent again, only idempotent methods are retried unless
+// retryNonIdempotent is set
+type Retry struct {
+	MaxAttempts        int           `yaml:"maxAttempts"`
+	PerTryTimeout      time.Duration `yaml:"perTryTimeout"`
+	Backoff            time.Duration `yaml:"backoff"`
+	MaxBackoff         time.Duration `yaml:"maxBackoff"`
+	RetryOn            []int         `yaml:"retryOn"`
+	RetryNonIdempotent bool          `yaml:"retryNonIdempotent"`
+	pos                position
+}
+
+// Step describes a single request or response rewrite
+type Step struct {
+	Action      string     `yaml:"action"`
+	Name        string     `yaml:"name"`
+	Value       string     `yaml:"value"`
+	Source      string     `yaml:"source"`
+	Destination string     `yaml:"destination"`
+	Match       string     `yaml:"match"`
+	Replace     string     `yaml:"replace"`
+	Path        string     `yaml:"path"`
+	Scripts     bool       `yaml:"scripts"`
+	Text        bool       `yaml:"text"`
+	Patterns    []string   `yaml:"patterns"`
+	When        *Condition `yaml:"when"`
+	pos         position
+}
+
+// Condition describes when a step or variant applies, all of the specified values must match. The value is
+// compared to the header, cookie or query parameter and match is a regular expression for the header. All, any
+// and not combine other conditions
+type Condition struct {
+	PathPrefix  string       `yaml:"pathPrefix"`
+	PathRegex   string       `yaml:"pathRegex"`
+	PathGlob    string       `yaml:"pathGlob"`
+	Method      string       `yaml:"method"`
+	Header      string       `yaml:"header"`
+	Cookie      string       `yaml:"cookie"`
+	Query       string       `yaml:"query"`
+	Value       string       `yaml:"value"`
+	Match       string       `yaml:"match"`
+	ClientCIDR  []string     `yaml:"clientCIDR"`
+	Status      int          `yaml:"status"`
+	MinStatus   int          `yaml:"minStatus"`
+	MaxStatus   int          `yaml:"maxStatus"`
+	ContentType []string     `yaml:"contentType"`
+	All         []*Condition `yaml:"all"`
+	Any         []*Condition `yaml:"any"`
+	Not         *Condition   `yaml:"not"`
+	pos         position
+}
+
+// position records where a mapping and its keys were found in the configuration file
+type position struct {
+	line    int
+	column  int
+	keys    map[string]*yaml.Node
+	unknown []*yaml.Node
+}
+
+// UnmarshalYAML decodes the config and records its position
+func (c *Config) UnmarshalYAML(node *yaml.Node) error {
+	type plain Config
+	return decode(node, (*plain)(c), &c.pos)
+}
+
+// UnmarshalYAML decodes the admin and records its position
+func (a *Admin) UnmarshalYAML(node *yaml.Node) error {
+	type plain Admin
+	return decode(node, (*plain)(a), &a.pos)
+}
+
+// UnmarshalYAML decodes the health check and records its position
+func (h *HealthCheck) UnmarshalYAML(node *yaml.Node) error {
+	type plain HealthCheck
+	return decode(node, (*plain)(h), &h.pos)
+}
+
+// UnmarshalYAML decodes the hash settings and records their position
+func (h *HashOn) UnmarshalYAML(node *yaml.Node) error {
+	type plain HashOn
+	return decode(node, (*plain)(h), &h.pos)
+}
+
+// UnmarshalYAML decodes the affinity cookie and records its position
+func (a *AffinityCookie) UnmarshalYAML(node *yaml.Node) error {
+	type plain AffinityCookie
+	return decode(node, (*plain)(a), &a.pos)
+}
+
+// UnmarshalYAML decodes the backend and records its position
+func (b *Backend) UnmarshalYAML(node *yaml.Node) error {
+	type plain Backend
+	return decode(node, (*plain)(b), &b.pos)
+}
+
+// UnmarshalYAML decodes the member and records its position
+func (m *Member) UnmarshalYAML(node *yaml.Node) error {
+	type plain Member
+	return decode(node, (*plain)(m), &m.pos)
+}
+
+// UnmarshalYAML decodes the listener and records its position
+func (l *Listener) UnmarshalYAML(node *yaml.Node) error {
+	type plain Listener
+	return decode(node, (*plain)(l), &l.pos)
+}
+
+// UnmarshalYAML decodes the response and records its position
+func (r *Response) UnmarshalYAML(node *yaml.Node) error {
+	type plain Response
+	return decode(node, (*plain)(r), &r.pos)
+}
+
+// UnmarshalYAML decodes the route and records its position
+func (r *Route) UnmarshalYAML(node *yaml.Node) error {
+	type plain Route
+	return decode(node, (*plain)(r), &r.pos)
+}
+
+// UnmarshalYAML decodes the circuit breaker and records its position
+func (c *CircuitBreaker) UnmarshalYAML(node *yaml.Node) error {
+	type plain CircuitBreaker
+	return decode(node, (*plain)(c), &c.pos)
+}
+
+// UnmarshalYAML decodes the split and records its position
+func (s *Split) UnmarshalYAML(node *yaml.Node) error {
+	type plain Split
+	return decode(node, (*plain)(s), &s.pos)
+}
+
+// UnmarshalYAML decodes the variant and records its position
+func (v *Variant) UnmarshalYAML(node *yaml.Node) error {
+	type plain Variant
+	return decode(node, (*plain)(v), &v.pos)
+}
+
+// UnmarshalYAML decodes the mirror and records its position
+func (m *Mirror) UnmarshalYAML(node *yaml.Node) error {
+	type plain Mirror
+	return decode(node, (*plain)(m), &m.pos)
+}
+
+// UnmarshalYAML decodes the request body limit and records its position
+func (r *RequestBody) UnmarshalYAML(node *yaml.Node) error {
+	type plain RequestBody
+	return decode(node, (*plain)(r), &r.pos)
+}
+
+// UnmarshalYAML decodes the retry and records its position
+func (r *Retry) UnmarshalYAML(node *yaml.Node) error {
+	type plain Retry
+	return decode(node, (*plain)(r), &r.pos)
+}
+
+// UnmarshalYAML decodes the step and records its position
+func (s *Step) UnmarshalYAML(node *yaml.Node) error {
+	type plain Step
+	return decode(node, (*plain)(s), &s.pos)
+}
+
+// UnmarshalYAML decodes the condition and records its position
+func (c *Condition) UnmarshalYAML(node *yaml.Node) error {
+	type plain Condition
+	return decode(node, (*plain)(c), &c.pos)
+}
+
+func decode(node *yaml.Node, value interface{}, pos *position) error {
+	if err := node.Decode(value); err != nil {
+		return err
+	}
+
+	pos.line = node.Line
+	pos.column = node.Column
+	pos.keys = map[string]*yaml.Node{}
+	pos.unknown = []*yaml.Node{}
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	known := knownKeys(value)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if _, ok := known[key.Value]; !ok {
+			pos.unknown = append(pos.unknown, key)
+			continue
+		}
+		pos.keys[key.Value] = key
+	}
+	return nil
+}
+
+func knownKeys(value interface{}) map[string]struct{} {
+	keys := map[string]struct{}{}
+	t := reflect.TypeOf(value).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("yaml")
+		name := strings.Split(tag, ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		keys[name] = struct{}{}
+	}
+	return keys
+}
+
+// at returns the line and column of the key, falling back to the position of the mapping
+func (p position) at(key string) (int, int) {
+	if node, ok := p.keys[key]; ok {
+		return node.Line, node.Column
+	}
+	return p.line, p.column
+}
@@ -0,0 +1,898 @@
+package config_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/patrickhuber/go-reverse-proxy/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var (
+		backend *httptest.Server
+	)
+	BeforeEach(func() {
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Backend", "true")
+			fmt.Fprintf(w, "path=%s header=%s", r.URL.Path, r.Header.Get("X-Step"))
+		}))
+	})
+	AfterEach(func() {
+		backend.Close()
+	})
+	serve := func(cfg *config.Config, host string, path string, header http.Header) (int, string) {
+		compiled, err := cfg.Compile()
+		Expect(err).To(BeNil())
+		Expect(len(compiled.Servers)).To(Equal(1))
+
+		frontend := httptest.NewServer(compiled.Servers[0].Handler)
+		defer frontend.Close()
+
+		req, err := http.NewRequest("GET", frontend.URL+path, nil)
+		Expect(err).To(BeNil())
+		req.Host = host
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).To(BeNil())
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).To(BeNil())
+		return res.StatusCode, string(body)
+	}
+	Context("yaml", func() {
+		It("compiles routes and steps", func() {
+			content := fmt.Sprintf(`
+backends:
+  - name: app
+    url: %s
+listeners:
+  - address: ":8080"
+    notFound:
+      status: 404
+      body: missing
+    routes:
+      - pathPrefix: /app
+        backend: app
+        request:
+          - action: setHeader
+            name: X-Step
+            value: applied
+            when:
+              header: X-Enabled
+              value: "yes"
+        response:
+          - action: replaceBody
+            match: "path="
+            replace: "backend-path="
+`, backend.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+
+			status, body := serve(cfg, "", "/app/ok", http.Header{"X-Enabled": []string{"yes"}})
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("backend-path=/ok header=applied"))
+
+			status, body = serve(cfg, "", "/app/ok", nil)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("backend-path=/ok header="))
+
+			status, body = serve(cfg, "", "/other", nil)
+			Expect(status).To(Equal(http.StatusNotFound))
+			Expect(body).To(Equal("missing"))
+		})
+		It("routes by host", func() {
+			content := fmt.Sprintf(`
+backends:
+  - name: app
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - host: "*.example.com"
+        backend: app
+`, backend.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+
+			status, _ := serve(cfg, "www.example.com", "/ok", nil)
+			Expect(status).To(Equal(http.StatusOK))
+
+			status, _ = serve(cfg, "www.example.org", "/ok", nil)
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+	Context("pools", func() {
+		It("spreads requests across members", func() {
+			other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "other")
+			}))
+			defer other.Close()
+
+			content := fmt.Sprintf(`
+backends:
+  - name: app
+    strategy: roundRobin
+    members:
+      - url: %s
+      - url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - backend: app
+`, backend.URL, other.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+
+			compiled, err := cfg.Compile()
+			Expect(err).To(BeNil())
+			frontend := httptest.NewServer(compiled.Servers[0].Handler)
+			defer frontend.Close()
+
+			bodies := []string{}
+			for i := 0; i < 2; i++ {
+				res, err := http.Get(frontend.URL + "/ok")
+				Expect(err).To(BeNil())
+				body, err := ioutil.ReadAll(res.Body)
+				res.Body.Close()
+				Expect(err).To(BeNil())
+				bodies = append(bodies, string(body))
+			}
+			Expect(bodies).To(ConsistOf("path=/ok header=", "other"))
+		})
+		It("removes unhealthy members", func() {
+			unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			defer unhealthy.Close()
+
+			content := fmt.Sprintf(`
+backends:
+  - name: app
+    members:
+      - url: %s
+      - url: %s
+    healthCheck:
+      path: /health
+      interval: 1m
+      unhealthyThreshold: 1
+listeners:
+  - address: ":8080"
+    routes:
+      - backend: app
+admin:
+  address: ":9090"
+`, backend.URL, unhealthy.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+
+			compiled, err := cfg.Compile()
+			Expect(err).To(BeNil())
+			Expect(compiled.HealthCheckers).To(HaveLen(1))
+			Expect(compiled.Servers).To(HaveLen(2))
+			Expect(compiled.Servers[1].Name).To(Equal(config.AdminName))
+			compiled.HealthCheckers[0].Check()
+
+			frontend := httptest.NewServer(compiled.Servers[0].Handler)
+			defer frontend.Close()
+			for i := 0; i < 2; i++ {
+				res, err := http.Get(frontend.URL + "/ok")
+				Expect(err).To(BeNil())
+				res.Body.Close()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+			}
+
+			admin := httptest.NewServer(compiled.Servers[1].Handler)
+			defer admin.Close()
+			res, err := http.Get(admin.URL + "/health")
+			Expect(err).To(BeNil())
+			defer res.Body.Close()
+			body, err := ioutil.ReadAll(res.Body)
+			Expect(err).To(BeNil())
+			Expect(string(body)).To(ContainSubstring(`"url":"` + unhealthy.URL + `","healthy":false`))
+		})
+		It("rejects invalid health checks", func() {
+			content := "backends:\n  - name: app\n    url: http://app\n    healthCheck:\n      path: health\n      interval: soon\n"
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(HavePrefix("proxy.yml:6: "))
+
+			content = "backends:\n  - name: app\n    url: http://app\n    healthCheck:\n      path: health\n"
+			_, err = config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("proxy.yml:5:7: health check path 'health' must start with '/'"))
+		})
+		It("pins clients with hashing and affinity cookies", func() {
+			other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "other")
+			}))
+			defer other.Close()
+
+			content := fmt.Sprintf(`
+backends:
+  - name: app
+    strategy: consistentHash
+    hashOn:
+      header: X-User
+    affinityCookie:
+      name: sticky
+      maxAge: 1h
+    members:
+      - url: %s
+      - url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - backend: app
+`, backend.URL, other.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+
+			compiled, err := cfg.Compile()
+			Expect(err).To(BeNil())
+			frontend := httptest.NewServer(compiled.Servers[0].Handler)
+			defer frontend.Close()
+
+			bodies := map[string]bool{}
+			for i := 0; i < 4; i++ {
+				req, err := http.NewRequest("GET", frontend.URL+"/ok", nil)
+				Expect(err).To(BeNil())
+				req.Header.Set("X-User", "alice")
+				res, err := http.DefaultClient.Do(req)
+				Expect(err).To(BeNil())
+				body, err := ioutil.ReadAll(res.Body)
+				res.Body.Close()
+				Expect(err).To(BeNil())
+				bodies[string(body)] = true
+				Expect(res.Header.Get("Set-Cookie")).To(HavePrefix("sticky="))
+				Expect(res.Header.Get("Set-Cookie")).To(ContainSubstring("Max-Age=3600"))
+			}
+			Expect(bodies).To(HaveLen(1))
+		})
+		It("requires hash settings for consistent hashing", func() {
+			content := "backends:\n  - name: app\n    strategy: consistentHash\n    url: http://app\n"
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("proxy.yml:3:5: strategy 'consistentHash' requires 'hashOn'"))
+
+			content = "backends:\n  - name: app\n    strategy: consistentHash\n    hashOn:\n      header: X-User\n      clientIP: true\n    url: http://app\n"
+			_, err = config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("proxy.yml:5:7: hashOn requires exactly one of 'header', 'cookie' or 'clientIP'"))
+		})
+		It("rejects unknown strategies", func() {
+			content := "backends:\n  - name: app\n    strategy: fastest\n    url: http://app\n"
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("proxy.yml:3:5: unknown strategy 'fastest'"))
+		})
+	})
+	Context("circuit breaker", func() {
+		It("fails fast once the circuit opens", func() {
+			failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+			defer failing.Close()
+
+			content := fmt.Sprintf(`
+backends:
+  - name: app
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - backend: app
+        circuitBreaker:
+          consecutiveFailures: 1
+          cooldown: 1m
+`, failing.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+
+			compiled, err := cfg.Compile()
+			Expect(err).To(BeNil())
+			Expect(compiled.CircuitBreakers).To(HaveLen(1))
+			frontend := httptest.NewServer(compiled.Servers[0].Handler)
+			defer frontend.Close()
+
+			statuses := []int{}
+			for i := 0; i < 2; i++ {
+				res, err := http.Get(frontend.URL + "/ok")
+				Expect(err).To(BeNil())
+				res.Body.Close()
+				statuses = append(statuses, res.StatusCode)
+			}
+			Expect(statuses).To(Equal([]int{http.StatusBadGateway, http.StatusServiceUnavailable}))
+		})
+		It("rejects negative thresholds", func() {
+			content := "listeners:\n  - address: \":8080\"\n    routes:\n      - backend: app\n        circuitBreaker:\n          consecutiveFailures: -1\n"
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("proxy.yml:6:11: consecutive failures must not be negative"))
+		})
+	})
+	Context("retry", func() {
+		It("retries failed requests", func() {
+			attempts := 0
+			flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if attempts == 1 {
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				fmt.Fprint(w, "recovered")
+			}))
+			defer flaky.Close()
+
+			content := fmt.Sprintf(`
+backends:
+  - name: app
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - backend: app
+        retry:
+          maxAttempts: 2
+          backoff: 1ms
+          retryOn: [429]
+`, flaky.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+
+			status, body := serve(cfg, "", "/ok", nil)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("recovered"))
+			Expect(attempts).To(Equal(2))
+		})
+		It("rejects invalid status codes", func() {
+			content := "listeners:\n  - address: \":8080\"\n    routes:\n      - backend: app\n        retry:\n          retryOn: [42]\n"
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("proxy.yml:6:11: invalid status code 42"))
+		})
+	})
+	Context("split", func() {
+		var canary *httptest.Server
+		BeforeEach(func() {
+			canary = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, "canary header=%s", r.Header.Get("X-Step"))
+			}))
+		})
+		AfterEach(func() {
+			canary.Close()
+		})
+		content := func(weight int) string {
+			return fmt.Sprintf(`
+backends:
+  - name: stable
+    url: %s
+  - name: canary
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - backend: stable
+        split:
+          name: app
+          default: stable
+          variants:
+            - name: canary
+              backend: canary
+              weight: %d
+              when:
+                cookie: canary
+                value: "true"
+              request:
+                - action: setHeader
+                  name: X-Step
+                  value: canary
+`, backend.URL, canary.URL, weight)
+		}
+		It("sends matching requests to the variant with its own steps", func() {
+			cfg, err := config.Parse("proxy.yml", []byte(content(0)))
+			Expect(err).To(BeNil())
+
+			_, body := serve(cfg, "", "/ok", nil)
+			Expect(body).To(Equal("path=/ok header="))
+
+			_, body = serve(cfg, "", "/ok", http.Header{"Cookie": []string{"canary=true"}})
+			Expect(body).To(Equal("canary header=canary"))
+		})
+		It("splits by weight and adjusts weights through the admin api", func() {
+			cfg, err := config.Parse("proxy.yml", []byte(content(100)+"admin:\n  address: \":9090\"\n"))
+			Expect(err).To(BeNil())
+			compiled, err := cfg.Compile()
+			Expect(err).To(BeNil())
+			Expect(compiled.TrafficSplits).To(HaveLen(1))
+
+			frontend := httptest.NewServer(compiled.Servers[0].Handler)
+			defer frontend.Close()
+			admin := httptest.NewServer(compiled.Servers[1].Handler)
+			defer admin.Close()
+
+			res, err := http.Get(frontend.URL + "/ok")
+			Expect(err).To(BeNil())
+			res.Body.Close()
+			Expect(res.Header.Get("X-Variant")).To(Equal("canary"))
+
+			req, err := http.NewRequest("PUT", admin.URL+"/splits", strings.NewReader(`{"name":"app","weights":{"stable":100,"canary":0}}`))
+			Expect(err).To(BeNil())
+			res, err = http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			res, err = http.Get(frontend.URL + "/ok")
+			Expect(err).To(BeNil())
+			res.Body.Close()
+			Expect(res.Header.Get("X-Variant")).To(Equal("stable"))
+		})
+		It("rejects weights over 100 percent", func() {
+			_, err := config.Parse("proxy.yml", []byte(content(101)))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("weight must be a percentage between 0 and 100"))
+			Expect(err.Error()).To(ContainSubstring("variant weights add up to 101, more than 100 percent"))
+		})
+	})
+	Context("mirror", func() {
+		It("copies requests to the shadow backend", func() {
+			received := make(chan string, 1)
+			shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received <- r.URL.Path
+			}))
+			defer shadow.Close()
+
+			content := fmt.Sprintf(`
+backends:
+  - name: app
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - backend: app
+        mirror:
+          url: %s/shadow
+          percentage: 100
+          maxBodySize: 1024
+`, backend.URL, shadow.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+
+			_, body := serve(cfg, "", "/ok", nil)
+			Expect(body).To(Equal("path=/ok header="))
+			Eventually(received).Should(Receive(Equal("/shadow/ok")))
+		})
+		It("rejects invalid percentages", func() {
+			content := "listeners:\n  - address: \":8080\"\n    routes:\n      - backend: app\n        mirror:\n          url: http://shadow\n          percentage: 150\n"
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("proxy.yml:7:11: percentage must be between 0 and 100"))
+		})
+	})
+	Context("html", func() {
+		It("maps root relative links to the path prefix", func() {
+			site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprint(w, `<a href="/login">login</a><script>go("/login")</script>`)
+			}))
+			defer site.Close()
+			content := fmt.Sprintf(`
+backends:
+  - name: site
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - pathPrefix: /app
+        backend: site
+        response:
+          - action: rewriteHTML
+            scripts: true
+`, site.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+
+			_, body := serve(cfg, "", "/app/", nil)
+			Expect(body).To(Equal(`<a href="/app/login">login</a><script>go("/login")</script>`))
+		})
+	})
+	Context("assets", func() {
+		It("maps string literals of scripts", func() {
+			site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/javascript")
+				fmt.Fprint(w, `fetch("/api/users")`)
+			}))
+			defer site.Close()
+			content := fmt.Sprintf(`
+backends:
+  - name: site
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - pathPrefix: /app
+        backend: site
+        response:
+          - action: rewriteCSS
+          - action: rewriteJavaScript
+            patterns: ["/api/"]
+`, site.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+
+			_, body := serve(cfg, "", "/app/main.js", nil)
+			Expect(body).To(Equal(`fetch("/app/api/users")`))
+		})
+	})
+	Context("conditions", func() {
+		It("combines conditions", func() {
+			content := fmt.Sprintf(`
+backends:
+  - name: app
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - backend: app
+        request:
+          - action: setHeader
+            name: X-Step
+            value: applied
+            when:
+              method: GET, HEAD
+              any:
+                - pathGlob: /api/**
+                - query: debug
+              not:
+                header: X-Skip
+                match: "^(1|true)$"
+`, backend.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+
+			_, body := serve(cfg, "", "/api/v1/users", nil)
+			Expect(body).To(Equal("path=/api/v1/users header=applied"))
+			_, body = serve(cfg, "", "/ok?debug", nil)
+			Expect(body).To(Equal("path=/ok header=applied"))
+			_, body = serve(cfg, "", "/ok", nil)
+			Expect(body).To(Equal("path=/ok header="))
+			_, body = serve(cfg, "", "/api/v1/users", http.Header{"X-Skip": {"true"}})
+			Expect(body).To(Equal("path=/api/v1/users header="))
+		})
+		It("rejects invalid conditions", func() {
+			content := strings.Join([]string{
+				"listeners:",
+				"  - address: \":8080\"",
+				"    routes:",
+				"      - backend: app",
+				"        request:",
+				"          - action: setHeader",
+				"            name: X-Step",
+				"            value: x",
+				"            when:",
+				"              all:",
+				"                - clientCIDR: [10.0.0.0]",
+				"                - contentType: [text/html]",
+				"        response:",
+				"          - action: deleteHeader",
+				"            name: Server",
+				"            when:",
+				"              not:",
+				"                query: debug",
+			}, "\n")
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("proxy.yml:11:19: invalid network '10.0.0.0'"))
+			Expect(err.Error()).To(ContainSubstring("content type conditions are only supported for response steps"))
+			Expect(err.Error()).To(ContainSubstring("query conditions are only supported for request steps"))
+		})
+	})
+	Context("response headers", func() {
+		It("applies header steps to responses", func() {
+			content := fmt.Sprintf(`
+backends:
+  - name: app
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - backend: app
+        response:
+          - action: setHeader
+            name: X-Frame-Options
+            value: DENY
+          - action: copyHeader
+            source: X-Frame-Options
+            destination: X-Copied
+          - action: deleteHeader
+            name: Content-Type
+            when:
+              status: 200
+`, backend.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+			compiled, err := cfg.Compile()
+			Expect(err).To(BeNil())
+			frontend := httptest.NewServer(compiled.Servers[0].Handler)
+			defer frontend.Close()
+
+			res, err := http.Get(frontend.URL + "/ok")
+			Expect(err).To(BeNil())
+			res.Body.Close()
+			Expect(res.Header.Get("X-Frame-Options")).To(Equal("DENY"))
+			Expect(res.Header.Get("X-Copied")).To(Equal("DENY"))
+			Expect(res.Header).ToNot(HaveKey("Content-Type"))
+		})
+	})
+	Context("paths", func() {
+		It("maps paths with templates and maps redirects back", func() {
+			site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Location", "http://"+r.Host+r.URL.RequestURI())
+				w.WriteHeader(http.StatusFound)
+			}))
+			defer site.Close()
+			content := fmt.Sprintf(`
+backends:
+  - name: site
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - pathPrefix: /app
+        backend: site
+        request:
+          - action: rewritePath
+            match: ^/users/(\d+)/profile$
+            replace: /api/v2/profile?id=$1
+`, site.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+			compiled, err := cfg.Compile()
+			Expect(err).To(BeNil())
+			frontend := httptest.NewServer(compiled.Servers[0].Handler)
+			defer frontend.Close()
+
+			client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+			res, err := client.Get(frontend.URL + "/app/users/42/profile")
+			Expect(err).To(BeNil())
+			res.Body.Close()
+			Expect(res.Header.Get("Location")).To(Equal(frontend.URL + "/app/users/42/profile"))
+		})
+		It("rejects invalid patterns", func() {
+			content := "listeners:\n  - address: \":8080\"\n    routes:\n      - backend: app\n        request:\n          - action: rewritePath\n            match: (\n"
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("invalid regular expression"))
+		})
+	})
+	Context("json bodies", func() {
+		It("edits fields and maps links", func() {
+			site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/hal+json")
+				fmt.Fprintf(w, `{"_links": {"self": {"href": "http://%s/users/1"}}, "password": "x", "total": 1.50}`, r.Host)
+			}))
+			defer site.Close()
+			content := fmt.Sprintf(`
+backends:
+  - name: site
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - pathPrefix: /app
+        backend: site
+        response:
+          - action: deleteJSON
+            path: /password
+          - action: setJSON
+            path: $.version
+            value: "2"
+          - action: rewriteJSONURLs
+`, site.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+
+			_, body := serve(cfg, "example.com", "/app/users/1", nil)
+			Expect(body).To(Equal(`{"_links":{"self":{"href":"http://example.com/app/users/1"}},"total":1.50,"version":2}`))
+		})
+		It("rejects invalid paths and values", func() {
+			content := strings.Join([]string{
+				"listeners:",
+				"  - address: \":8080\"",
+				"    routes:",
+				"      - backend: app",
+				"        request:",
+				"          - action: deleteJSON",
+				"            path: password",
+				"          - action: setJSON",
+				"            path: /a",
+				"            value: \"{\"",
+			}, "\n")
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("json location 'password' must be a JSON Pointer"))
+			Expect(err.Error()).To(ContainSubstring("invalid json value '{'"))
+		})
+	})
+	Context("xml bodies", func() {
+		It("maps wsdl locations", func() {
+			site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/xml")
+				fmt.Fprintf(w, `<wsdl:port><soap:address location="http://%s/Service.svc"/></wsdl:port>`, r.Host)
+			}))
+			defer site.Close()
+			content := fmt.Sprintf(`
+backends:
+  - name: site
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - pathPrefix: /soap
+        backend: site
+        response:
+          - action: rewriteWSDL
+`, site.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+
+			_, body := serve(cfg, "example.com", "/soap/Service.svc", nil)
+			Expect(body).To(Equal(`<wsdl:port><soap:address location="http://example.com/soap/Service.svc"/></wsdl:port>`))
+		})
+		It("rejects invalid paths", func() {
+			content := "listeners:\n  - address: \":8080\"\n    routes:\n      - backend: app\n        response:\n          - action: replaceXML\n            path: $.a\n            match: x\n"
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("xml location '$.a' must start with /"))
+		})
+	})
+	Context("request body", func() {
+		It("limits the bodies the body rewrites buffer", func() {
+			content := fmt.Sprintf(`
+backends:
+  - name: app
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - backend: app
+        requestBody:
+          maxSize: 4
+`, backend.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+			compiled, err := cfg.Compile()
+			Expect(err).To(BeNil())
+			frontend := httptest.NewServer(compiled.Servers[0].Handler)
+			defer frontend.Close()
+
+			res, err := http.Post(frontend.URL+"/ok", "text/plain", strings.NewReader("larger"))
+			Expect(err).To(BeNil())
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+		})
+		It("answers failures with the error response", func() {
+			content := fmt.Sprintf(`
+backends:
+  - name: app
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - backend: app
+        requestBody:
+          maxSize: 4
+        errorResponse:
+          body: request rejected
+          headers:
+            Content-Type: text/plain
+`, backend.URL)
+			cfg, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).To(BeNil())
+			compiled, err := cfg.Compile()
+			Expect(err).To(BeNil())
+			frontend := httptest.NewServer(compiled.Servers[0].Handler)
+			defer frontend.Close()
+
+			res, err := http.Post(frontend.URL+"/ok", "text/plain", strings.NewReader("larger"))
+			Expect(err).To(BeNil())
+			defer res.Body.Close()
+			body, _ := ioutil.ReadAll(res.Body)
+			Expect(res.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+			Expect(res.Header.Get("Content-Type")).To(Equal("text/plain"))
+			Expect(string(body)).To(Equal("request rejected"))
+		})
+		It("rejects invalid error responses", func() {
+			content := "listeners:\n  - address: \":8080\"\n    routes:\n      - backend: app\n        errorResponse:\n          status: 42\n"
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("invalid status code 42"))
+		})
+		It("rejects invalid content types", func() {
+			content := "listeners:\n  - address: \":8080\"\n    routes:\n      - backend: app\n        contentTypes: [\"text\"]\n"
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("invalid content type 'text'"))
+		})
+		It("rejects unknown body encodings", func() {
+			content := "listeners:\n  - address: \":8080\"\n    routes:\n      - backend: app\n        bodyEncoding: zip\n"
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("unknown body encoding 'zip', expected reencode or decode"))
+		})
+		It("rejects negative sizes", func() {
+			content := "listeners:\n  - address: \":8080\"\n    routes:\n      - backend: app\n        requestBody:\n          maxSize: -1\n"
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("max size must not be negative"))
+		})
+	})
+	Context("json", func() {
+		It("compiles routes", func() {
+			content := fmt.Sprintf(`{
+	"backends": [{"name": "app", "url": "%s"}],
+	"listeners": [{
+		"address": ":8080",
+		"routes": [{"pathPrefix": "/", "backend": "app"}]
+	}]
+}`, backend.URL)
+			cfg, err := config.Parse("proxy.json", []byte(content))
+			Expect(err).To(BeNil())
+
+			status, body := serve(cfg, "", "/ok", nil)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("path=/ok header="))
+		})
+	})
+	Context("validation", func() {
+		It("reports errors with line numbers", func() {
+			content := strings.Join([]string{
+				"backends:",
+				"  - name: app",
+				"    url: /relative",
+				"listeners:",
+				"  - address: \":8080\"",
+				"    routes:",
+				"      - backend: missing",
+				"        request:",
+				"          - action: explode",
+				"          - action: replaceBody",
+				"            match: \"(\"",
+				"        colour: blue",
+			}, "\n")
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+
+			errs, ok := err.(config.ValidationErrors)
+			Expect(ok).To(BeTrue())
+			Expect(errs).To(HaveLen(5))
+			Expect(errs[0].Error()).To(Equal("proxy.yml:3:5: url '/relative' must be absolute"))
+			Expect(errs[1].Error()).To(Equal("proxy.yml:7:9: unknown backend 'missing'"))
+			Expect(errs[2].Error()).To(Equal("proxy.yml:9:13: unknown request action 'explode'"))
+			Expect(errs[3].Error()).To(ContainSubstring("proxy.yml:11:13: invalid regular expression"))
+			Expect(errs[4].Error()).To(Equal("proxy.yml:12:9: unknown field 'colour'"))
+		})
+		It("reports syntax errors with line numbers", func() {
+			content := "listeners:\n  - address: [\n"
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(HavePrefix("proxy.yml:"))
+		})
+		It("reports type errors with line numbers", func() {
+			content := "listeners:\n  - address: \":8080\"\n    notFound:\n      status: abc\n"
+			_, err := config.Parse("proxy.yml", []byte(content))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(HavePrefix("proxy.yml:4: "))
+		})
+	})
+})
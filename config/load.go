@@ -0,0 +1,581 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+	"gopkg.in/yaml.v3"
+)
+
+var yamlLineRegex = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// Load reads and validates the YAML or JSON configuration file at the path
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, data)
+}
+
+// Parse parses and validates YAML or JSON configuration, the file name is used in error messages
+func Parse(file string, data []byte) (*Config, error) {
+	config := &Config{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(config); err != nil && err != io.EOF {
+		return nil, parseError(file, err)
+	}
+
+	v := &validator{file: file, splits: map[string]bool{}}
+	v.validate(config)
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
+	return config, nil
+}
+
+// parseError converts yaml syntax and type errors into line numbered validation errors
+func parseError(file string, err error) error {
+	messages := []string{err.Error()}
+	if typeError, ok := err.(*yaml.TypeError); ok {
+		messages = typeError.Errors
+	}
+
+	errs := ValidationErrors{}
+	for _, message := range messages {
+		line := 0
+		if match := yamlLineRegex.FindStringSubmatch(message); match != nil {
+			line, _ = strconv.Atoi(match[1])
+			message = match[2]
+		}
+		errs = append(errs, &ValidationError{
+			File:    file,
+			Line:    line,
+			Message: strings.TrimPrefix(message, "yaml: "),
+		})
+	}
+	return errs
+}
+
+type validator struct {
+	file   string
+	errs   ValidationErrors
+	splits map[string]bool
+}
+
+func (v *validator) errorf(pos position, key string, format string, args ...interface{}) {
+	line, column := pos.at(key)
+	v.errs = append(v.errs, &ValidationError{
+		File:    v.file,
+		Line:    line,
+		Column:  column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) unknown(pos position) {
+	for _, key := range pos.unknown {
+		v.errs = append(v.errs, &ValidationError{
+			File:    v.file,
+			Line:    key.Line,
+			Column:  key.Column,
+			Message: fmt.Sprintf("unknown field '%s'", key.Value),
+		})
+	}
+}
+
+func (v *validator) validate(config *Config) {
+	v.unknown(config.pos)
+
+	backends := map[string]*Backend{}
+	for _, backend := range config.Backends {
+		v.validateBackend(backend, backends)
+	}
+
+	if len(config.Listeners) == 0 {
+		v.errorf(config.pos, "listeners", "at least one listener is required")
+	}
+	addresses := map[string]bool{}
+	for _, listener := range config.Listeners {
+		v.validateListener(listener, backends, addresses)
+	}
+	if config.Admin != nil {
+		v.validateAdmin(config.Admin, addresses)
+	}
+
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		if v.errs[i].Line == v.errs[j].Line {
+			return v.errs[i].Column < v.errs[j].Column
+		}
+		return v.errs[i].Line < v.errs[j].Line
+	})
+}
+
+func (v *validator) validateBackend(backend *Backend, backends map[string]*Backend) {
+	v.unknown(backend.pos)
+	if strings.TrimSpace(backend.Name) == "" {
+		v.errorf(backend.pos, "name", "backend name is required")
+	} else if _, ok := backends[backend.Name]; ok {
+		v.errorf(backend.pos, "name", "duplicate backend '%s'", backend.Name)
+	} else {
+		backends[backend.Name] = backend
+	}
+
+	if _, ok := strategies[backend.Strategy]; !ok {
+		v.errorf(backend.pos, "strategy", "unknown strategy '%s'", backend.Strategy)
+	}
+
+	switch {
+	case strings.TrimSpace(backend.URL) != "" && len(backend.Members) > 0:
+		v.errorf(backend.pos, "members", "backend may have a url or members but not both")
+	case strings.TrimSpace(backend.URL) != "":
+		v.validateURL(backend.pos, backend.URL)
+	case len(backend.Members) > 0:
+		for _, member := range backend.Members {
+			v.unknown(member.pos)
+			v.validateURL(member.pos, member.URL)
+			if member.Weight != nil && *member.Weight < 0 {
+				v.errorf(member.pos, "weight", "weight must not be negative")
+			}
+		}
+	default:
+		v.errorf(backend.pos, "url", "backend url or members are required")
+	}
+
+	if backend.HealthCheck != nil {
+		v.validateHealthCheck(backend.HealthCheck)
+	}
+
+	if backend.Strategy == "consistentHash" && backend.HashOn == nil {
+		v.errorf(backend.pos, "strategy", "strategy 'consistentHash' requires 'hashOn'")
+	}
+	if backend.HashOn != nil {
+		v.validateHashOn(backend)
+	}
+	if backend.AffinityCookie != nil {
+		v.validateAffinityCookie(backend.AffinityCookie)
+	}
+}
+
+func (v *validator) validateHashOn(backend *Backend) {
+	hashOn := backend.HashOn
+	v.unknown(hashOn.pos)
+	if backend.Strategy != "consistentHash" {
+		v.errorf(backend.pos, "hashOn", "hashOn requires strategy 'consistentHash'")
+	}
+	count := 0
+	for _, set := range []bool{hashOn.Header != "", hashOn.Cookie != "", hashOn.ClientIP} {
+		if set {
+			count++
+		}
+	}
+	if count != 1 {
+		v.errorf(hashOn.pos, "", "hashOn requires exactly one of 'header', 'cookie' or 'clientIP'")
+	}
+}
+
+func (v *validator) validateAffinityCookie(cookie *AffinityCookie) {
+	v.unknown(cookie.pos)
+	if cookie.Name != "" && !validCookieName(cookie.Name) {
+		v.errorf(cookie.pos, "name", "invalid cookie name '%s'", cookie.Name)
+	}
+	if cookie.Path != "" && !strings.HasPrefix(cookie.Path, "/") {
+		v.errorf(cookie.pos, "path", "cookie path '%s' must start with '/'", cookie.Path)
+	}
+	if cookie.MaxAge < 0 {
+		v.errorf(cookie.pos, "maxAge", "max age must not be negative")
+	}
+}
+
+// validCookieName reports whether the name is an http token
+func validCookieName(name string) bool {
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?={}", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *validator) validateHealthCheck(check *HealthCheck) {
+	v.unknown(check.pos)
+	if check.Path != "" && !strings.HasPrefix(check.Path, "/") {
+		v.errorf(check.pos, "path", "health check path '%s' must start with '/'", check.Path)
+	}
+	if check.ExpectedStatus != 0 && (check.ExpectedStatus < 100 || check.ExpectedStatus > 599) {
+		v.errorf(check.pos, "expectedStatus", "invalid status code %d", check.ExpectedStatus)
+	}
+	if check.Interval < 0 {
+		v.errorf(check.pos, "interval", "interval must not be negative")
+	}
+	if check.Timeout < 0 {
+		v.errorf(check.pos, "timeout", "timeout must not be negative")
+	}
+	if check.HealthyThreshold < 0 {
+		v.errorf(check.pos, "healthyThreshold", "healthy threshold must not be negative")
+	}
+	if check.UnhealthyThreshold < 0 {
+		v.errorf(check.pos, "unhealthyThreshold", "unhealthy threshold must not be negative")
+	}
+}
+
+func (v *validator) validateAdmin(admin *Admin, addresses map[string]bool) {
+	v.unknown(admin.pos)
+	if strings.TrimSpace(admin.Address) == "" {
+		v.errorf(admin.pos, "address", "admin address is required")
+	} else if addresses[admin.Address] {
+		v.errorf(admin.pos, "address", "admin address '%s' is already used by a listener", admin.Address)
+	}
+}
+
+func (v *validator) validateURL(pos position, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.errorf(pos, "url", "url is required")
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		v.errorf(pos, "url", "invalid url: %v", err)
+		return
+	}
+	if u.Scheme == "" || u.Host == "" {
+		v.errorf(pos, "url", "url '%s' must be absolute", value)
+	}
+}
+
+func (v *validator) validateListener(listener *Listener, backends map[string]*Backend, addresses map[string]bool) {
+	v.unknown(listener.pos)
+	if strings.TrimSpace(listener.Address) == "" {
+		v.errorf(listener.pos, "address", "listener address is required")
+	} else if addresses[listener.Address] {
+		v.errorf(listener.pos, "address", "duplicate listener address '%s'", listener.Address)
+	} else {
+		addresses[listener.Address] = true
+	}
+
+	if listener.NotFound != nil {
+		v.validateResponse(listener.NotFound)
+	}
+
+	if len(listener.Routes) == 0 {
+		v.errorf(listener.pos, "routes", "at least one route is required")
+	}
+	routes := map[string]bool{}
+	for _, route := range listener.Routes {
+		v.validateRoute(route, backends, routes)
+	}
+}
+
+func (v *validator) validateResponse(response *Response) {
+	v.unknown(response.pos)
+	if response.Status != 0 && (response.Status < 100 || response.Status > 599) {
+		v.errorf(response.pos, "status", "invalid status code %d", response.Status)
+	}
+}
+
+func (v *validator) validateRoute(route *Route, backends map[string]*Backend, routes map[string]bool) {
+	v.unknown(route.pos)
+
+	host := strings.ToLower(strings.TrimSpace(route.Host))
+	if strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+		v.errorf(route.pos, "host", "host '%s' may only contain a leading '*.' wildcard", route.Host)
+	}
+	if route.PathPrefix != "" && !strings.HasPrefix(route.PathPrefix, "/") {
+		v.errorf(route.pos, "pathPrefix", "path prefix '%s' must start with '/'", route.PathPrefix)
+	}
+	key := host + strings.TrimSuffix(route.PathPrefix, "/")
+	if routes[key] {
+		v.errorf(route.pos, "pathPrefix", "duplicate route for host '%s' and path prefix '%s'", route.Host, route.PathPrefix)
+	}
+	routes[key] = true
+
+	if strings.TrimSpace(route.Backend) == "" {
+		v.errorf(route.pos, "backend", "route backend is required")
+	} else if _, ok := backends[route.Backend]; !ok {
+		v.errorf(route.pos, "backend", "unknown backend '%s'", route.Backend)
+	}
+
+	if route.CircuitBreaker != nil {
+		v.validateCircuitBreaker(route.CircuitBreaker)
+	}
+	if route.Retry != nil {
+		v.validateRetry(route.Retry)
+	}
+
+	if route.Mirror != nil {
+		v.validateMirror(route.Mirror)
+	}
+	if route.RequestBody != nil {
+		v.validateRequestBody(route.RequestBody)
+	}
+	if route.ErrorResponse != nil {
+		v.validateResponse(route.ErrorResponse)
+	}
+	if _, ok := bodyEncodings[route.BodyEncoding]; !ok {
+		v.errorf(route.pos, "bodyEncoding", "unknown body encoding '%s', expected reencode or decode", route.BodyEncoding)
+	}
+	for _, contentType := range route.ContentTypes {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil || !strings.Contains(contentType, "/") {
+			v.errorf(route.pos, "contentTypes", "invalid content type '%s'", contentType)
+		}
+	}
+
+	v.validateSteps(route.Request, route.Response)
+
+	if route.Split != nil {
+		v.validateSplit(route.Split, backends)
+	}
+}
+
+func (v *validator) validateSteps(request []*Step, response []*Step) {
+	for _, step := range request {
+		v.unknown(step.pos)
+		definition, ok := requestSteps[step.Action]
+		if !ok {
+			v.errorf(step.pos, "action", "unknown request action '%s'", step.Action)
+			continue
+		}
+		v.validateStep(step, definition.required, definition.patterns, definition.jsonValues, definition.location, definition.conditional)
+		if step.When != nil {
+			v.validateRequestCondition(step.When)
+		}
+	}
+
+	for _, step := range response {
+		v.unknown(step.pos)
+		definition, ok := responseSteps[step.Action]
+		if !ok {
+			v.errorf(step.pos, "action", "unknown response action '%s'", step.Action)
+			continue
+		}
+		v.validateStep(step, definition.required, definition.patterns, definition.jsonValues, definition.location, definition.conditional)
+		if step.When != nil {
+			v.validateResponseCondition(step.When)
+		}
+	}
+}
+
+func (v *validator) validateRequestCondition(condition *Condition) {
+	v.validateCondition(condition)
+	if condition.Status != 0 || condition.MinStatus != 0 || condition.MaxStatus != 0 {
+		v.errorf(condition.pos, "status", "status conditions are only supported for response steps")
+	}
+	if len(condition.ContentType) > 0 {
+		v.errorf(condition.pos, "contentType", "content type conditions are only supported for response steps")
+	}
+	if _, err := proxies.PathRegex(condition.PathRegex); err != nil {
+		v.errorf(condition.pos, "pathRegex", "invalid regular expression: %v", err)
+	}
+	if _, err := proxies.PathGlob(condition.PathGlob); err != nil {
+		v.errorf(condition.pos, "pathGlob", "invalid glob: %v", err)
+	}
+	for _, cidr := range condition.ClientCIDR {
+		if _, err := proxies.ClientCIDR(cidr); err != nil {
+			v.errorf(condition.pos, "clientCIDR", "invalid network '%s'", cidr)
+		}
+	}
+	for _, nested := range condition.nested() {
+		v.validateRequestCondition(nested)
+	}
+}
+
+func (v *validator) validateResponseCondition(condition *Condition) {
+	v.validateCondition(condition)
+	if condition.PathPrefix != "" || condition.PathRegex != "" || condition.PathGlob != "" {
+		v.errorf(condition.pos, "pathPrefix", "path conditions are only supported for request steps")
+	}
+	if condition.Method != "" {
+		v.errorf(condition.pos, "method", "method conditions are only supported for request steps")
+	}
+	if condition.Cookie != "" {
+		v.errorf(condition.pos, "cookie", "cookie conditions are only supported for request steps")
+	}
+	if condition.Query != "" {
+		v.errorf(condition.pos, "query", "query conditions are only supported for request steps")
+	}
+	if len(condition.ClientCIDR) > 0 {
+		v.errorf(condition.pos, "clientCIDR", "client conditions are only supported for request steps")
+	}
+	if condition.MaxStatus != 0 && condition.MinStatus > condition.MaxStatus {
+		v.errorf(condition.pos, "minStatus", "min status %d is larger than max status %d", condition.MinStatus, condition.MaxStatus)
+	}
+	for _, contentType := range condition.ContentType {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil || !strings.Contains(contentType, "/") {
+			v.errorf(condition.pos, "contentType", "invalid content type '%s'", contentType)
+		}
+	}
+	for _, nested := range condition.nested() {
+		v.validateResponseCondition(nested)
+	}
+}
+
+func (v *validator) validateCondition(condition *Condition) {
+	v.unknown(condition.pos)
+	sources := 0
+	for _, source := range []string{condition.Header, condition.Cookie, condition.Query} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		v.errorf(condition.pos, "cookie", "conditions may have one of a header, a cookie or a query parameter")
+	}
+	if condition.Value != "" && sources == 0 {
+		v.errorf(condition.pos, "value", "value conditions require a header, a cookie or a query parameter")
+	}
+	if condition.Match != "" {
+		if condition.Header == "" {
+			v.errorf(condition.pos, "match", "match conditions require a header")
+		}
+		if _, err := proxies.HeaderRegex(condition.Header, condition.Match); err != nil {
+			v.errorf(condition.pos, "match", "invalid regular expression: %v", err)
+		}
+	}
+}
+
+// nested returns the conditions combined by all, any and not
+func (c *Condition) nested() []*Condition {
+	nested := append([]*Condition{}, c.All...)
+	nested = append(nested, c.Any...)
+	if c.Not != nil {
+		nested = append(nested, c.Not)
+	}
+	return nested
+}
+
+func (v *validator) validateSplit(split *Split, backends map[string]*Backend) {
+	v.unknown(split.pos)
+	if strings.TrimSpace(split.Name) == "" {
+		v.errorf(split.pos, "name", "split name is required")
+	} else if v.splits[split.Name] {
+		v.errorf(split.pos, "name", "duplicate split '%s'", split.Name)
+	} else {
+		v.splits[split.Name] = true
+	}
+
+	if len(split.Variants) == 0 {
+		v.errorf(split.pos, "variants", "at least one variant is required")
+	}
+	names := map[string]bool{split.defaultName(): true}
+	total := 0
+	for _, variant := range split.Variants {
+		v.unknown(variant.pos)
+		if strings.TrimSpace(variant.Name) == "" {
+			v.errorf(variant.pos, "name", "variant name is required")
+		} else if names[variant.Name] {
+			v.errorf(variant.pos, "name", "duplicate variant '%s'", variant.Name)
+		} else {
+			names[variant.Name] = true
+		}
+
+		if strings.TrimSpace(variant.Backend) == "" {
+			v.errorf(variant.pos, "backend", "variant backend is required")
+		} else if _, ok := backends[variant.Backend]; !ok {
+			v.errorf(variant.pos, "backend", "unknown backend '%s'", variant.Backend)
+		}
+
+		if variant.Weight < 0 || variant.Weight > 100 {
+			v.errorf(variant.pos, "weight", "weight must be a percentage between 0 and 100")
+		}
+		total += variant.Weight
+
+		if variant.When != nil {
+			v.validateRequestCondition(variant.When)
+		}
+		v.validateSteps(variant.Request, variant.Response)
+	}
+	if total > 100 {
+		v.errorf(split.pos, "variants", "variant weights add up to %d, more than 100 percent", total)
+	}
+}
+
+func (v *validator) validateCircuitBreaker(breaker *CircuitBreaker) {
+	v.unknown(breaker.pos)
+	if breaker.ConsecutiveFailures < 0 {
+		v.errorf(breaker.pos, "consecutiveFailures", "consecutive failures must not be negative")
+	}
+	if breaker.Cooldown < 0 {
+		v.errorf(breaker.pos, "cooldown", "cooldown must not be negative")
+	}
+	if breaker.HalfOpenRequests < 0 {
+		v.errorf(breaker.pos, "halfOpenRequests", "half open requests must not be negative")
+	}
+}
+
+func (v *validator) validateMirror(mirror *Mirror) {
+	v.unknown(mirror.pos)
+	v.validateURL(mirror.pos, mirror.URL)
+	if mirror.Percentage != nil && (*mirror.Percentage < 0 || *mirror.Percentage > 100) {
+		v.errorf(mirror.pos, "percentage", "percentage must be between 0 and 100")
+	}
+	if mirror.MaxBodySize < 0 {
+		v.errorf(mirror.pos, "maxBodySize", "max body size must not be negative")
+	}
+}
+
+func (v *validator) validateRequestBody(body *RequestBody) {
+	v.unknown(body.pos)
+	if body.MaxSize < 0 {
+		v.errorf(body.pos, "maxSize", "max size must not be negative")
+	}
+}
+
+func (v *validator) validateRetry(retry *Retry) {
+	v.unknown(retry.pos)
+	if retry.MaxAttempts < 0 {
+		v.errorf(retry.pos, "maxAttempts", "max attempts must not be negative")
+	}
+	if retry.PerTryTimeout < 0 {
+		v.errorf(retry.pos, "perTryTimeout", "per try timeout must not be negative")
+	}
+	if retry.Backoff < 0 {
+		v.errorf(retry.pos, "backoff", "backoff must not be negative")
+	}
+	if retry.MaxBackoff < 0 {
+		v.errorf(retry.pos, "maxBackoff", "max backoff must not be negative")
+	}
+	for _, status := range retry.RetryOn {
+		if status < 100 || status > 599 {
+			v.errorf(retry.pos, "retryOn", "invalid status code %d", status)
+		}
+	}
+}
+
+func (v *validator) validateStep(step *Step, required []string, patterns []string, jsonValues []string, location func(path string) error, conditional bool) {
+	for _, key := range required {
+		if step.field(key) == "" {
+			v.errorf(step.pos, "action", "action '%s' requires '%s'", step.Action, key)
+		}
+	}
+	for _, key := range patterns {
+		if _, err := regexp.Compile(step.field(key)); err != nil {
+			v.errorf(step.pos, key, "invalid regular expression: %v", err)
+		}
+	}
+	for _, key := range jsonValues {
+		if !json.Valid([]byte(step.field(key))) {
+			v.errorf(step.pos, key, "invalid json value '%s'", step.field(key))
+		}
+	}
+	if location != nil && step.Path != "" {
+		if err := location(step.Path); err != nil {
+			v.errorf(step.pos, "path", "%v", err)
+		}
+	}
+	if step.When == nil {
+		return
+	}
+	if !conditional {
+		v.errorf(step.pos, "when", "action '%s' does not support conditions", step.Action)
+	}
+}
@@ -0,0 +1,209 @@
+package config
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+)
+
+// Reloader serves the listeners of a configuration file and swaps their handlers when the configuration
+// changes. Requests in flight finish on the handler they started with and invalid configuration is rejected
+// keeping the current handlers
+type Reloader interface {
+	Reload() error
+	Watch(interval time.Duration, stop <-chan struct{})
+	Handler(address string) http.Handler
+	ListenAndServe() error
+}
+
+type reloader struct {
+	path     string
+	lock     sync.Mutex
+	handlers map[string]proxies.AtomicHandler
+	servers  map[string]*http.Server
+	compiled *Compiled
+	errs     chan error
+	serving  bool
+	modTime  time.Time
+	size     int64
+}
+
+func (r *reloader) Reload() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// stat before loading so a change during the load triggers another reload, a rejected
+	// configuration is remembered so it is not reloaded until the file changes again
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+
+	cfg, err := Load(r.path)
+	if err != nil {
+		return err
+	}
+
+	compiled, err := cfg.Compile()
+	if err != nil {
+		return err
+	}
+
+	// listeners the configuration adds are bound before traffic is swapped so an address that is in use
+	// keeps the current configuration
+	listeners := map[string]net.Listener{}
+	if r.serving {
+		for _, server := range compiled.Servers {
+			if _, ok := r.handlers[server.Address]; ok {
+				continue
+			}
+			listener, err := net.Listen("tcp", server.Address)
+			if err != nil {
+				for _, bound := range listeners {
+					bound.Close()
+				}
+				return err
+			}
+			listeners[server.Address] = listener
+		}
+	}
+
+	// the new pools are checked before traffic is swapped to them, the old checkers stop afterwards
+	compiled.Start()
+	if r.compiled != nil {
+		defer r.compiled.Stop()
+	}
+	r.compiled = compiled
+
+	addresses := map[string]bool{}
+	for _, server := range compiled.Servers {
+		addresses[server.Address] = true
+		if handler, ok := r.handlers[server.Address]; ok {
+			handler.Swap(server.Handler)
+			continue
+		}
+		r.handlers[server.Address] = proxies.NewAtomicHandler(server.Handler)
+		if listener, ok := listeners[server.Address]; ok {
+			r.serve(server.Address, listener)
+		}
+	}
+
+	for address := range r.handlers {
+		if addresses[address] {
+			continue
+		}
+		delete(r.handlers, address)
+		if server, ok := r.servers[address]; ok {
+			delete(r.servers, address)
+			go shutdown(server)
+		}
+	}
+	return nil
+}
+
+func (r *reloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if !r.changed() {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			log.Printf("unable to reload configuration '%s', keeping the current configuration: %v", r.path, err)
+			continue
+		}
+		log.Printf("reloaded configuration '%s'", r.path)
+	}
+}
+
+func (r *reloader) changed() bool {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return !info.ModTime().Equal(r.modTime) || info.Size() != r.size
+}
+
+func (r *reloader) Handler(address string) http.Handler {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	handler, ok := r.handlers[address]
+	if !ok {
+		return nil
+	}
+	return handler
+}
+
+func (r *reloader) ListenAndServe() error {
+	r.lock.Lock()
+	r.serving = true
+	for address := range r.handlers {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			r.lock.Unlock()
+			return err
+		}
+		r.serve(address, listener)
+	}
+	r.lock.Unlock()
+
+	return <-r.errs
+}
+
+// serve starts a server for the address on the bound listener, the caller must hold the lock
+func (r *reloader) serve(address string, listener net.Listener) {
+	server := &http.Server{
+		Addr:    address,
+		Handler: r.handlers[address],
+	}
+	r.servers[address] = server
+	go func() {
+		log.Printf("listening on %s", address)
+		err := server.Serve(listener)
+		if err == http.ErrServerClosed {
+			return
+		}
+		select {
+		case r.errs <- err:
+		default:
+		}
+	}()
+}
+
+func shutdown(server *http.Server) {
+	log.Printf("stopping listener on %s", server.Addr)
+	if err := server.Shutdown(context.Background()); err != nil {
+		log.Printf("unable to stop listener on %s: %v", server.Addr, err)
+	}
+}
+
+// NewReloader creates a reloader and loads the initial configuration from the path
+func NewReloader(path string) (Reloader, error) {
+	r := &reloader{
+		path:     path,
+		handlers: map[string]proxies.AtomicHandler{},
+		servers:  map[string]*http.Server{},
+		errs:     make(chan error, 1),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,155 @@
+package config_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/patrickhuber/go-reverse-proxy/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reloader", func() {
+	var (
+		directory string
+		path      string
+		backend   *httptest.Server
+		frontend  *httptest.Server
+		reloader  config.Reloader
+		block     chan struct{}
+		received  chan struct{}
+	)
+	write := func(value string) {
+		content := fmt.Sprintf(`
+backends:
+  - name: app
+    url: %s
+listeners:
+  - address: ":8080"
+    routes:
+      - backend: app
+        request:
+          - action: setHeader
+            name: X-Step
+            value: %s
+`, backend.URL, value)
+		Expect(ioutil.WriteFile(path, []byte(content), 0644)).To(Succeed())
+	}
+	get := func(path string) string {
+		res, err := http.Get(frontend.URL + path)
+		Expect(err).To(BeNil())
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).To(BeNil())
+		return string(body)
+	}
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "reloader")
+		Expect(err).To(BeNil())
+		path = filepath.Join(directory, "proxy.yml")
+
+		block = make(chan struct{})
+		received = make(chan struct{}, 1)
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/block" {
+				received <- struct{}{}
+				<-block
+			}
+			fmt.Fprint(w, r.Header.Get("X-Step"))
+		}))
+
+		write("one")
+		reloader, err = config.NewReloader(path)
+		Expect(err).To(BeNil())
+		frontend = httptest.NewServer(reloader.Handler(":8080"))
+	})
+	AfterEach(func() {
+		frontend.Close()
+		backend.Close()
+		os.RemoveAll(directory)
+	})
+	It("swaps the pipeline on reload", func() {
+		Expect(get("/ok")).To(Equal("one"))
+
+		write("two")
+		Expect(reloader.Reload()).To(Succeed())
+		Expect(get("/ok")).To(Equal("two"))
+	})
+	It("keeps the current pipeline when the configuration is invalid", func() {
+		Expect(ioutil.WriteFile(path, []byte("listeners: []"), 0644)).To(Succeed())
+		Expect(reloader.Reload()).ToNot(Succeed())
+		Expect(get("/ok")).To(Equal("one"))
+	})
+	It("finishes in flight requests on the previous pipeline", func() {
+		done := make(chan string)
+		go func() {
+			defer GinkgoRecover()
+			done <- get("/block")
+		}()
+		Eventually(received).Should(Receive())
+
+		write("two")
+		Expect(reloader.Reload()).To(Succeed())
+		close(block)
+
+		Eventually(done).Should(Receive(Equal("one")))
+		Expect(get("/ok")).To(Equal("two"))
+	})
+	It("keeps serving the current configuration when a new listener can not be bound", func() {
+		free, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		address := free.Addr().String()
+		free.Close()
+		taken, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		defer taken.Close()
+
+		listeners := func(addresses ...string) {
+			content := fmt.Sprintf("backends:\n  - name: app\n    url: %s\nlisteners:\n", backend.URL)
+			for _, listener := range addresses {
+				content += fmt.Sprintf("  - address: %q\n    routes:\n      - backend: app\n", listener)
+			}
+			Expect(ioutil.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		}
+		listeners(address)
+		reloader, err = config.NewReloader(path)
+		Expect(err).To(BeNil())
+		served := make(chan error, 1)
+		go func() {
+			served <- reloader.ListenAndServe()
+		}()
+		Eventually(func() error {
+			res, err := http.Get("http://" + address + "/ok")
+			if err == nil {
+				res.Body.Close()
+			}
+			return err
+		}).Should(Succeed())
+
+		listeners(address, taken.Addr().String())
+		Expect(reloader.Reload()).ToNot(Succeed())
+		Consistently(served, 100*time.Millisecond).ShouldNot(Receive())
+		res, err := http.Get("http://" + address + "/ok")
+		Expect(err).To(BeNil())
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(reloader.Handler(taken.Addr().String())).To(BeNil())
+	})
+	It("reloads when the file changes", func() {
+		stop := make(chan struct{})
+		defer close(stop)
+		go reloader.Watch(10*time.Millisecond, stop)
+
+		write("three")
+		Eventually(func() string { return get("/ok") }).Should(Equal("three"))
+	})
+})
@@ -0,0 +1,431 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+)
+
+// target is the backend pool and frontend path prefix a step rewrites between
+type target struct {
+	pool       proxies.BackendPool
+	pathPrefix string
+}
+
+type requestStep struct {
+	required    []string
+	patterns    []string
+	jsonValues  []string
+	location    func(path string) error
+	conditional bool
+	apply       func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder
+}
+
+type responseStep struct {
+	required    []string
+	patterns    []string
+	jsonValues  []string
+	location    func(path string) error
+	conditional bool
+	apply       func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder
+}
+
+var requestSteps = map[string]*requestStep{
+	"addHeader": {
+		required:    []string{"name", "value"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.AddRequestHeaderIf(step.Name, step.Value, condition)
+		},
+	},
+	"setHeader": {
+		required:    []string{"name", "value"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.SetRequestHeaderIf(step.Name, step.Value, condition)
+		},
+	},
+	"copyHeader": {
+		required:    []string{"source", "destination"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.CopyRequestHeaderIf(step.Source, step.Destination, condition)
+		},
+	},
+	"deleteHeader": {
+		required:    []string{"name"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.DeleteRequestHeaderIf(step.Name, condition)
+		},
+	},
+	"replaceHeader": {
+		required:    []string{"name", "match"},
+		patterns:    []string{"match"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.ReplaceRequestHeaderIf(step.Name, step.Match, step.Replace, condition)
+		},
+	},
+	"replaceHeaderValue": {
+		required:    []string{"name", "match"},
+		patterns:    []string{"match"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.ReplaceRequestHeaderValueIf(step.Name, step.Match, step.Replace, condition)
+		},
+	},
+	"replaceBody": {
+		required:    []string{"match"},
+		patterns:    []string{"match"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.ReplaceRequestBodyIf(step.Match, step.Replace, condition)
+		},
+	},
+	"replaceFormField": {
+		required:    []string{"name", "match"},
+		patterns:    []string{"match"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.ReplaceRequestFormFieldIf(step.Name, step.Match, step.Replace, condition)
+		},
+	},
+	"rewriteHost": {
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.RewriteHostPool(target.pool, target.pathPrefix)
+		},
+	},
+	"rewritePath": {
+		required: []string{"match"},
+		patterns: []string{"match"},
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.RewritePath(step.Match, step.Replace)
+		},
+	},
+	"rewriteCookies": {
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.RewriteRequestCookiesPool(target.pool, target.pathPrefix)
+		},
+	},
+	"rewriteBody": {
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.RewriteRequestBodyPool(target.pool, target.pathPrefix)
+		},
+	},
+	"setJSON": {
+		required:   []string{"path", "value"},
+		jsonValues: []string{"value"},
+		location:   proxies.ValidateJSONPath,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.SetRequestJSON(step.Path, step.Value)
+		},
+	},
+	"deleteJSON": {
+		required: []string{"path"},
+		location: proxies.ValidateJSONPath,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.DeleteRequestJSON(step.Path)
+		},
+	},
+	"renameJSON": {
+		required: []string{"path", "name"},
+		location: proxies.ValidateJSONPath,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.RenameRequestJSON(step.Path, step.Name)
+		},
+	},
+	"replaceJSON": {
+		required: []string{"path", "match"},
+		patterns: []string{"match"},
+		location: proxies.ValidateJSONPath,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.ReplaceRequestJSON(step.Path, step.Match, step.Replace)
+		},
+	},
+	"replaceXML": {
+		required: []string{"path", "match"},
+		patterns: []string{"match"},
+		location: proxies.ValidateXMLPath,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.RequestCondition) proxies.ReverseProxyBuilder {
+			return builder.ReplaceRequestXML(step.Path, step.Match, step.Replace)
+		},
+	},
+}
+
+var responseSteps = map[string]*responseStep{
+	"addHeader": {
+		required:    []string{"name", "value"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.AddResponseHeaderIf(step.Name, step.Value, condition)
+		},
+	},
+	"setHeader": {
+		required:    []string{"name", "value"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.SetResponseHeaderIf(step.Name, step.Value, condition)
+		},
+	},
+	"copyHeader": {
+		required:    []string{"source", "destination"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.CopyResponseHeaderIf(step.Source, step.Destination, condition)
+		},
+	},
+	"deleteHeader": {
+		required:    []string{"name"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.DeleteResponseHeaderIf(step.Name, condition)
+		},
+	},
+	"replaceHeader": {
+		required:    []string{"name", "match"},
+		patterns:    []string{"match"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.ReplaceResponseHeaderIf(step.Name, step.Match, step.Replace, condition)
+		},
+	},
+	"replaceHeaderValue": {
+		required:    []string{"name", "match"},
+		patterns:    []string{"match"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.ReplaceResponseHeaderValueIf(step.Name, step.Match, step.Replace, condition)
+		},
+	},
+	"replaceBody": {
+		required:    []string{"match"},
+		patterns:    []string{"match"},
+		conditional: true,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.ReplaceResponseBodyIf(step.Match, step.Replace, condition)
+		},
+	},
+	"rewriteRedirect": {
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.RewriteRedirectPool(target.pool, target.pathPrefix)
+		},
+	},
+	"rewriteCookies": {
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.RewriteResponseCookiesPool(target.pool, target.pathPrefix)
+		},
+	},
+	"rewriteBody": {
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.RewriteResponseBodyPool(target.pool, target.pathPrefix)
+		},
+	},
+	"rewriteHTML": {
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.RewriteHTMLPool(target.pool, target.pathPrefix, proxies.HTMLOptions{Scripts: step.Scripts, Text: step.Text})
+		},
+	},
+	"rewriteCSS": {
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.RewriteCSSPool(target.pool, target.pathPrefix)
+		},
+	},
+	"rewriteJavaScript": {
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.RewriteJavaScriptPool(target.pool, target.pathPrefix, step.Patterns...)
+		},
+	},
+	"setJSON": {
+		required:   []string{"path", "value"},
+		jsonValues: []string{"value"},
+		location:   proxies.ValidateJSONPath,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.SetResponseJSON(step.Path, step.Value)
+		},
+	},
+	"deleteJSON": {
+		required: []string{"path"},
+		location: proxies.ValidateJSONPath,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.DeleteResponseJSON(step.Path)
+		},
+	},
+	"renameJSON": {
+		required: []string{"path", "name"},
+		location: proxies.ValidateJSONPath,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.RenameResponseJSON(step.Path, step.Name)
+		},
+	},
+	"replaceJSON": {
+		required: []string{"path", "match"},
+		patterns: []string{"match"},
+		location: proxies.ValidateJSONPath,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.ReplaceResponseJSON(step.Path, step.Match, step.Replace)
+		},
+	},
+	"rewriteJSONURLs": {
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.RewriteJSONURLsPool(target.pool, target.pathPrefix)
+		},
+	},
+	"replaceXML": {
+		required: []string{"path", "match"},
+		patterns: []string{"match"},
+		location: proxies.ValidateXMLPath,
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.ReplaceResponseXML(step.Path, step.Match, step.Replace)
+		},
+	},
+	"rewriteWSDL": {
+		apply: func(builder proxies.ReverseProxyBuilder, step *Step, target *target, condition proxies.ResponseCondition) proxies.ReverseProxyBuilder {
+			return builder.RewriteWSDLPool(target.pool, target.pathPrefix)
+		},
+	},
+}
+
+// field returns the value of the step parameter with the given key
+func (s *Step) field(key string) string {
+	switch key {
+	case "name":
+		return s.Name
+	case "value":
+		return s.Value
+	case "source":
+		return s.Source
+	case "destination":
+		return s.Destination
+	case "match":
+		return s.Match
+	case "replace":
+		return s.Replace
+	case "path":
+		return s.Path
+	}
+	return ""
+}
+
+func (c *Condition) requestCondition() (proxies.RequestCondition, error) {
+	if c == nil {
+		return proxies.AllRequests(), nil
+	}
+	conditions := []proxies.RequestCondition{}
+	if c.PathPrefix != "" {
+		conditions = append(conditions, proxies.PathPrefix(c.PathPrefix))
+	}
+	if c.PathRegex != "" {
+		condition, err := proxies.PathRegex(c.PathRegex)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	if c.PathGlob != "" {
+		condition, err := proxies.PathGlob(c.PathGlob)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	if c.Method != "" {
+		conditions = append(conditions, proxies.Method(c.methods()...))
+	}
+	switch {
+	case c.Cookie != "":
+		conditions = append(conditions, proxies.Cookie(c.Cookie, c.Value))
+	case c.Query != "":
+		conditions = append(conditions, proxies.QueryParam(c.Query, c.Value))
+	case c.Header != "":
+		condition, err := c.headerCondition()
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition.Request())
+	}
+	if len(c.ClientCIDR) > 0 {
+		condition, err := proxies.ClientCIDR(c.ClientCIDR...)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	nested := []proxies.RequestCondition{}
+	for _, n := range c.nested() {
+		condition, err := n.requestCondition()
+		if err != nil {
+			return nil, err
+		}
+		nested = append(nested, condition)
+	}
+	conditions = append(conditions, nested[:len(c.All)]...)
+	if len(c.Any) > 0 {
+		any := nested[len(c.All) : len(c.All)+len(c.Any)]
+		conditions = append(conditions, any[0].Or(any[1:]...))
+	}
+	if c.Not != nil {
+		conditions = append(conditions, nested[len(nested)-1].Not())
+	}
+	return proxies.AllRequests().And(conditions...), nil
+}
+
+func (c *Condition) responseCondition() (proxies.ResponseCondition, error) {
+	if c == nil {
+		return proxies.AllResponses(), nil
+	}
+	conditions := []proxies.ResponseCondition{}
+	if c.Status != 0 {
+		conditions = append(conditions, proxies.StatusRange(c.Status, c.Status))
+	}
+	if c.MinStatus != 0 || c.MaxStatus != 0 {
+		maxStatus := c.MaxStatus
+		if maxStatus == 0 {
+			maxStatus = 999
+		}
+		conditions = append(conditions, proxies.StatusRange(c.MinStatus, maxStatus))
+	}
+	if len(c.ContentType) > 0 {
+		conditions = append(conditions, proxies.ContentType(c.ContentType...))
+	}
+	if c.Header != "" {
+		condition, err := c.headerCondition()
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition.Response())
+	}
+	nested := []proxies.ResponseCondition{}
+	for _, n := range c.nested() {
+		condition, err := n.responseCondition()
+		if err != nil {
+			return nil, err
+		}
+		nested = append(nested, condition)
+	}
+	conditions = append(conditions, nested[:len(c.All)]...)
+	if len(c.Any) > 0 {
+		any := nested[len(c.All) : len(c.All)+len(c.Any)]
+		conditions = append(conditions, any[0].Or(any[1:]...))
+	}
+	if c.Not != nil {
+		conditions = append(conditions, nested[len(nested)-1].Not())
+	}
+	return proxies.AllResponses().And(conditions...), nil
+}
+
+func (c *Condition) headerCondition() (proxies.HeaderCondition, error) {
+	if c.Match != "" {
+		return proxies.HeaderRegex(c.Header, c.Match)
+	}
+	return proxies.HeaderEquals(c.Header, c.Value), nil
+}
+
+// methods returns the comma separated methods of the condition
+func (c *Condition) methods() []string {
+	methods := []string{}
+	for _, method := range strings.Split(c.Method, ",") {
+		methods = append(methods, strings.TrimSpace(method))
+	}
+	return methods
+}
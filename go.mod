@@ -1,8 +1,22 @@
 module github.com/patrickhuber/go-reverse-proxy
 
+go 1.18
+
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/gorilla/mux v1.7.0
 	github.com/onsi/ginkgo v1.7.0
 	github.com/onsi/gomega v1.4.3
 	github.com/urfave/cli v1.20.0
+	golang.org/x/net v0.33.0
+	golang.org/x/text v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/hpcloud/tail v1.0.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	gopkg.in/fsnotify.v1 v1.4.7 // indirect
+	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	gopkg.in/yaml.v2 v2.2.1 // indirect
 )
@@ -7,20 +7,36 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/patrickhuber/go-reverse-proxy/config"
 	"github.com/patrickhuber/go-reverse-proxy/proxies"
 
 	"github.com/urfave/cli"
 )
 
 const (
-	DefaultPort = "8080"
+	DefaultPort           = "8080"
+	DefaultReloadInterval = 2 * time.Second
 )
 
 func main() {
 	app := cli.App{
 		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "config, c",
+				EnvVar: "CONFIG",
+				Usage:  "yaml or json configuration file, the other flags are ignored when set",
+			},
+			cli.DurationFlag{
+				Name:   "reload-interval",
+				EnvVar: "RELOAD_INTERVAL",
+				Value:  DefaultReloadInterval,
+				Usage:  "how often the configuration file is checked for changes, 0 disables watching",
+			},
 			cli.StringFlag{
 				Name:   "port, p",
 				EnvVar: "PORT",
@@ -34,6 +50,16 @@ func main() {
 				Name:   "path-prefix, x",
 				EnvVar: "PATH_PREFIX",
 			},
+			cli.StringSliceFlag{
+				Name:   "route, r",
+				EnvVar: "ROUTES",
+				Usage:  "path prefix and forwarded url pair in the form '/prefix=https://backend'",
+			},
+			cli.StringSliceFlag{
+				Name:   "virtual-host, H",
+				EnvVar: "VIRTUAL_HOSTS",
+				Usage:  "host and forwarded url pair in the form 'www.example.com=https://backend', the host may be a wildcard like '*.example.com'",
+			},
 			cli.BoolFlag{
 				Name:   "skip-ssl-validation, k",
 				EnvVar: "SKIP_SSL_VALIDATION",
@@ -48,6 +74,11 @@ func main() {
 			},
 		},
 		Action: func(c *cli.Context) error {
+			configPath := c.String("config")
+			if strings.TrimSpace(configPath) != "" {
+				return serveConfig(configPath, c.Duration("reload-interval"))
+			}
+
 			port := c.String("port")
 			forwardedURL := c.String("forwarded-url")
 			skipTLSValidation := c.Bool("skip-ssl-validation")
@@ -55,31 +86,78 @@ func main() {
 			xForwardedPathHeader := c.String("x-forwarded-path-header")
 			pathPrefix := c.String("path-prefix")
 
-			if strings.TrimSpace(forwardedURL) == "" {
-				return fmt.Errorf("forwarded-url argument is required")
+			routes := c.StringSlice("route")
+			virtualHosts := c.StringSlice("virtual-host")
+
+			if strings.TrimSpace(forwardedURL) == "" && len(routes) == 0 && len(virtualHosts) == 0 {
+				return fmt.Errorf("forwarded-url, route or virtual-host argument is required")
+			}
+
+			configure := func(builder proxies.ReverseProxyBuilder, url *url.URL, pathPrefix string) proxies.ReverseProxyBuilder {
+				return builder.
+					RewriteHost(url, pathPrefix).
+					CopyRequestHeaderIf(xForwardedHostHeader, "X-Forwarded-Host", func(r *http.Request) bool {
+						return strings.TrimSpace(xForwardedHostHeader) != ""
+					}).
+					CopyRequestHeaderIf(xForwardedPathHeader, "X-Forwarded-Path", func(r *http.Request) bool {
+						return strings.TrimSpace(xForwardedPathHeader) != ""
+					}).
+					RewriteRequestCookies(url, pathPrefix).
+					RewriteRequestBody(url, pathPrefix).
+					RewriteRedirect(url, pathPrefix).
+					RewriteResponseBody(url, pathPrefix).
+					RewriteResponseCookies(url, pathPrefix)
+			}
+
+			routerBuilder := proxies.NewRouterBuilder()
+			for _, route := range routes {
+				segments := strings.SplitN(route, "=", 2)
+				if len(segments) != 2 {
+					return fmt.Errorf("route '%s' must be in the form '/prefix=https://backend'", route)
+				}
+				url, err := url.Parse(segments[1])
+				if err != nil {
+					return err
+				}
+				routerBuilder.RouteWith(segments[0], url, configure)
 			}
 
-			url, err := url.Parse(forwardedURL)
+			if strings.TrimSpace(forwardedURL) != "" {
+				url, err := url.Parse(forwardedURL)
+				if err != nil {
+					return err
+				}
+				routerBuilder.RouteWith(pathPrefix, url, configure)
+			}
+
+			transport := &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: skipTLSValidation},
+			}
+			reverseProxy, err := routerBuilder.Transport(transport).Build()
 			if err != nil {
 				return err
 			}
 
-			reverseProxy := proxies.NewReverseProxyBuilder().
-				RewriteHost(url, pathPrefix).
-				CopyRequestHeaderIf(xForwardedHostHeader, "X-Forwarded-Host", func(r *http.Request) bool {
-					return strings.TrimSpace(xForwardedHostHeader) != ""
-				}).
-				CopyRequestHeaderIf(xForwardedPathHeader, "X-Forwarded-Path", func(r *http.Request) bool {
-					return strings.TrimSpace(xForwardedPathHeader) != ""
-				}).
-				RewriteRequestCookies(url, pathPrefix).
-				RewriteRequestBody(url, pathPrefix).
-				RewriteRedirect(url, pathPrefix).
-				RewriteResponseBody(url, pathPrefix).
-				RewriteResponseCookies(url, pathPrefix).
-				ToReverseProxy(&http.Transport{
-					TLSClientConfig: &tls.Config{InsecureSkipVerify: skipTLSValidation},
-				})
+			// hosts that do not match a virtual host fall through to the routes
+			if len(virtualHosts) > 0 {
+				virtualHostBuilder := proxies.NewVirtualHostBuilder().
+					NotFound(reverseProxy)
+				for _, virtualHost := range virtualHosts {
+					segments := strings.SplitN(virtualHost, "=", 2)
+					if len(segments) != 2 {
+						return fmt.Errorf("virtual-host '%s' must be in the form 'www.example.com=https://backend'", virtualHost)
+					}
+					url, err := url.Parse(segments[1])
+					if err != nil {
+						return err
+					}
+					virtualHostBuilder.HostWith(segments[0], url, "", configure)
+				}
+				reverseProxy, err = virtualHostBuilder.Transport(transport).Build()
+				if err != nil {
+					return err
+				}
+			}
 
 			return http.ListenAndServe(":"+port, reverseProxy)
 		},
@@ -95,3 +173,28 @@ func main() {
 	}
 	os.Exit(0)
 }
+
+func serveConfig(path string, reloadInterval time.Duration) error {
+	reloader, err := config.NewReloader(path)
+	if err != nil {
+		return err
+	}
+
+	// reload when the file changes or when the process receives SIGHUP
+	if reloadInterval > 0 {
+		go reloader.Watch(reloadInterval, nil)
+	}
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := reloader.Reload(); err != nil {
+				log.Printf("unable to reload configuration '%s', keeping the current configuration: %v", path, err)
+				continue
+			}
+			log.Printf("reloaded configuration '%s'", path)
+		}
+	}()
+
+	return reloader.ListenAndServe()
+}
@@ -0,0 +1,82 @@
+package proxies
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// cssContentTypes are the media types the css rewrite changes
+var cssContentTypes = contentTypes{"text/css"}
+
+// javaScriptContentTypes are the media types the javascript rewrite changes
+var javaScriptContentTypes = contentTypes{
+	"application/javascript",
+	"application/x-javascript",
+	"application/ecmascript",
+	"text/javascript",
+	"text/ecmascript",
+}
+
+// cssRegex matches url() functions and @import rules with a quoted url, the url is in one of the groups
+var cssRegex = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)\s"']*))\s*\)|@import\s+(?:"([^"]*)"|'([^']*)')`)
+
+// mappingMatcher replaces the groups of regular expression matches with the frontend urls of the backend
+// urls they hold
+type mappingMatcher struct {
+	regexMatcher
+	mapper *urlMapper
+}
+
+func (m *mappingMatcher) expand(dst []byte, buf []byte, match []int) []byte {
+	last := match[0]
+	for group := 1; 2*group < len(match); group++ {
+		start, end := match[2*group], match[2*group+1]
+		if start < 0 {
+			continue
+		}
+		dst = append(dst, buf[last:start]...)
+		dst = append(dst, m.mapper.mapURL(string(buf[start:end]))...)
+		last = end
+	}
+	return append(dst, buf[last:match[1]]...)
+}
+
+func newMappingMatcher(regex *regexp.Regexp, mapper *urlMapper) streamMatcher {
+	return &mappingMatcher{
+		regexMatcher: regexMatcher{regex: regex, multiline: matchesNewline(regex)},
+		mapper:       mapper,
+	}
+}
+
+// newCSSMatcher maps the urls of url() functions and @import rules
+func newCSSMatcher(mapper *urlMapper) streamMatcher {
+	return newMappingMatcher(cssRegex, mapper)
+}
+
+// javaScriptRegex matches absolute urls in string literals and the start of string literals that begin with
+// one of the patterns, like "/api/"
+func javaScriptRegex(patterns []string) *regexp.Regexp {
+	alternatives := []string{`(?i:https?://)[^"'` + "`" + `\s\\]*`}
+	// longer patterns first so a pattern that starts another one does not hide it
+	patterns = append([]string{}, patterns...)
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return len(patterns[i]) > len(patterns[j])
+	})
+	for _, pattern := range patterns {
+		alternatives = append(alternatives, regexp.QuoteMeta(pattern))
+	}
+	return regexp.MustCompile(`["'` + "`" + `](` + strings.Join(alternatives, "|") + `)`)
+}
+
+// replaceAll replaces every match of the matcher in a buffer that holds the whole input
+func replaceAll(matcher streamMatcher, buf []byte) []byte {
+	output := make([]byte, 0, len(buf))
+	last := 0
+	for _, match := range matcher.matches(nil, buf) {
+		output = append(output, buf[last:match[0]]...)
+		output = matcher.expand(output, buf, match)
+		last = match[1]
+	}
+	return append(output, buf[last:]...)
+}
@@ -0,0 +1,69 @@
+package proxies_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing/iotest"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AssetRewriter", func() {
+	backendURL, _ := url.Parse("http://backend:8080/base")
+	rewrite := func(builder proxies.ReverseProxyBuilder, contentType string, body string) string {
+		request := httptest.NewRequest("GET", "http://frontend/app/asset", nil)
+		response := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{contentType}},
+			Body:       ioutil.NopCloser(iotest.OneByteReader(strings.NewReader(body))),
+			Request:    request,
+		}
+		Expect(builder.ToReverseProxy(nil).ModifyResponse(response)).To(Succeed())
+		return readAll(response)
+	}
+	Context("css", func() {
+		builder := func() proxies.ReverseProxyBuilder {
+			return proxies.NewReverseProxyBuilder().RewriteCSS(backendURL, "/app")
+		}
+		It("maps url functions and imports", func() {
+			css := `@import "/base/theme.css";
+@import url(/base/print.css) print;
+.a { background: url("/base/a.png") }
+.b { background: url( '/base/b.png' ) }
+.c { background: url(http://backend:8080/base/c.png) }
+.d { background: url(data:image/png;base64,AAAA) }
+.e { background: url(/other/e.png) }`
+			Expect(rewrite(builder(), "text/css", css)).To(Equal(`@import "/app/theme.css";
+@import url(/app/print.css) print;
+.a { background: url("/app/a.png") }
+.b { background: url( '/app/b.png' ) }
+.c { background: url(http://frontend/app/c.png) }
+.d { background: url(data:image/png;base64,AAAA) }
+.e { background: url(/other/e.png) }`))
+		})
+		It("leaves other content types alone", func() {
+			Expect(rewrite(builder(), "text/html", `url("/base/a.png")`)).To(Equal(`url("/base/a.png")`))
+		})
+	})
+	Context("javascript", func() {
+		builder := func() proxies.ReverseProxyBuilder {
+			return proxies.NewReverseProxyBuilder().RewriteJavaScript(backendURL, "/app", "/base/api/", "/base/static/")
+		}
+		It("maps string literals that match the patterns", func() {
+			js := "fetch(\"/base/api/users\"); load('/base/static/app.js'); go(`/base/api/${id}`);\n" +
+				"var origin = \"http://backend:8080/base/\"; var other = \"/base/private\"; var path = x + \"/base/api/\";"
+			Expect(rewrite(builder(), "application/javascript", js)).To(Equal(
+				"fetch(\"/app/api/users\"); load('/app/static/app.js'); go(`/app/api/${id}`);\n" +
+					"var origin = \"http://frontend/app/\"; var other = \"/base/private\"; var path = x + \"/app/api/\";"))
+		})
+		It("leaves other content types alone", func() {
+			Expect(rewrite(builder(), "text/css", `"/base/api/"`)).To(Equal(`"/base/api/"`))
+		})
+	})
+})
@@ -0,0 +1,41 @@
+package proxies
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// AtomicHandler serves requests with a handler that can be swapped while serving. Requests that
+// already started keep the handler they started with, new requests use the swapped handler
+type AtomicHandler interface {
+	http.Handler
+	Swap(handler http.Handler)
+	Handler() http.Handler
+}
+
+type handlerBox struct {
+	handler http.Handler
+}
+
+type atomicHandler struct {
+	value atomic.Value
+}
+
+func (h *atomicHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Handler().ServeHTTP(w, r)
+}
+
+func (h *atomicHandler) Swap(handler http.Handler) {
+	h.value.Store(&handlerBox{handler: handler})
+}
+
+func (h *atomicHandler) Handler() http.Handler {
+	return h.value.Load().(*handlerBox).handler
+}
+
+// NewAtomicHandler creates a handler that delegates to the given handler until it is swapped
+func NewAtomicHandler(handler http.Handler) AtomicHandler {
+	h := &atomicHandler{}
+	h.value.Store(&handlerBox{handler: handler})
+	return h
+}
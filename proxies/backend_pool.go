@@ -0,0 +1,237 @@
+package proxies
+
+import (
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Backend is a forwarded url that is a member of a backend pool
+type Backend interface {
+	URL() *url.URL
+	Weight() int
+	Outstanding() int64
+	Healthy() bool
+	acquire()
+	release()
+	setHealthy(healthy bool)
+}
+
+// BackendPool provides a set of backends and selects one of them for each request
+type BackendPool interface {
+	Backends() []Backend
+	Select(request *http.Request) Backend
+	Find(host string) Backend
+}
+
+// SelectionStrategy selects one of the backends for a request
+type SelectionStrategy interface {
+	Select(backends []Backend, request *http.Request) Backend
+}
+
+type backend struct {
+	url         *url.URL
+	weight      int
+	outstanding int64
+	unhealthy   int32
+}
+
+type backendPool struct {
+	strategy SelectionStrategy
+	backends []Backend
+}
+
+func (b *backend) URL() *url.URL {
+	return b.url
+}
+
+func (b *backend) Weight() int {
+	return b.weight
+}
+
+func (b *backend) Outstanding() int64 {
+	return atomic.LoadInt64(&b.outstanding)
+}
+
+func (b *backend) acquire() {
+	atomic.AddInt64(&b.outstanding, 1)
+}
+
+func (b *backend) release() {
+	atomic.AddInt64(&b.outstanding, -1)
+}
+
+func (b *backend) Healthy() bool {
+	return atomic.LoadInt32(&b.unhealthy) == 0
+}
+
+func (b *backend) setHealthy(healthy bool) {
+	var unhealthy int32
+	if !healthy {
+		unhealthy = 1
+	}
+	atomic.StoreInt32(&b.unhealthy, unhealthy)
+}
+
+func (pool *backendPool) Backends() []Backend {
+	return pool.backends
+}
+
+// Select returns one of the healthy backends or nil if no backend is healthy
+func (pool *backendPool) Select(request *http.Request) Backend {
+	healthy := make([]Backend, 0, len(pool.backends))
+	for _, b := range pool.backends {
+		if b.Healthy() {
+			healthy = append(healthy, b)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	if len(healthy) == 1 {
+		return healthy[0]
+	}
+	return pool.strategy.Select(healthy, request)
+}
+
+func (pool *backendPool) Find(host string) Backend {
+	for _, b := range pool.backends {
+		if strings.EqualFold(b.URL().Host, host) {
+			return b
+		}
+	}
+	return nil
+}
+
+// backendURLs returns the urls of the pool members ordered from longest to shortest so
+// a member url that is a prefix of another member url is replaced last
+func backendURLs(pool BackendPool) []*url.URL {
+	urls := []*url.URL{}
+	for _, b := range pool.Backends() {
+		urls = append(urls, b.URL())
+	}
+	sort.SliceStable(urls, func(i, j int) bool {
+		return len(urls[i].String()) > len(urls[j].String())
+	})
+	return urls
+}
+
+type roundRobinStrategy struct {
+	next uint64
+}
+
+func (s *roundRobinStrategy) Select(backends []Backend, request *http.Request) Backend {
+	next := atomic.AddUint64(&s.next, 1) - 1
+	return backends[next%uint64(len(backends))]
+}
+
+type weightedStrategy struct {
+	lock    sync.Mutex
+	current map[Backend]int
+}
+
+// Select uses smooth weighted round robin so heavier backends are interleaved instead of selected in bursts
+func (s *weightedStrategy) Select(backends []Backend, request *http.Request) Backend {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	total := 0
+	var selected Backend
+	for _, b := range backends {
+		weight := b.Weight()
+		if weight <= 0 {
+			continue
+		}
+		total += weight
+		s.current[b] += weight
+		if selected == nil || s.current[b] > s.current[selected] {
+			selected = b
+		}
+	}
+	if selected == nil {
+		return backends[0]
+	}
+	s.current[selected] -= total
+	return selected
+}
+
+type leastOutstandingStrategy struct {
+	next uint64
+}
+
+// Select returns the backend with the fewest outstanding requests, ties are broken in round robin order
+func (s *leastOutstandingStrategy) Select(backends []Backend, request *http.Request) Backend {
+	offset := int(atomic.AddUint64(&s.next, 1) % uint64(len(backends)))
+	var selected Backend
+	for i := range backends {
+		b := backends[(offset+i)%len(backends)]
+		if selected == nil || b.Outstanding() < selected.Outstanding() {
+			selected = b
+		}
+	}
+	return selected
+}
+
+type randomTwoChoicesStrategy struct{}
+
+// Select picks two distinct backends at random and returns the one with fewer outstanding requests
+func (s *randomTwoChoicesStrategy) Select(backends []Backend, request *http.Request) Backend {
+	first := rand.Intn(len(backends))
+	second := rand.Intn(len(backends) - 1)
+	if second >= first {
+		second++
+	}
+	if backends[second].Outstanding() < backends[first].Outstanding() {
+		return backends[second]
+	}
+	return backends[first]
+}
+
+// NewBackend creates a backend pool member for the forwarded url with the given weight
+func NewBackend(forwardedURL *url.URL, weight int) Backend {
+	return &backend{
+		url:    forwardedURL,
+		weight: weight,
+	}
+}
+
+// NewBackendPool creates a backend pool that selects between the backends using the strategy
+func NewBackendPool(strategy SelectionStrategy, backends ...Backend) BackendPool {
+	if strategy == nil {
+		strategy = NewRoundRobinStrategy()
+	}
+	return &backendPool{
+		strategy: strategy,
+		backends: backends,
+	}
+}
+
+// NewRoundRobinStrategy creates a strategy that selects backends in turn
+func NewRoundRobinStrategy() SelectionStrategy {
+	return &roundRobinStrategy{}
+}
+
+// NewWeightedStrategy creates a strategy that selects backends in proportion to their weight
+func NewWeightedStrategy() SelectionStrategy {
+	return &weightedStrategy{
+		current: map[Backend]int{},
+	}
+}
+
+// NewLeastOutstandingStrategy creates a strategy that selects the backend with the fewest outstanding requests
+func NewLeastOutstandingStrategy() SelectionStrategy {
+	return &leastOutstandingStrategy{}
+}
+
+// NewRandomTwoChoicesStrategy creates a strategy that selects the less loaded of two random backends
+func NewRandomTwoChoicesStrategy() SelectionStrategy {
+	return &randomTwoChoicesStrategy{}
+}
+
+func singleBackendPool(forwardedURL *url.URL) BackendPool {
+	return NewBackendPool(nil, NewBackend(forwardedURL, 1))
+}
@@ -0,0 +1,164 @@
+package proxies_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BackendPool", func() {
+	newBackends := func(weights ...int) []proxies.Backend {
+		backends := []proxies.Backend{}
+		for i, weight := range weights {
+			backendURL, err := url.Parse(fmt.Sprintf("http://backend-%d", i))
+			Expect(err).To(BeNil())
+			backends = append(backends, proxies.NewBackend(backendURL, weight))
+		}
+		return backends
+	}
+	count := func(pool proxies.BackendPool, requests int) map[string]int {
+		counts := map[string]int{}
+		for i := 0; i < requests; i++ {
+			counts[pool.Select(nil).URL().Host]++
+		}
+		return counts
+	}
+	It("selects backends in round robin order", func() {
+		backends := newBackends(1, 1, 1)
+		pool := proxies.NewBackendPool(proxies.NewRoundRobinStrategy(), backends...)
+		Expect(pool.Select(nil)).To(Equal(backends[0]))
+		Expect(pool.Select(nil)).To(Equal(backends[1]))
+		Expect(pool.Select(nil)).To(Equal(backends[2]))
+		Expect(pool.Select(nil)).To(Equal(backends[0]))
+	})
+	It("selects backends by weight", func() {
+		pool := proxies.NewBackendPool(proxies.NewWeightedStrategy(), newBackends(3, 1, 0)...)
+		counts := count(pool, 40)
+		Expect(counts["backend-0"]).To(Equal(30))
+		Expect(counts["backend-1"]).To(Equal(10))
+		Expect(counts["backend-2"]).To(Equal(0))
+	})
+	It("finds backends by host", func() {
+		backends := newBackends(1, 1)
+		pool := proxies.NewBackendPool(nil, backends...)
+		Expect(pool.Find("BACKEND-1")).To(Equal(backends[1]))
+		Expect(pool.Find("other")).To(BeNil())
+	})
+	Context("proxy", func() {
+		var (
+			servers  []*httptest.Server
+			frontend *httptest.Server
+			backends []proxies.Backend
+			block    chan struct{}
+			received chan struct{}
+		)
+		newServer := func(name string) proxies.Backend {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/block":
+					received <- struct{}{}
+					<-block
+				case "/redirect":
+					http.Redirect(w, r, fmt.Sprintf("http://%s/ok", r.Host), http.StatusTemporaryRedirect)
+					return
+				}
+				fmt.Fprint(w, name)
+			}))
+			servers = append(servers, server)
+			serverURL, err := url.Parse(server.URL)
+			Expect(err).To(BeNil())
+			return proxies.NewBackend(serverURL, 1)
+		}
+		get := func(client *http.Client, path string) *http.Response {
+			res, err := client.Get(frontend.URL + path)
+			Expect(err).To(BeNil())
+			return res
+		}
+		body := func(res *http.Response) string {
+			defer res.Body.Close()
+			bodyBytes, err := ioutil.ReadAll(res.Body)
+			Expect(err).To(BeNil())
+			return string(bodyBytes)
+		}
+		serve := func(strategy proxies.SelectionStrategy) {
+			pool := proxies.NewBackendPool(strategy, backends...)
+			reverseProxy := proxies.NewReverseProxyBuilder().
+				AddRequestHeader("X-Forwarded-Proto", "http").
+				RewriteHostPool(pool, "/").
+				RewriteRedirectPool(pool, "/").
+				RewriteResponseBodyPool(pool, "/").
+				ToReverseProxy(&http.Transport{})
+			frontend = httptest.NewServer(reverseProxy)
+		}
+		BeforeEach(func() {
+			servers = []*httptest.Server{}
+			block = make(chan struct{})
+			received = make(chan struct{}, 1)
+			backends = []proxies.Backend{newServer("one"), newServer("two")}
+		})
+		AfterEach(func() {
+			frontend.Close()
+			for _, server := range servers {
+				server.Close()
+			}
+		})
+		It("spreads requests across the pool", func() {
+			serve(proxies.NewRoundRobinStrategy())
+			Expect(body(get(http.DefaultClient, "/ok"))).To(Equal("one"))
+			Expect(body(get(http.DefaultClient, "/ok"))).To(Equal("two"))
+		})
+		It("rewrites redirects from any pool member", func() {
+			serve(proxies.NewRoundRobinStrategy())
+			client := &http.Client{
+				CheckRedirect: func(req *http.Request, via []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			}
+			for i := 0; i < len(backends); i++ {
+				res := get(client, "/redirect")
+				res.Body.Close()
+				Expect(res.StatusCode).To(Equal(http.StatusTemporaryRedirect))
+				Expect(res.Header.Get("Location")).To(Equal(frontend.URL + "/ok"))
+			}
+		})
+		for _, s := range []struct {
+			name     string
+			strategy func() proxies.SelectionStrategy
+		}{
+			{name: "least outstanding", strategy: proxies.NewLeastOutstandingStrategy},
+			{name: "random two choices", strategy: proxies.NewRandomTwoChoicesStrategy},
+		} {
+			strategy := s.strategy
+			It(fmt.Sprintf("avoids busy backends with %s", s.name), func() {
+				serve(strategy())
+
+				done := make(chan string)
+				go func() {
+					defer GinkgoRecover()
+					done <- body(get(http.DefaultClient, "/block"))
+				}()
+				Eventually(received).Should(Receive())
+
+				busy := "one"
+				if backends[1].Outstanding() == 1 {
+					busy = "two"
+				}
+				for i := 0; i < 3; i++ {
+					Expect(body(get(http.DefaultClient, "/ok"))).ToNot(Equal(busy))
+				}
+
+				close(block)
+				Eventually(done).Should(Receive(Equal(busy)))
+				Eventually(backends[0].Outstanding).Should(BeZero())
+				Eventually(backends[1].Outstanding).Should(BeZero())
+			})
+		}
+	})
+})
@@ -0,0 +1,75 @@
+package proxies
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// BuildErrors collects the invalid arguments passed to the methods of a reverse proxy builder
+type BuildErrors []error
+
+func (errs BuildErrors) Error() string {
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// add appends the errors of the builder of a route or host, each one prefixed with the name
+func (errs BuildErrors) add(name string, err error) BuildErrors {
+	var nested BuildErrors
+	if !errors.As(err, &nested) {
+		nested = BuildErrors{err}
+	}
+	for _, e := range nested {
+		errs = append(errs, fmt.Errorf("%s: %w", name, e))
+	}
+	return errs
+}
+
+// errorf records an invalid argument of the builder method, the rewrite of the method is not added
+func (builder *reverseProxyBuilder) errorf(method string, format string, args ...interface{}) {
+	builder.errors = append(builder.errors, fmt.Errorf(method+": "+format, args...))
+}
+
+// regex compiles the regular expression of the builder method once, it returns nil when the expression is invalid
+func (builder *reverseProxyBuilder) regex(method string, match string) *regexp.Regexp {
+	regex, err := regexp.Compile(match)
+	if err != nil {
+		builder.errorf(method, "invalid regular expression '%s': %w", match, err)
+		return nil
+	}
+	return regex
+}
+
+// jsonPath parses the location of the builder method, it returns false when the location is invalid
+func (builder *reverseProxyBuilder) jsonPath(method string, path string) (jsonPath, bool) {
+	parsed, err := parseJSONPath(path)
+	if err != nil {
+		builder.errorf(method, "%w", err)
+		return nil, false
+	}
+	return parsed, true
+}
+
+// jsonValue reports whether the value of the builder method is valid json
+func (builder *reverseProxyBuilder) jsonValue(method string, value string) bool {
+	if _, err := parseJSONDocument([]byte(value)); err != nil {
+		builder.errorf(method, "invalid json value '%s': %w", value, err)
+		return false
+	}
+	return true
+}
+
+// xmlPath parses the location of the builder method, it returns nil when the location is invalid
+func (builder *reverseProxyBuilder) xmlPath(method string, path string) *xmlPath {
+	parsed, err := parseXMLPath(path)
+	if err != nil {
+		builder.errorf(method, "%w", err)
+		return nil
+	}
+	return parsed
+}
@@ -0,0 +1,65 @@
+package proxies_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Build", func() {
+	It("collects the errors of every invalid pattern", func() {
+		builder := proxies.NewReverseProxyBuilder().
+			ReplaceRequestHeader("X-Name", "(", "").
+			ReplaceRequestHeaderValueIf("X-Name", "[", "", func(r *http.Request) bool { return true }).
+			ReplaceRequestBody("a", "b").
+			ReplaceResponseBodyIf("*", "", func(r *http.Response) bool { return true })
+
+		handler, err := builder.Build()
+		Expect(handler).To(BeNil())
+		Expect(err).To(HaveLen(3))
+		Expect(err.Error()).To(ContainSubstring("ReplaceRequestHeader: invalid regular expression '('"))
+		Expect(err.Error()).To(ContainSubstring("ReplaceRequestHeaderValue: invalid regular expression '['"))
+		Expect(err.Error()).To(ContainSubstring("ReplaceResponseBody: invalid regular expression '*'"))
+		Expect(func() { builder.ToReverseProxy(nil) }).To(Panic())
+	})
+	It("builds a handler that uses the transport", func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Header.Get("X-Name")))
+		}))
+		defer backend.Close()
+
+		transport := &countingTransport{}
+		handler, err := proxies.NewReverseProxyBuilder().
+			RequestRewrite(func(r *http.Request) {
+				r.URL.Scheme = "http"
+				r.URL.Host = strings.TrimPrefix(backend.URL, "http://")
+			}).
+			ReplaceRequestHeader("X-Name", "^internal-", "").
+			Transport(transport).
+			Build()
+		Expect(err).To(BeNil())
+
+		request := httptest.NewRequest("GET", "http://frontend/", nil)
+		request.Header.Set("X-Name", "internal-bob")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		body, _ := ioutil.ReadAll(recorder.Body)
+		Expect(string(body)).To(Equal("bob"))
+		Expect(transport.requests).To(Equal(1))
+	})
+})
+
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(request)
+}
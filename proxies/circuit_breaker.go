@@ -0,0 +1,281 @@
+package proxies
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCircuitFailures is the number of consecutive failures that open a circuit
+	DefaultCircuitFailures = 5
+	// DefaultCircuitCooldown is the time an open circuit fails fast before it is half opened
+	DefaultCircuitCooldown = 30 * time.Second
+	// DefaultCircuitHalfOpenRequests is the number of concurrent probe requests allowed through a half open circuit
+	DefaultCircuitHalfOpenRequests = 1
+)
+
+// CircuitState is the state of the circuit to a backend
+type CircuitState int
+
+const (
+	// CircuitClosed circuits send every request to the backend
+	CircuitClosed CircuitState = iota
+	// CircuitOpen circuits fail fast without sending requests to the backend
+	CircuitOpen
+	// CircuitHalfOpen circuits send a limited number of probe requests to the backend to detect recovery
+	CircuitHalfOpen
+)
+
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "halfOpen"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerSettings describes when circuits open and how they recover, zero values are replaced
+// with defaults
+type CircuitBreakerSettings struct {
+	// ConsecutiveFailures is the number of 5xx responses or connection failures that open the circuit
+	ConsecutiveFailures int
+	// Cooldown is the time an open circuit fails fast
+	Cooldown time.Duration
+	// HalfOpenRequests is the number of concurrent requests allowed while probing recovery
+	HalfOpenRequests int
+	// OnStateChange is called after the circuit to a backend host changes state
+	OnStateChange func(host string, from CircuitState, to CircuitState)
+}
+
+// CircuitStatus describes the circuit to a backend host
+type CircuitStatus struct {
+	Host                string    `json:"host"`
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Opened              int       `json:"opened"`
+	OpenUntil           time.Time `json:"openUntil"`
+}
+
+// CircuitBreaker is a transport that tracks failures for each backend host and fails fast with
+// 503 Service Unavailable while the circuit to the host is open
+type CircuitBreaker interface {
+	http.RoundTripper
+	State(host string) CircuitState
+	Status() []CircuitStatus
+}
+
+type circuit struct {
+	state     CircuitState
+	failures  int
+	opened    int
+	openUntil time.Time
+	probes    int
+	// round counts the times the circuit was half opened so probes of an earlier round are not counted
+	round int
+}
+
+type circuitBreaker struct {
+	transport http.RoundTripper
+	settings  CircuitBreakerSettings
+	lock      sync.Mutex
+	circuits  map[string]*circuit
+}
+
+func (breaker *circuitBreaker) RoundTrip(request *http.Request) (*http.Response, error) {
+	host := strings.ToLower(request.URL.Host)
+	probe, retryAfter, ok := breaker.allow(host)
+	if !ok {
+		return unavailable(request, retryAfter), nil
+	}
+	response, err := breaker.transport.RoundTrip(request)
+	breaker.record(host, probe, request, response, err)
+	return response, err
+}
+
+func (breaker *circuitBreaker) unwrap() http.RoundTripper {
+	return breaker.transport
+}
+
+// allow reports whether the request may be sent to the host and if not how long until it may be retried. Requests
+// sent while the circuit is half open are probes of the round, other requests have round 0
+func (breaker *circuitBreaker) allow(host string) (int, time.Duration, bool) {
+	breaker.lock.Lock()
+	c := breaker.circuit(host)
+	now := time.Now()
+	from := c.state
+
+	if c.state == CircuitOpen {
+		if now.Before(c.openUntil) {
+			breaker.lock.Unlock()
+			return 0, c.openUntil.Sub(now), false
+		}
+		c.state = CircuitHalfOpen
+		c.probes = 0
+		c.round++
+	}
+	probe := 0
+	if c.state == CircuitHalfOpen {
+		if c.probes >= breaker.settings.HalfOpenRequests {
+			breaker.lock.Unlock()
+			return 0, time.Second, false
+		}
+		c.probes++
+		probe = c.round
+	}
+	to := c.state
+	breaker.lock.Unlock()
+
+	breaker.changed(host, from, to)
+	return probe, 0, true
+}
+
+func (breaker *circuitBreaker) record(host string, probe int, request *http.Request, response *http.Response, err error) {
+	abandoned := err != nil && request.Context().Err() != nil
+	failed := err != nil || response.StatusCode >= http.StatusInternalServerError
+
+	breaker.lock.Lock()
+	c := breaker.circuit(host)
+	from := c.state
+	current := c.state == CircuitHalfOpen && probe == c.round
+	if current {
+		c.probes--
+	}
+	switch {
+	case abandoned:
+		// a request abandoned by the client says nothing about the backend
+	case c.state == CircuitHalfOpen && !current:
+		// only the probes of the current round decide whether the circuit recovered
+	case !failed:
+		c.failures = 0
+		if c.state == CircuitHalfOpen {
+			c.state = CircuitClosed
+		}
+	case c.state == CircuitOpen:
+		// requests sent before the circuit opened do not extend the cooldown
+	default:
+		c.failures++
+		if c.state == CircuitHalfOpen || c.failures >= breaker.settings.ConsecutiveFailures {
+			c.state = CircuitOpen
+			c.opened++
+			c.openUntil = time.Now().Add(breaker.settings.Cooldown)
+		}
+	}
+	to := c.state
+	breaker.lock.Unlock()
+
+	breaker.changed(host, from, to)
+}
+
+// circuit returns the circuit to the host, the caller must hold the lock
+func (breaker *circuitBreaker) circuit(host string) *circuit {
+	c, ok := breaker.circuits[host]
+	if !ok {
+		c = &circuit{}
+		breaker.circuits[host] = c
+	}
+	return c
+}
+
+func (breaker *circuitBreaker) changed(host string, from CircuitState, to CircuitState) {
+	if from == to || breaker.settings.OnStateChange == nil {
+		return
+	}
+	breaker.settings.OnStateChange(host, from, to)
+}
+
+func (breaker *circuitBreaker) State(host string) CircuitState {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	c, ok := breaker.circuits[strings.ToLower(host)]
+	if !ok {
+		return CircuitClosed
+	}
+	return c.state
+}
+
+func (breaker *circuitBreaker) Status() []CircuitStatus {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	statuses := []CircuitStatus{}
+	for host, c := range breaker.circuits {
+		statuses = append(statuses, CircuitStatus{
+			Host:                host,
+			State:               c.state.String(),
+			ConsecutiveFailures: c.failures,
+			Opened:              c.opened,
+			OpenUntil:           c.openUntil,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Host < statuses[j].Host
+	})
+	return statuses
+}
+
+// unavailable creates the response returned while a circuit is open
+func unavailable(request *http.Request, retryAfter time.Duration) *http.Response {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	body := http.StatusText(http.StatusServiceUnavailable)
+	return &http.Response{
+		Status:     "503 " + body,
+		StatusCode: http.StatusServiceUnavailable,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type": []string{"text/plain; charset=utf-8"},
+			"Retry-After":  []string{strconv.Itoa(seconds)},
+		},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}
+}
+
+// CircuitHandler serves the status of the circuits of every breaker as json
+func CircuitHandler(breakers ...CircuitBreaker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statuses := []CircuitStatus{}
+		for _, breaker := range breakers {
+			statuses = append(statuses, breaker.Status()...)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+	})
+}
+
+// NewCircuitBreaker wraps the transport with a circuit breaker for each backend host
+func NewCircuitBreaker(transport http.RoundTripper, settings CircuitBreakerSettings) CircuitBreaker {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if settings.ConsecutiveFailures <= 0 {
+		settings.ConsecutiveFailures = DefaultCircuitFailures
+	}
+	if settings.Cooldown <= 0 {
+		settings.Cooldown = DefaultCircuitCooldown
+	}
+	if settings.HalfOpenRequests <= 0 {
+		settings.HalfOpenRequests = DefaultCircuitHalfOpenRequests
+	}
+	return &circuitBreaker{
+		transport: transport,
+		settings:  settings,
+		circuits:  map[string]*circuit{},
+	}
+}
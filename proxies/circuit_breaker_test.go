@@ -0,0 +1,166 @@
+package proxies_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CircuitBreaker", func() {
+	var (
+		server      *httptest.Server
+		frontend    *httptest.Server
+		breaker     proxies.CircuitBreaker
+		status      int32
+		requests    int32
+		lock        sync.Mutex
+		transitions []string
+		host        string
+		release     chan struct{}
+		probe       chan struct{}
+	)
+	BeforeEach(func() {
+		atomic.StoreInt32(&status, http.StatusInternalServerError)
+		atomic.StoreInt32(&requests, 0)
+		transitions = []string{}
+		release = make(chan struct{})
+		probe = make(chan struct{})
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			switch r.URL.Path {
+			case "/slow":
+				<-release
+			case "/probe":
+				<-probe
+			}
+			w.WriteHeader(int(atomic.LoadInt32(&status)))
+		}))
+		serverURL, err := url.Parse(server.URL)
+		Expect(err).To(BeNil())
+		host = serverURL.Host
+
+		breaker = proxies.NewCircuitBreaker(nil, proxies.CircuitBreakerSettings{
+			ConsecutiveFailures: 2,
+			Cooldown:            50 * time.Millisecond,
+			OnStateChange: func(host string, from proxies.CircuitState, to proxies.CircuitState) {
+				lock.Lock()
+				defer lock.Unlock()
+				transitions = append(transitions, from.String()+"->"+to.String())
+			},
+		})
+		reverseProxy := proxies.NewReverseProxyBuilder().
+			RewriteHost(serverURL, "/").
+			ToReverseProxy(breaker)
+		frontend = httptest.NewServer(reverseProxy)
+	})
+	AfterEach(func() {
+		for _, held := range []chan struct{}{release, probe} {
+			select {
+			case <-held:
+			default:
+				close(held)
+			}
+		}
+		frontend.Close()
+		server.Close()
+	})
+	getPath := func(path string) *http.Response {
+		res, err := http.Get(frontend.URL + path)
+		Expect(err).To(BeNil())
+		res.Body.Close()
+		return res
+	}
+	get := func() *http.Response {
+		return getPath("/")
+	}
+	observed := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, transitions...)
+	}
+	It("opens after consecutive failures and fails fast", func() {
+		Expect(get().StatusCode).To(Equal(http.StatusInternalServerError))
+		Expect(breaker.State(host)).To(Equal(proxies.CircuitClosed))
+		Expect(get().StatusCode).To(Equal(http.StatusInternalServerError))
+		Expect(breaker.State(host)).To(Equal(proxies.CircuitOpen))
+
+		res := get()
+		Expect(res.StatusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(res.Header.Get("Retry-After")).To(Equal("1"))
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(2)))
+
+		statuses := breaker.Status()
+		Expect(statuses).To(HaveLen(1))
+		Expect(statuses[0].State).To(Equal("open"))
+		Expect(statuses[0].Opened).To(Equal(1))
+	})
+	It("closes when a half open probe succeeds", func() {
+		get()
+		get()
+		atomic.StoreInt32(&status, http.StatusOK)
+		time.Sleep(60 * time.Millisecond)
+
+		Expect(get().StatusCode).To(Equal(http.StatusOK))
+		Expect(breaker.State(host)).To(Equal(proxies.CircuitClosed))
+		Expect(observed()).To(Equal([]string{"closed->open", "open->halfOpen", "halfOpen->closed"}))
+	})
+	It("reopens when a half open probe fails", func() {
+		get()
+		get()
+		time.Sleep(60 * time.Millisecond)
+
+		Expect(get().StatusCode).To(Equal(http.StatusInternalServerError))
+		Expect(breaker.State(host)).To(Equal(proxies.CircuitOpen))
+		Expect(get().StatusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(observed()).To(Equal([]string{"closed->open", "open->halfOpen", "halfOpen->open"}))
+	})
+	It("only counts probes against the half open limit", func() {
+		done := make(chan int, 3)
+		send := func(path string) {
+			go func() {
+				defer GinkgoRecover()
+				done <- getPath(path).StatusCode
+			}()
+		}
+		send("/slow")
+		send("/slow")
+		Eventually(func() int32 { return atomic.LoadInt32(&requests) }).Should(Equal(int32(2)))
+		get()
+		get()
+		Expect(breaker.State(host)).To(Equal(proxies.CircuitOpen))
+		time.Sleep(60 * time.Millisecond)
+
+		send("/probe")
+		Eventually(func() int32 { return atomic.LoadInt32(&requests) }).Should(Equal(int32(5)))
+		Expect(breaker.State(host)).To(Equal(proxies.CircuitHalfOpen))
+
+		// the requests sent while the circuit was closed finish while the probe is in flight
+		close(release)
+		Eventually(done).Should(Receive(Equal(http.StatusInternalServerError)))
+		Eventually(done).Should(Receive(Equal(http.StatusInternalServerError)))
+		Expect(breaker.State(host)).To(Equal(proxies.CircuitHalfOpen))
+		Expect(get().StatusCode).To(Equal(http.StatusServiceUnavailable))
+
+		atomic.StoreInt32(&status, http.StatusOK)
+		close(probe)
+		Eventually(done).Should(Receive(Equal(http.StatusOK)))
+		Expect(breaker.State(host)).To(Equal(proxies.CircuitClosed))
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(5)))
+	})
+	It("resets the failure count on success", func() {
+		get()
+		atomic.StoreInt32(&status, http.StatusOK)
+		get()
+		atomic.StoreInt32(&status, http.StatusInternalServerError)
+		get()
+		Expect(breaker.State(host)).To(Equal(proxies.CircuitClosed))
+	})
+})
@@ -0,0 +1,318 @@
+package proxies
+
+import (
+	"mime"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// And returns a condition that matches when the condition and all of the others match
+func (condition RequestCondition) And(others ...RequestCondition) RequestCondition {
+	return func(r *http.Request) bool {
+		if !condition(r) {
+			return false
+		}
+		for _, other := range others {
+			if !other(r) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or returns a condition that matches when the condition or any of the others match
+func (condition RequestCondition) Or(others ...RequestCondition) RequestCondition {
+	return func(r *http.Request) bool {
+		if condition(r) {
+			return true
+		}
+		for _, other := range others {
+			if other(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not returns a condition that matches when the condition does not
+func (condition RequestCondition) Not() RequestCondition {
+	return func(r *http.Request) bool {
+		return !condition(r)
+	}
+}
+
+// And returns a condition that matches when the condition and all of the others match
+func (condition ResponseCondition) And(others ...ResponseCondition) ResponseCondition {
+	return func(r *http.Response) bool {
+		if !condition(r) {
+			return false
+		}
+		for _, other := range others {
+			if !other(r) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or returns a condition that matches when the condition or any of the others match
+func (condition ResponseCondition) Or(others ...ResponseCondition) ResponseCondition {
+	return func(r *http.Response) bool {
+		if condition(r) {
+			return true
+		}
+		for _, other := range others {
+			if other(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not returns a condition that matches when the condition does not
+func (condition ResponseCondition) Not() ResponseCondition {
+	return func(r *http.Response) bool {
+		return !condition(r)
+	}
+}
+
+// AllRequests matches every request
+func AllRequests() RequestCondition {
+	return allRequests
+}
+
+// AllResponses matches every response
+func AllResponses() ResponseCondition {
+	return allResponses
+}
+
+// PathPrefix matches requests whose path is the prefix or below it on a segment boundary
+func PathPrefix(prefix string) RequestCondition {
+	return func(r *http.Request) bool {
+		return MatchesPathPrefix(r.URL.Path, prefix)
+	}
+}
+
+// PathRegex matches requests whose path matches the regular expression
+func PathRegex(pattern string) (RequestCondition, error) {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return func(r *http.Request) bool {
+		return regex.MatchString(r.URL.Path)
+	}, nil
+}
+
+// MustPathRegex is like PathRegex but panics when the regular expression is invalid
+func MustPathRegex(pattern string) RequestCondition {
+	condition, err := PathRegex(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return condition
+}
+
+// PathGlob matches requests whose whole path matches the glob, * and ? match within a segment and ** matches
+// any number of segments
+func PathGlob(glob string) (RequestCondition, error) {
+	regex, err := globRegex(glob)
+	if err != nil {
+		return nil, err
+	}
+	return func(r *http.Request) bool {
+		return regex.MatchString(r.URL.Path)
+	}, nil
+}
+
+// MustPathGlob is like PathGlob but panics when the glob is invalid
+func MustPathGlob(glob string) RequestCondition {
+	condition, err := PathGlob(glob)
+	if err != nil {
+		panic(err)
+	}
+	return condition
+}
+
+func globRegex(glob string) (*regexp.Regexp, error) {
+	pattern := &strings.Builder{}
+	pattern.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			pattern.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			pattern.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			pattern.WriteString("[^/]*")
+		case glob[i] == '?':
+			pattern.WriteString("[^/]")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	pattern.WriteString("$")
+	return regexp.Compile(pattern.String())
+}
+
+// Method matches requests with one of the methods, compared without case
+func Method(methods ...string) RequestCondition {
+	return func(r *http.Request) bool {
+		for _, method := range methods {
+			if strings.EqualFold(r.Method, method) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// QueryParam matches requests with the query parameter, when value is not empty one of the values of the
+// parameter must equal it
+func QueryParam(name string, value string) RequestCondition {
+	return func(r *http.Request) bool {
+		values, ok := r.URL.Query()[name]
+		return ok && matchesValue(values, value)
+	}
+}
+
+// Cookie matches requests with the cookie, when value is not empty the cookie must have the value
+func Cookie(name string, value string) RequestCondition {
+	return func(r *http.Request) bool {
+		cookie, err := r.Cookie(name)
+		return err == nil && (value == "" || cookie.Value == value)
+	}
+}
+
+// ClientCIDR matches requests whose remote address is in one of the networks, like 10.0.0.0/8
+func ClientCIDR(cidrs ...string) (RequestCondition, error) {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return func(r *http.Request) bool {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return false
+		}
+		for _, network := range networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// MustClientCIDR is like ClientCIDR but panics when a network is invalid
+func MustClientCIDR(cidrs ...string) RequestCondition {
+	condition, err := ClientCIDR(cidrs...)
+	if err != nil {
+		panic(err)
+	}
+	return condition
+}
+
+// StatusRange matches responses with a status code from first to last, like 500 to 599
+func StatusRange(first int, last int) ResponseCondition {
+	return func(r *http.Response) bool {
+		return r.StatusCode >= first && r.StatusCode <= last
+	}
+}
+
+// ContentType matches responses whose media type matches one of the patterns, a * matches any subtype
+func ContentType(patterns ...string) ResponseCondition {
+	types := contentTypes(patterns)
+	return func(r *http.Response) bool {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		return err == nil && types.allows(mediaType)
+	}
+}
+
+// HeaderCondition checks the headers of a request or a response
+type HeaderCondition func(header http.Header) bool
+
+// Request returns the condition for the headers of requests
+func (condition HeaderCondition) Request() RequestCondition {
+	return func(r *http.Request) bool {
+		return condition(r.Header)
+	}
+}
+
+// Response returns the condition for the headers of responses
+func (condition HeaderCondition) Response() ResponseCondition {
+	return func(r *http.Response) bool {
+		return condition(r.Header)
+	}
+}
+
+// HeaderPresent matches headers that contain the header
+func HeaderPresent(name string) HeaderCondition {
+	return func(header http.Header) bool {
+		_, ok := header[http.CanonicalHeaderKey(name)]
+		return ok
+	}
+}
+
+// HeaderEquals matches headers where one of the values of the header equals the value, an empty value matches
+// any value
+func HeaderEquals(name string, value string) HeaderCondition {
+	return func(header http.Header) bool {
+		values, ok := header[http.CanonicalHeaderKey(name)]
+		return ok && matchesValue(values, value)
+	}
+}
+
+// HeaderRegex matches headers where one of the values of the header matches the regular expression
+func HeaderRegex(name string, pattern string) (HeaderCondition, error) {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return func(header http.Header) bool {
+		for _, value := range header.Values(name) {
+			if regex.MatchString(value) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// MustHeaderRegex is like HeaderRegex but panics when the regular expression is invalid
+func MustHeaderRegex(name string, pattern string) HeaderCondition {
+	condition, err := HeaderRegex(name, pattern)
+	if err != nil {
+		panic(err)
+	}
+	return condition
+}
+
+// matchesValue reports whether one of the values equals the value, an empty value matches any
+func matchesValue(values []string, value string) bool {
+	if value == "" {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
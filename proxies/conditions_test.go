@@ -0,0 +1,77 @@
+package proxies_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conditions", func() {
+	request := func(method string, target string) *http.Request {
+		r := httptest.NewRequest(method, target, nil)
+		r.RemoteAddr = "10.1.2.3:5000"
+		r.Header.Set("X-Debug", "yes")
+		r.AddCookie(&http.Cookie{Name: "canary", Value: "on"})
+		return r
+	}
+	response := func(status int, contentType string) *http.Response {
+		return &http.Response{StatusCode: status, Header: http.Header{"Content-Type": {contentType}}}
+	}
+	It("matches paths", func() {
+		r := request("GET", "http://frontend/static/js/app.min.js")
+		Expect(proxies.PathPrefix("/static")(r)).To(BeTrue())
+		Expect(proxies.PathPrefix("/stat")(r)).To(BeFalse())
+		Expect(proxies.MustPathRegex(`\.js$`)(r)).To(BeTrue())
+		Expect(proxies.MustPathGlob("/static/**/*.js")(r)).To(BeTrue())
+		Expect(proxies.MustPathGlob("/static/*.js")(r)).To(BeFalse())
+		Expect(proxies.MustPathGlob("/static/js/app.???.js")(r)).To(BeTrue())
+	})
+	It("matches methods, headers, query parameters, cookies and clients", func() {
+		r := request("post", "http://frontend/?debug=1&debug=2")
+		Expect(proxies.Method("GET", "POST")(r)).To(BeTrue())
+		Expect(proxies.Method("GET")(r)).To(BeFalse())
+		Expect(proxies.HeaderPresent("x-debug").Request()(r)).To(BeTrue())
+		Expect(proxies.HeaderEquals("X-Debug", "no").Request()(r)).To(BeFalse())
+		Expect(proxies.MustHeaderRegex("X-Debug", "^y").Request()(r)).To(BeTrue())
+		Expect(proxies.QueryParam("debug", "2")(r)).To(BeTrue())
+		Expect(proxies.QueryParam("trace", "")(r)).To(BeFalse())
+		Expect(proxies.Cookie("canary", "on")(r)).To(BeTrue())
+		Expect(proxies.Cookie("canary", "off")(r)).To(BeFalse())
+		Expect(proxies.MustClientCIDR("192.168.0.0/16", "10.0.0.0/8")(r)).To(BeTrue())
+		Expect(proxies.MustClientCIDR("10.2.0.0/16")(r)).To(BeFalse())
+		Expect(func() { proxies.MustClientCIDR("10.0.0.0") }).To(Panic())
+		_, err := proxies.ClientCIDR("10.0.0.0")
+		Expect(err).ToNot(BeNil())
+	})
+	It("matches responses", func() {
+		Expect(proxies.StatusRange(500, 599)(response(503, "text/plain"))).To(BeTrue())
+		Expect(proxies.StatusRange(500, 599)(response(404, "text/plain"))).To(BeFalse())
+		Expect(proxies.ContentType("application/*+json")(response(200, "application/hal+json; charset=utf-8"))).To(BeTrue())
+		Expect(proxies.ContentType("text/html")(response(200, "text/plain"))).To(BeFalse())
+		Expect(proxies.HeaderPresent("Content-Type").Response()(response(200, "text/plain"))).To(BeTrue())
+	})
+	It("returns errors for invalid patterns", func() {
+		_, err := proxies.PathRegex("(")
+		Expect(err).ToNot(BeNil())
+		_, err = proxies.HeaderRegex("X-Debug", "[")
+		Expect(err).ToNot(BeNil())
+		Expect(func() { proxies.MustPathRegex("(") }).To(Panic())
+	})
+	It("combines conditions", func() {
+		r := request("GET", "http://frontend/api/users")
+		api := proxies.PathPrefix("/api")
+		Expect(api.And(proxies.Method("GET"), proxies.Cookie("canary", "on"))(r)).To(BeTrue())
+		Expect(api.And(proxies.Method("POST"))(r)).To(BeFalse())
+		Expect(proxies.Method("POST").Or(proxies.Method("PUT"), api)(r)).To(BeTrue())
+		Expect(api.Not()(r)).To(BeFalse())
+
+		errors := proxies.StatusRange(500, 599)
+		Expect(errors.And(proxies.ContentType("text/*"))(response(502, "text/html"))).To(BeTrue())
+		Expect(errors.Or(proxies.StatusRange(404, 404))(response(404, "text/html"))).To(BeTrue())
+		Expect(errors.Not()(response(200, "text/html"))).To(BeTrue())
+	})
+})
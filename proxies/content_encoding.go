@@ -0,0 +1,261 @@
+package proxies
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// BodyEncoding decides how a body with a Content-Encoding is sent once it is rewritten
+type BodyEncoding int
+
+const (
+	// ReencodeBody compresses the rewritten body again with the encoding of the original body
+	ReencodeBody BodyEncoding = iota
+	// DecodeBody sends the rewritten body uncompressed and removes the Content-Encoding header
+	DecodeBody
+)
+
+func (e BodyEncoding) String() string {
+	switch e {
+	case ReencodeBody:
+		return "reencode"
+	case DecodeBody:
+		return "decode"
+	}
+	return "unknown"
+}
+
+// flushWriter is a compressing writer that can emit what it has buffered
+type flushWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// contentCoding compresses and decompresses one Content-Encoding
+type contentCoding struct {
+	name      string
+	newReader func(source io.Reader) (io.Reader, error)
+	newWriter func(destination io.Writer) flushWriter
+}
+
+var contentCodings = map[string]*contentCoding{
+	"gzip": {
+		name: "gzip",
+		newReader: func(source io.Reader) (io.Reader, error) {
+			return gzip.NewReader(source)
+		},
+		newWriter: func(destination io.Writer) flushWriter {
+			return gzip.NewWriter(destination)
+		},
+	},
+	"deflate": {
+		name:      "deflate",
+		newReader: newDeflateReader,
+		newWriter: func(destination io.Writer) flushWriter {
+			return zlib.NewWriter(destination)
+		},
+	},
+	"br": {
+		name: "br",
+		newReader: func(source io.Reader) (io.Reader, error) {
+			return brotli.NewReader(source), nil
+		},
+		newWriter: func(destination io.Writer) flushWriter {
+			return brotli.NewWriter(destination)
+		},
+	},
+}
+
+func init() {
+	contentCodings["x-gzip"] = contentCodings["gzip"]
+}
+
+// newDeflateReader reads zlib wrapped deflate data as the specification requires and the raw deflate data
+// some servers send instead
+func newDeflateReader(source io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(source)
+	header, err := buffered.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+// lookupContentCoding returns the coding of the Content-Encoding header, the coding is nil when the body is
+// not encoded. ok is false for encodings that can not be decoded, including bodies encoded more than once
+func lookupContentCoding(header http.Header) (coding *contentCoding, ok bool) {
+	value := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding")))
+	if value == "" || value == "identity" {
+		return nil, true
+	}
+	coding, ok = contentCodings[value]
+	return coding, ok
+}
+
+// decode returns the decompressed body, the decompressor is created on the first read so it does not block
+// the rewrite chain
+func (coding *contentCoding) decode(body io.ReadCloser) io.ReadCloser {
+	// the body was compressed by an earlier rewrite, its uncompressed form is used instead
+	if encoded, ok := body.(*encodedBody); ok && encoded.coding == coding && !encoded.started() {
+		return encoded.decoded
+	}
+	return &decodedBody{source: body, coding: coding}
+}
+
+// encode returns the body compressed with the coding
+func (coding *contentCoding) encode(body io.ReadCloser) io.ReadCloser {
+	reader, writer := io.Pipe()
+	return &encodedBody{PipeReader: reader, writer: writer, decoded: body, coding: coding}
+}
+
+// encodeBytes compresses the content with the coding
+func (coding *contentCoding) encodeBytes(content []byte) []byte {
+	var buffer bytes.Buffer
+	writer := coding.newWriter(&buffer)
+	// writes to a buffer do not fail
+	writer.Write(content)
+	writer.Close()
+	return buffer.Bytes()
+}
+
+// decodeBytes decompresses at most maxBytes of the content, exceeded is true when there is more
+func (coding *contentCoding) decodeBytes(content []byte, maxBytes int64) (decoded []byte, exceeded bool, err error) {
+	reader, err := coding.newReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, false, err
+	}
+	decoded, err = ioutil.ReadAll(io.LimitReader(reader, maxBytes+1))
+	if err != nil {
+		return nil, false, err
+	}
+	return decoded, int64(len(decoded)) > maxBytes, nil
+}
+
+// decodedBody decompresses the source while it is read
+type decodedBody struct {
+	source io.ReadCloser
+	coding *contentCoding
+	reader io.Reader
+	err    error
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	if d.reader == nil && d.err == nil {
+		d.reader, d.err = d.coding.newReader(d.source)
+		if d.err != nil {
+			d.err = fmt.Errorf("unable to decode %s body: %w", d.coding.name, d.err)
+		}
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.reader.Read(p)
+}
+
+func (d *decodedBody) Close() error {
+	if closer, ok := d.reader.(io.Closer); ok {
+		closer.Close()
+	}
+	return d.source.Close()
+}
+
+// encodedBody compresses the decoded body in the background once it is first read, each read of the decoded
+// body is flushed so streamed responses are not held back by the compressor
+type encodedBody struct {
+	*io.PipeReader
+	writer  *io.PipeWriter
+	decoded io.ReadCloser
+	coding  *contentCoding
+	once    sync.Once
+	lock    sync.Mutex
+	running bool
+	// done is closed once the compressor stops reading the decoded body
+	done chan struct{}
+}
+
+func (e *encodedBody) Read(p []byte) (int, error) {
+	e.once.Do(e.start)
+	return e.PipeReader.Read(p)
+}
+
+// Close stops the compressor and waits for it to finish its read of the decoded body before closing it, the
+// compressor is never started after the body is closed
+func (e *encodedBody) Close() error {
+	e.PipeReader.Close()
+	e.once.Do(func() {})
+	e.lock.Lock()
+	done := e.done
+	e.lock.Unlock()
+	if done != nil {
+		<-done
+	}
+	return e.decoded.Close()
+}
+
+func (e *encodedBody) started() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.running
+}
+
+func (e *encodedBody) start() {
+	done := make(chan struct{})
+	e.lock.Lock()
+	e.running = true
+	e.done = done
+	e.lock.Unlock()
+
+	go func() {
+		defer close(done)
+		compressor := e.coding.newWriter(e.writer)
+		buffer := make([]byte, streamChunkSize)
+		for {
+			n, err := e.decoded.Read(buffer)
+			if n > 0 {
+				if _, werr := compressor.Write(buffer[:n]); werr != nil {
+					e.writer.CloseWithError(werr)
+					return
+				}
+				if werr := compressor.Flush(); werr != nil {
+					e.writer.CloseWithError(werr)
+					return
+				}
+			}
+			if err == io.EOF {
+				e.writer.CloseWithError(compressor.Close())
+				return
+			}
+			if err != nil {
+				e.writer.CloseWithError(err)
+				return
+			}
+		}
+	}()
+}
+
+// addVary adds the header name to the Vary header unless it is already listed
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
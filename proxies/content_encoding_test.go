@@ -0,0 +1,234 @@
+package proxies_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func compress(coding string, content string) []byte {
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	switch coding {
+	case "gzip":
+		writer = gzip.NewWriter(&buffer)
+	case "deflate":
+		writer = zlib.NewWriter(&buffer)
+	case "raw deflate":
+		writer, _ = flate.NewWriter(&buffer, flate.DefaultCompression)
+	case "br":
+		writer = brotli.NewWriter(&buffer)
+	}
+	writer.Write([]byte(content))
+	writer.Close()
+	return buffer.Bytes()
+}
+
+func decompress(coding string, content []byte) string {
+	var reader io.Reader
+	var err error
+	switch coding {
+	case "gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(content))
+	case "deflate":
+		reader, err = zlib.NewReader(bytes.NewReader(content))
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(content))
+	}
+	Expect(err).To(BeNil())
+	decompressed, err := ioutil.ReadAll(reader)
+	Expect(err).To(BeNil())
+	return string(decompressed)
+}
+
+var _ = Describe("ContentEncoding", func() {
+	var (
+		backend     *httptest.Server
+		frontend    *httptest.Server
+		encoding    string
+		contentType string
+		content     []byte
+		received    chan *http.Request
+		bodies      chan []byte
+	)
+	// the client does not decompress so the encoding the proxy sends can be checked, requests name the
+	// encodings they accept so the transport of the proxy does not decompress either
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	BeforeEach(func() {
+		contentType = "text/html"
+		received = make(chan *http.Request, 1)
+		bodies = make(chan []byte, 1)
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			received <- r
+			bodies <- body
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Encoding", encoding)
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content)
+		}))
+	})
+	AfterEach(func() {
+		frontend.Close()
+		backend.Close()
+	})
+	start := func(bodyEncoding proxies.BodyEncoding) {
+		backendURL, err := url.Parse(backend.URL)
+		Expect(err).To(BeNil())
+		frontend = httptest.NewServer(proxies.NewReverseProxyBuilder().
+			RewriteHost(backendURL, "/").
+			RewriteResponseBody(backendURL, "/").
+			ReplaceRequestBody("before", "after").
+			ReplaceResponseBody("before", "after").
+			RewrittenBodyEncoding(bodyEncoding).
+			ToReverseProxy(nil))
+	}
+	get := func() (*http.Response, []byte) {
+		req, err := http.NewRequest("GET", frontend.URL, nil)
+		Expect(err).To(BeNil())
+		req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+		res, err := client.Do(req)
+		Expect(err).To(BeNil())
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).To(BeNil())
+		<-received
+		<-bodies
+		return res, body
+	}
+	for _, coding := range []string{"gzip", "deflate", "br"} {
+		coding := coding
+		It("rewrites "+coding+" responses and encodes them again", func() {
+			encoding = coding
+			start(proxies.ReencodeBody)
+			content = compress(coding, "<a href=\""+backend.URL+"/page\">before</a>")
+
+			res, body := get()
+			Expect(res.Header.Get("Content-Encoding")).To(Equal(coding))
+			Expect(res.Header.Get("Vary")).To(Equal("Accept-Encoding"))
+			Expect(res.ContentLength).To(Equal(int64(-1)))
+			Expect(decompress(coding, body)).To(Equal("<a href=\"" + frontend.URL + "/page\">after</a>"))
+		})
+	}
+	It("reads raw deflate data", func() {
+		encoding = "deflate"
+		start(proxies.DecodeBody)
+		content = compress("raw deflate", "before")
+
+		_, body := get()
+		Expect(string(body)).To(Equal("after"))
+	})
+	It("sends rewritten bodies uncompressed when asked to", func() {
+		encoding = "gzip"
+		start(proxies.DecodeBody)
+		content = compress("gzip", "before")
+
+		res, body := get()
+		Expect(res.Header.Get("Content-Encoding")).To(BeEmpty())
+		Expect(res.Header.Get("Vary")).To(Equal("Accept-Encoding"))
+		Expect(string(body)).To(Equal("after"))
+	})
+	It("leaves compressed binary bodies and unknown encodings alone", func() {
+		encoding = "gzip"
+		contentType = "image/png"
+		start(proxies.ReencodeBody)
+		content = compress("gzip", "before")
+		res, body := get()
+		Expect(body).To(Equal(content))
+		Expect(res.ContentLength).To(Equal(int64(len(content))))
+
+		encoding = "zstd"
+		contentType = "text/html"
+		content = []byte("before")
+		res, body = get()
+		Expect(string(body)).To(Equal("before"))
+		Expect(res.ContentLength).To(Equal(int64(len(content))))
+	})
+	It("stops compressing when the client goes away mid stream", func() {
+		stopped := make(chan struct{})
+		streaming := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer close(stopped)
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			writer := gzip.NewWriter(w)
+			for {
+				writer.Write([]byte("<p>before</p>"))
+				writer.Flush()
+				w.(http.Flusher).Flush()
+				select {
+				case <-r.Context().Done():
+					return
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+		}))
+		defer streaming.Close()
+		streamingURL, err := url.Parse(streaming.URL)
+		Expect(err).To(BeNil())
+		frontend = httptest.NewServer(proxies.NewReverseProxyBuilder().
+			RewriteHost(streamingURL, "/").
+			ReplaceResponseBody("before", "after").
+			ToReverseProxy(nil))
+
+		req, err := http.NewRequest("GET", frontend.URL, nil)
+		Expect(err).To(BeNil())
+		req.Header.Set("Accept-Encoding", "gzip")
+		res, err := client.Do(req)
+		Expect(err).To(BeNil())
+		reader, err := gzip.NewReader(res.Body)
+		Expect(err).To(BeNil())
+		chunk := make([]byte, len("<p>after</p>"))
+		_, err = io.ReadFull(reader, chunk)
+		Expect(err).To(BeNil())
+		Expect(string(chunk)).To(Equal("<p>after</p>"))
+		res.Body.Close()
+		client.Transport.(*http.Transport).CloseIdleConnections()
+
+		Eventually(stopped, 5*time.Second).Should(BeClosed())
+	})
+	It("rewrites compressed request bodies", func() {
+		encoding = ""
+		content = []byte("ok")
+		start(proxies.ReencodeBody)
+		req, err := http.NewRequest("POST", frontend.URL, bytes.NewReader(compress("gzip", "before")))
+		Expect(err).To(BeNil())
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-Encoding", "gzip")
+		res, err := client.Do(req)
+		Expect(err).To(BeNil())
+		res.Body.Close()
+
+		r := <-received
+		body := <-bodies
+		Expect(r.Header.Get("Content-Encoding")).To(Equal("gzip"))
+		Expect(r.ContentLength).To(Equal(int64(len(body))))
+		Expect(decompress("gzip", body)).To(Equal("after"))
+	})
+	It("rejects request bodies that can not be decompressed", func() {
+		encoding = ""
+		content = []byte("ok")
+		start(proxies.ReencodeBody)
+		req, err := http.NewRequest("POST", frontend.URL, bytes.NewReader([]byte("not gzip")))
+		Expect(err).To(BeNil())
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-Encoding", "gzip")
+		res, err := client.Do(req)
+		Expect(err).To(BeNil())
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,112 @@
+package proxies
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// sniffLength is the amount of a body without a content type that is used to detect it
+const sniffLength = 512
+
+// DefaultRewriteContentTypes are the media types the body rewrites change when no allow list is configured,
+// a * matches any subtype or, before a suffix like +json, any subtype with the suffix
+var DefaultRewriteContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/*+json",
+	"application/xml",
+	"application/*+xml",
+	"application/javascript",
+	"application/x-javascript",
+	"application/ecmascript",
+}
+
+// contentTypes is an allow list of media type patterns
+type contentTypes []string
+
+// allows reports whether the media type matches one of the patterns
+func (types contentTypes) allows(mediaType string) bool {
+	for _, pattern := range types {
+		if matchesMediaType(pattern, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesMediaType(pattern string, mediaType string) bool {
+	pattern = strings.ToLower(pattern)
+	star := strings.Index(pattern, "*")
+	if star < 0 {
+		return pattern == mediaType
+	}
+	prefix, suffix := pattern[:star], pattern[star+1:]
+	return len(mediaType) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(mediaType, prefix) &&
+		strings.HasSuffix(mediaType, suffix) &&
+		!strings.Contains(mediaType[len(prefix):len(mediaType)-len(suffix)], "/")
+}
+
+// textEncoding returns the encoding of a content type the allow list accepts, the encoding is nil for utf-8.
+// ok is false when the body must not be rewritten because the media type is not allowed or the charset is
+// unknown
+func (types contentTypes) textEncoding(contentType string) (encoding.Encoding, bool) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !types.allows(mediaType) {
+		return nil, false
+	}
+	label, ok := params["charset"]
+	if !ok {
+		return nil, true
+	}
+	e, err := htmlindex.Get(label)
+	if err != nil {
+		return nil, false
+	}
+	if name, _ := htmlindex.Name(e); name == "utf-8" {
+		return nil, true
+	}
+	return e, true
+}
+
+// sniffContentType detects the content type of a body that does not declare one, the returned body still
+// starts with the detected bytes
+func sniffContentType(body io.ReadCloser) (string, io.ReadCloser) {
+	buffered := bufio.NewReaderSize(body, sniffLength)
+	// a short body is detected from what could be read
+	start, _ := buffered.Peek(sniffLength)
+	return http.DetectContentType(start), readCloser{buffered, body}
+}
+
+// textBody prepares a body for a rewrite. The decoded body is utf-8 and encode turns the rewritten body back
+// into the charset of the content type. When ok is false the body must not be rewritten and the returned
+// body replaces the original because part of it may have been read to detect its type
+func (types contentTypes) textBody(header http.Header, body io.ReadCloser) (decoded io.ReadCloser, encode func(io.ReadCloser) io.ReadCloser, ok bool) {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType, body = sniffContentType(body)
+	}
+	e, ok := types.textEncoding(contentType)
+	if !ok {
+		return body, nil, false
+	}
+	if e == nil {
+		return body, identity, true
+	}
+	decoded = readCloser{transform.NewReader(body, e.NewDecoder()), body}
+	encode = func(rewritten io.ReadCloser) io.ReadCloser {
+		return readCloser{transform.NewReader(rewritten, encoding.ReplaceUnsupported(e.NewEncoder())), rewritten}
+	}
+	return decoded, encode, true
+}
+
+func identity(body io.ReadCloser) io.ReadCloser {
+	return body
+}
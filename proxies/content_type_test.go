@@ -0,0 +1,129 @@
+package proxies_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ContentType", func() {
+	var (
+		backend     *httptest.Server
+		frontend    *httptest.Server
+		contentType string
+		content     []byte
+		received    chan []byte
+	)
+	BeforeEach(func() {
+		received = make(chan []byte, 1)
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			select {
+			case received <- body:
+			default:
+			}
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content)
+		}))
+	})
+	AfterEach(func() {
+		frontend.Close()
+		backend.Close()
+	})
+	start := func(configure func(proxies.ReverseProxyBuilder) proxies.ReverseProxyBuilder) {
+		backendURL, err := url.Parse(backend.URL)
+		Expect(err).To(BeNil())
+		builder := proxies.NewReverseProxyBuilder().
+			RewriteHost(backendURL, "/").
+			ReplaceRequestBody("before", "after").
+			ReplaceResponseBody("before", "after")
+		frontend = httptest.NewServer(configure(builder).ToReverseProxy(nil))
+	}
+	unchanged := func(builder proxies.ReverseProxyBuilder) proxies.ReverseProxyBuilder {
+		return builder
+	}
+	get := func() (*http.Response, []byte) {
+		res, err := http.Get(frontend.URL)
+		Expect(err).To(BeNil())
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).To(BeNil())
+		return res, body
+	}
+	It("leaves binary bodies alone", func() {
+		contentType = "image/png"
+		content = []byte("\x89PNG before \x00\xff")
+		start(unchanged)
+		res, body := get()
+		Expect(body).To(Equal(content))
+		Expect(res.ContentLength).To(Equal(int64(len(content))))
+	})
+	It("rewrites json and xml media types", func() {
+		content = []byte(`{"state":"before"}`)
+		for _, contentType = range []string{"application/json", "application/problem+json", "application/soap+xml", "text/html; charset=utf-8"} {
+			start(unchanged)
+			_, body := get()
+			Expect(string(body)).To(Equal(`{"state":"after"}`), contentType)
+			frontend.Close()
+		}
+		start(unchanged)
+	})
+	It("detects the type of bodies without one", func() {
+		contentType = ""
+		content = []byte("plain text before")
+		start(unchanged)
+		_, body := get()
+		Expect(string(body)).To(Equal("plain text after"))
+	})
+	It("uses the configured allow list", func() {
+		contentType = "text/plain"
+		content = []byte("before")
+		start(func(builder proxies.ReverseProxyBuilder) proxies.ReverseProxyBuilder {
+			return builder.RewriteContentTypes("application/json")
+		})
+		_, body := get()
+		Expect(string(body)).To(Equal("before"))
+	})
+	It("rewrites response bodies in their charset", func() {
+		contentType = "text/plain; charset=iso-8859-1"
+		// café before in latin-1
+		content = []byte("caf\xe9 before")
+		start(func(builder proxies.ReverseProxyBuilder) proxies.ReverseProxyBuilder {
+			return builder.ReplaceResponseBody("é", "eè")
+		})
+		_, body := get()
+		Expect(body).To(Equal([]byte("cafe\xe8 after")))
+	})
+	It("rewrites request bodies in their charset", func() {
+		contentType = "text/plain"
+		content = []byte("ok")
+		start(func(builder proxies.ReverseProxyBuilder) proxies.ReverseProxyBuilder {
+			return builder.ReplaceRequestBody("ü", "ue")
+		})
+		res, err := http.Post(frontend.URL, "text/plain; charset=windows-1252", bytes.NewReader([]byte("gr\xfcn before")))
+		Expect(err).To(BeNil())
+		res.Body.Close()
+		Expect(<-received).To(Equal([]byte("gruen after")))
+	})
+	It("does not rewrite uploads of other types", func() {
+		contentType = "text/plain"
+		content = []byte("ok")
+		start(unchanged)
+		upload := []byte("PK\x03\x04 before")
+		res, err := http.Post(frontend.URL, "application/zip", bytes.NewReader(upload))
+		Expect(err).To(BeNil())
+		res.Body.Close()
+		Expect(<-received).To(Equal(upload))
+	})
+})
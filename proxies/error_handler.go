@@ -0,0 +1,81 @@
+package proxies
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// ErrorHandler answers a request whose rewrites or backend failed
+type ErrorHandler func(w http.ResponseWriter, request *http.Request, err error)
+
+// RewriteError is the error of a rewrite that failed, the request is answered with the status
+type RewriteError struct {
+	Status int
+	Err    error
+}
+
+func (e *RewriteError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RewriteError) Unwrap() error {
+	return e.Err
+}
+
+// NewRewriteError creates a rewrite error that is answered with the status
+func NewRewriteError(status int, format string, args ...interface{}) error {
+	return &RewriteError{Status: status, Err: fmt.Errorf(format, args...)}
+}
+
+// ErrorResponse describes the response sent when a rewrite or the backend fails
+type ErrorResponse struct {
+	// Status is the status code of the response, zero uses the status of the error
+	Status int
+	Body   string
+	Header http.Header
+}
+
+// Handler returns the error handler that writes the response
+func (e ErrorResponse) Handler() ErrorHandler {
+	return func(w http.ResponseWriter, request *http.Request, err error) {
+		status := e.Status
+		if status == 0 {
+			status = statusOf(err)
+		}
+		for name, values := range e.Header {
+			w.Header()[http.CanonicalHeaderKey(name)] = append([]string{}, values...)
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, e.Body)
+	}
+}
+
+// statusOf returns the status that answers the error
+func statusOf(err error) int {
+	var rewriteError *RewriteError
+	switch {
+	case errors.As(err, &rewriteError):
+		return rewriteError.Status
+	case errors.Is(err, ErrRequestBodyTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrMalformedRequestBody):
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+// handleError writes the status that matches the error, failures of the backend are bad gateways
+func handleError(w http.ResponseWriter, request *http.Request, err error) {
+	w.WriteHeader(statusOf(err))
+}
+
+// logErrors logs every failure before the handler answers it
+func logErrors(handler ErrorHandler) func(w http.ResponseWriter, request *http.Request, err error) {
+	return func(w http.ResponseWriter, request *http.Request, err error) {
+		log.Printf("http: proxy error: %s %s: %v", request.Method, request.URL.Path, err)
+		handler(w, request, err)
+	}
+}
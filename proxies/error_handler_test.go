@@ -0,0 +1,87 @@
+package proxies_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ErrorHandler", func() {
+	var (
+		backend  *httptest.Server
+		requests int
+		location string
+	)
+	BeforeEach(func() {
+		requests = 0
+		location = ""
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if location != "" {
+				w.Header().Set("Location", location)
+				w.WriteHeader(http.StatusFound)
+				return
+			}
+			w.Write([]byte("ok"))
+		}))
+	})
+	AfterEach(func() {
+		backend.Close()
+	})
+	get := func(builder proxies.ReverseProxyBuilder) (*http.Response, string) {
+		frontend := httptest.NewServer(builder.ToReverseProxy(nil))
+		defer frontend.Close()
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		res, err := client.Get(frontend.URL + "/")
+		Expect(err).To(BeNil())
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).To(BeNil())
+		return res, string(body)
+	}
+	builder := func() proxies.ReverseProxyBuilder {
+		backendURL, _ := url.Parse(backend.URL)
+		return proxies.NewReverseProxyBuilder().RewriteHost(backendURL, "/")
+	}
+	It("answers failed request rewrites without calling the backend", func() {
+		later := false
+		res, body := get(builder().
+			RequestRewriteFunc(func(r *http.Request) error {
+				return proxies.NewRewriteError(http.StatusForbidden, "denied")
+			}).
+			RequestRewrite(func(r *http.Request) { later = true }).
+			ErrorHandler(proxies.ErrorResponse{
+				Body:   "not allowed",
+				Header: http.Header{"Content-Type": {"text/plain"}},
+			}.Handler()))
+		Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+		Expect(res.Header.Get("Content-Type")).To(Equal("text/plain"))
+		Expect(body).To(Equal("not allowed"))
+		Expect(requests).To(Equal(0))
+		Expect(later).To(BeFalse())
+	})
+	It("answers failed response rewrites", func() {
+		res, body := get(builder().
+			ResponseRewriteFunc(func(r *http.Response) error {
+				return errors.New("broken")
+			}).
+			ErrorHandler(proxies.ErrorResponse{Status: http.StatusServiceUnavailable, Body: "try again"}.Handler()))
+		Expect(res.StatusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(body).To(Equal("try again"))
+		Expect(requests).To(Equal(1))
+	})
+	It("rejects invalid redirect locations", func() {
+		location = "http://[::1"
+		backendURL, _ := url.Parse(backend.URL)
+		res, _ := get(builder().RewriteRedirect(backendURL, "/"))
+		Expect(res.StatusCode).To(Equal(http.StatusBadGateway))
+	})
+})
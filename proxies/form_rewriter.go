@@ -0,0 +1,193 @@
+package proxies
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// formContentTypes are the media types of url encoded form bodies
+var formContentTypes = contentTypes{"application/x-www-form-urlencoded"}
+
+// defaultFormContentTypes are the form media types the form rewrites change when no allow list is configured
+var defaultFormContentTypes = contentTypes{"application/x-www-form-urlencoded", "multipart/form-data"}
+
+// formEdit returns the new value of a form field
+type formEdit func(name string, value string) string
+
+// rewriteFormBody rewrites the decoded values of url encoded form fields and the text parts of multipart forms,
+// other bodies and forms the content type allow list does not allow are left alone
+func rewriteFormBody(request *http.Request, edit formEdit, options bodyOptions) {
+	mediaType, params, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil || !options.forms.allows(mediaType) {
+		return
+	}
+	switch {
+	case formContentTypes.allows(mediaType):
+		options.types = formContentTypes
+		rewriteRequestBody(request, func(body io.ReadCloser) io.ReadCloser {
+			return newFormRewriter(body, edit)
+		}, options)
+	case mediaType == "multipart/form-data" && params["boundary"] != "":
+		rewriteMultipartBody(request, params["boundary"], edit, options)
+	}
+}
+
+// formRewriter rewrites the fields of a url encoded form on the first read. Fields that do not change keep their
+// original encoding
+type formRewriter struct {
+	source io.ReadCloser
+	edit   formEdit
+	output io.Reader
+}
+
+func (f *formRewriter) Read(p []byte) (int, error) {
+	if f.output == nil {
+		body, err := ioutil.ReadAll(f.source)
+		if err != nil {
+			return 0, err
+		}
+		f.output = bytes.NewReader(rewriteFormValues(body, f.edit))
+	}
+	return f.output.Read(p)
+}
+
+func (f *formRewriter) Close() error {
+	return f.source.Close()
+}
+
+func newFormRewriter(source io.ReadCloser, edit formEdit) io.ReadCloser {
+	return &formRewriter{source: source, edit: edit}
+}
+
+func rewriteFormValues(body []byte, edit formEdit) []byte {
+	pairs := strings.Split(string(body), "&")
+	for i, pair := range pairs {
+		rawName, rawValue := pair, ""
+		if index := strings.Index(pair, "="); index >= 0 {
+			rawName, rawValue = pair[:index], pair[index+1:]
+		}
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			continue
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			continue
+		}
+		if rewritten := edit(name, value); rewritten != value {
+			pairs[i] = rawName + "=" + url.QueryEscape(rewritten)
+		}
+	}
+	return []byte(strings.Join(pairs, "&"))
+}
+
+// rewriteMultipartBody streams the parts of a multipart form to the backend, text parts up to the body limit are
+// rewritten and file parts are copied unchanged. The length of the rewritten body is not known up front so it
+// is sent chunked and can not be replayed
+func rewriteMultipartBody(request *http.Request, boundary string, edit formEdit, options bodyOptions) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return
+	}
+	state := requestStateOf(request)
+	if state != nil && (state.failed() != nil || state.bodyPassedThrough()) {
+		return
+	}
+	if coding, ok := lookupContentCoding(request.Header); !ok || coding != nil {
+		return
+	}
+	original := request.Body
+	reader, writer := io.Pipe()
+	go func() {
+		err := copyMultipart(writer, original, boundary, edit, options)
+		if err != nil {
+			err = fmt.Errorf("%w: %v", ErrMalformedRequestBody, err)
+		}
+		writer.CloseWithError(err)
+	}()
+	request.Body = readCloser{reader, closers{reader, original}}
+	request.ContentLength = -1
+	request.Header.Del("Content-Length")
+	request.GetBody = nil
+}
+
+// closers closes every closer and returns the first error
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var first error
+	for _, closer := range c {
+		if err := closer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func copyMultipart(dst io.Writer, src io.Reader, boundary string, edit formEdit, options bodyOptions) error {
+	reader := multipart.NewReader(src, boundary)
+	writer := multipart.NewWriter(dst)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return writer.Close()
+		}
+		if err != nil {
+			return err
+		}
+		w, err := writer.CreatePart(part.Header)
+		if err != nil {
+			return err
+		}
+		if isTextPart(part, options.types) {
+			err = copyTextPart(w, part, edit, options.limit.maxBytes)
+		} else {
+			_, err = io.Copy(w, part)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// isTextPart reports whether the part is a form field rather than a file, fields without a content type are text
+func isTextPart(part *multipart.Part, types contentTypes) bool {
+	if part.FileName() != "" || part.FormName() == "" {
+		return false
+	}
+	if part.Header.Get("Content-Transfer-Encoding") != "" {
+		return false
+	}
+	contentType := part.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	e, ok := types.textEncoding(contentType)
+	return ok && e == nil
+}
+
+// copyTextPart rewrites a text part, parts larger than maxBytes are copied unchanged
+func copyTextPart(w io.Writer, part *multipart.Part, edit formEdit, maxBytes int64) error {
+	value, err := ioutil.ReadAll(io.LimitReader(part, maxBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(value)) > maxBytes {
+		if _, err := w.Write(value); err != nil {
+			return err
+		}
+		_, err = io.Copy(w, part)
+		return err
+	}
+	_, err = io.WriteString(w, edit(part.FormName(), string(value)))
+	return err
+}
@@ -0,0 +1,109 @@
+package proxies_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FormRewriter", func() {
+	var (
+		backend  *httptest.Server
+		frontend *httptest.Server
+		received *http.Request
+		body     []byte
+	)
+	BeforeEach(func() {
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r
+			body, _ = ioutil.ReadAll(r.Body)
+		}))
+		backendURL, _ := url.Parse(backend.URL)
+		frontend = httptest.NewServer(proxies.NewReverseProxyBuilder().
+			RewriteHost(backendURL, "/").
+			RewriteRequestBody(backendURL, "/").
+			ReplaceRequestFormField("user", "^internal-", "").
+			ToReverseProxy(nil))
+	})
+	AfterEach(func() {
+		backend.Close()
+		frontend.Close()
+	})
+	// post sends the form through a frontend that announces its scheme, like a tls terminating load balancer
+	post := func(path string, contentType string, body io.Reader) *http.Response {
+		request, err := http.NewRequest("POST", frontend.URL+path, body)
+		Expect(err).To(BeNil())
+		request.Header.Set("Content-Type", contentType)
+		request.Header.Set("X-Forwarded-Proto", "http")
+		res, err := http.DefaultClient.Do(request)
+		Expect(err).To(BeNil())
+		return res
+	}
+	It("rewrites decoded form values", func() {
+		form := "redirect_uri=" + url.QueryEscape(frontend.URL+"/callback") + "&state=a%20b+c&user=internal-bob&other=internal-bob"
+		res := post("/callback", "application/x-www-form-urlencoded", strings.NewReader(form))
+		res.Body.Close()
+
+		expected := "redirect_uri=" + url.QueryEscape(backend.URL) + "&state=a%20b+c&user=bob&other=internal-bob"
+		Expect(string(body)).To(Equal(expected))
+		Expect(received.ContentLength).To(Equal(int64(len(expected))))
+	})
+	It("rewrites text parts and copies file parts of multipart forms", func() {
+		file := append([]byte(frontend.URL+"/callback"), 0, 1, 2, 0xff)
+		upload := &bytes.Buffer{}
+		writer := multipart.NewWriter(upload)
+		writer.WriteField("redirect_uri", frontend.URL+"/callback")
+		writer.WriteField("user", "internal-bob")
+		part, _ := writer.CreateFormFile("upload", "a.bin")
+		part.Write(file)
+		writer.Close()
+
+		res := post("/callback", writer.FormDataContentType(), upload)
+		res.Body.Close()
+
+		reader := multipart.NewReader(bytes.NewReader(body), writer.Boundary())
+		form, err := reader.ReadForm(1 << 20)
+		Expect(err).To(BeNil())
+		Expect(form.Value["redirect_uri"]).To(Equal([]string{backend.URL}))
+		Expect(form.Value["user"]).To(Equal([]string{"bob"}))
+		uploaded, _ := form.File["upload"][0].Open()
+		content, _ := ioutil.ReadAll(uploaded)
+		Expect(content).To(Equal(file))
+		Expect(received.TransferEncoding).To(Equal([]string{"chunked"}))
+	})
+	It("leaves forms alone that the content type allow list does not allow", func() {
+		var body []byte
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ = ioutil.ReadAll(r.Body)
+		}))
+		defer backend.Close()
+		backendURL, _ := url.Parse(backend.URL)
+		narrowed := httptest.NewServer(proxies.NewReverseProxyBuilder().
+			RewriteHost(backendURL, "/").
+			RewriteContentTypes("application/json").
+			ReplaceRequestFormField("user", "^internal-", "").
+			ToReverseProxy(nil))
+		defer narrowed.Close()
+
+		res, err := http.Post(narrowed.URL+"/callback", "application/x-www-form-urlencoded", strings.NewReader("user=internal-bob"))
+		Expect(err).To(BeNil())
+		res.Body.Close()
+		Expect(string(body)).To(Equal("user=internal-bob"))
+	})
+	It("rejects malformed multipart forms", func() {
+		res, err := http.Post(frontend.URL+"/callback", "multipart/form-data; boundary=x", strings.NewReader("--x\r\nbroken"))
+		Expect(err).To(BeNil())
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,106 @@
+package proxies_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Headers", func() {
+	Context("request", func() {
+		rewrite := func(builder proxies.ReverseProxyBuilder, header http.Header) http.Header {
+			request := httptest.NewRequest("GET", "http://frontend/", nil)
+			for name, values := range header {
+				request.Header[name] = values
+			}
+			builder.ToReverseProxy(nil).Director(request)
+			return request.Header
+		}
+		It("adds, sets, copies and deletes", func() {
+			header := rewrite(proxies.NewReverseProxyBuilder().
+				AddRequestHeader("X-Added", "a").
+				AddRequestHeader("X-Added", "b").
+				SetRequestHeader("X-Set", "c").
+				CopyRequestHeader("X-Source", "X-Destination").
+				DeleteRequestHeaderIf("X-Deleted", func(r *http.Request) bool { return true }),
+				http.Header{"X-Set": {"old"}, "X-Source": {"copied"}, "X-Deleted": {"gone"}})
+			Expect(header["X-Added"]).To(Equal([]string{"a", "b"}))
+			Expect(header["X-Set"]).To(Equal([]string{"c"}))
+			Expect(header["X-Destination"]).To(Equal([]string{"copied"}))
+			Expect(header).ToNot(HaveKey("X-Deleted"))
+		})
+		It("replaces values", func() {
+			header := rewrite(proxies.NewReverseProxyBuilder().
+				ReplaceRequestHeader("X-Whole", "^internal", "public").
+				ReplaceRequestHeaderValue("X-List", "^internal", "public"),
+				http.Header{"X-Whole": {"internal,internal"}, "X-List": {"internal,internal"}})
+			Expect(header["X-Whole"]).To(Equal([]string{"public,internal"}))
+			Expect(header["X-List"]).To(Equal([]string{"public,public"}))
+		})
+		It("skips rewrites when the condition does not match", func() {
+			never := func(r *http.Request) bool { return false }
+			header := rewrite(proxies.NewReverseProxyBuilder().
+				AddRequestHeaderIf("X-Added", "a", never).
+				SetRequestHeaderIf("X-Set", "c", never),
+				http.Header{"X-Set": {"old"}})
+			Expect(header).ToNot(HaveKey("X-Added"))
+			Expect(header["X-Set"]).To(Equal([]string{"old"}))
+		})
+	})
+	Context("response", func() {
+		rewrite := func(builder proxies.ReverseProxyBuilder, status int, header http.Header) http.Header {
+			response := &http.Response{StatusCode: status, Header: header}
+			Expect(builder.ToReverseProxy(nil).ModifyResponse(response)).To(Succeed())
+			return response.Header
+		}
+		It("adds, sets, copies and deletes", func() {
+			header := rewrite(proxies.NewReverseProxyBuilder().
+				AddResponseHeader("X-Added", "a").
+				AddResponseHeader("X-Added", "b").
+				SetResponseHeader("X-Set", "c").
+				CopyResponseHeader("X-Source", "X-Destination").
+				CopyResponseHeader("X-Missing", "X-Set").
+				DeleteResponseHeader("Server"),
+				http.StatusOK,
+				http.Header{"X-Set": {"old"}, "X-Source": {"one", "two"}, "Server": {"internal"}})
+			Expect(header["X-Added"]).To(Equal([]string{"a", "b"}))
+			Expect(header["X-Set"]).To(Equal([]string{"c"}))
+			Expect(header["X-Destination"]).To(Equal([]string{"one", "two"}))
+			Expect(header).ToNot(HaveKey("Server"))
+		})
+		It("replaces values", func() {
+			header := rewrite(proxies.NewReverseProxyBuilder().
+				ReplaceResponseHeader("Set-Cookie", "Domain=internal", "Domain=public").
+				ReplaceResponseHeaderValue("Allow", "^\\s*DELETE$", " PURGE").
+				ReplaceResponseHeader("X-Missing", ".*", "added"),
+				http.StatusOK,
+				http.Header{
+					"Set-Cookie": {"a=1; Domain=internal", "b=2; Domain=internal"},
+					"Allow":      {"GET, DELETE"},
+				})
+			Expect(header["Set-Cookie"]).To(Equal([]string{"a=1; Domain=public", "b=2; Domain=public"}))
+			Expect(header["Allow"]).To(Equal([]string{"GET, PURGE"}))
+			Expect(header).ToNot(HaveKey("X-Missing"))
+		})
+		It("skips rewrites when the condition does not match", func() {
+			notFound := func(r *http.Response) bool { return r.StatusCode == http.StatusNotFound }
+			builder := proxies.NewReverseProxyBuilder().
+				AddResponseHeaderIf("X-Added", "a", notFound).
+				SetResponseHeaderIf("X-Set", "c", notFound).
+				CopyResponseHeaderIf("X-Set", "X-Copy", notFound).
+				DeleteResponseHeaderIf("X-Deleted", notFound).
+				ReplaceResponseHeaderIf("X-Replaced", "old", "new", notFound).
+				ReplaceResponseHeaderValueIf("X-Replaced", "old", "new", notFound)
+
+			header := rewrite(builder, http.StatusOK, http.Header{"X-Set": {"old"}, "X-Deleted": {"d"}, "X-Replaced": {"old"}})
+			Expect(header).To(Equal(http.Header{"X-Set": {"old"}, "X-Deleted": {"d"}, "X-Replaced": {"old"}}))
+
+			header = rewrite(builder, http.StatusNotFound, http.Header{"X-Set": {"old"}, "X-Deleted": {"d"}, "X-Replaced": {"old"}})
+			Expect(header).To(Equal(http.Header{"X-Added": {"a"}, "X-Set": {"c"}, "X-Copy": {"c"}, "X-Replaced": {"new"}}))
+		})
+	})
+})
@@ -0,0 +1,246 @@
+package proxies
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHealthCheckInterval is the time between health checks when no interval is configured
+	DefaultHealthCheckInterval = 10 * time.Second
+	// DefaultHealthCheckTimeout is the time a health check may take when no timeout is configured
+	DefaultHealthCheckTimeout = 2 * time.Second
+	// DefaultHealthyThreshold is the number of consecutive successes that restore a backend
+	DefaultHealthyThreshold = 2
+	// DefaultUnhealthyThreshold is the number of consecutive failures that eject a backend
+	DefaultUnhealthyThreshold = 3
+)
+
+// HealthCheck describes how the members of a backend pool are probed
+type HealthCheck struct {
+	// Path is joined to the path of each backend url
+	Path string
+	// ExpectedStatus is the status code of a healthy backend, any 2xx status when zero
+	ExpectedStatus     int
+	Interval           time.Duration
+	Timeout            time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// HealthStatus describes the health of a backend pool member
+type HealthStatus struct {
+	URL                  string    `json:"url"`
+	Healthy              bool      `json:"healthy"`
+	ConsecutiveSuccesses int       `json:"consecutiveSuccesses"`
+	ConsecutiveFailures  int       `json:"consecutiveFailures"`
+	LastCheck            time.Time `json:"lastCheck"`
+	LastError            string    `json:"lastError,omitempty"`
+}
+
+// HealthChecker actively probes the members of a backend pool, removing unhealthy members from selection
+// and restoring them once they recover
+type HealthChecker interface {
+	Start()
+	Stop()
+	Check()
+	Status() []HealthStatus
+}
+
+type healthState struct {
+	successes int
+	failures  int
+	lastCheck time.Time
+	lastError string
+}
+
+type healthChecker struct {
+	pool   BackendPool
+	check  HealthCheck
+	client *http.Client
+	lock   sync.Mutex
+	states map[Backend]*healthState
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func (checker *healthChecker) Start() {
+	checker.lock.Lock()
+	defer checker.lock.Unlock()
+	if checker.stop != nil {
+		return
+	}
+	checker.stop = make(chan struct{})
+	checker.done = make(chan struct{})
+
+	go func(stop chan struct{}, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(checker.check.Interval)
+		defer ticker.Stop()
+		for {
+			checker.Check()
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}(checker.stop, checker.done)
+}
+
+func (checker *healthChecker) Stop() {
+	checker.lock.Lock()
+	stop, done := checker.stop, checker.done
+	checker.stop, checker.done = nil, nil
+	checker.lock.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// Check probes every member of the pool once and waits for the results
+func (checker *healthChecker) Check() {
+	wg := sync.WaitGroup{}
+	for _, b := range checker.pool.Backends() {
+		wg.Add(1)
+		go func(b Backend) {
+			defer wg.Done()
+			checker.record(b, checker.probe(b))
+		}(b)
+	}
+	wg.Wait()
+}
+
+func (checker *healthChecker) probe(b Backend) error {
+	probeURL := *b.URL()
+	probeURL.Path = SingleJoiningSlash(probeURL.Path, checker.check.Path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), checker.check.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequest(http.MethodGet, probeURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	response, err := checker.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if checker.check.ExpectedStatus == 0 {
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			return fmt.Errorf("unexpected status %d", response.StatusCode)
+		}
+		return nil
+	}
+	if response.StatusCode != checker.check.ExpectedStatus {
+		return fmt.Errorf("unexpected status %d, expected %d", response.StatusCode, checker.check.ExpectedStatus)
+	}
+	return nil
+}
+
+func (checker *healthChecker) record(b Backend, err error) {
+	checker.lock.Lock()
+	defer checker.lock.Unlock()
+
+	state := checker.states[b]
+	state.lastCheck = time.Now()
+	if err != nil {
+		state.successes = 0
+		state.failures++
+		state.lastError = err.Error()
+		if b.Healthy() && state.failures >= checker.check.UnhealthyThreshold {
+			log.Printf("backend %s is unhealthy: %v", b.URL(), err)
+			b.setHealthy(false)
+		}
+		return
+	}
+
+	state.failures = 0
+	state.successes++
+	state.lastError = ""
+	if !b.Healthy() && state.successes >= checker.check.HealthyThreshold {
+		log.Printf("backend %s is healthy", b.URL())
+		b.setHealthy(true)
+	}
+}
+
+func (checker *healthChecker) Status() []HealthStatus {
+	checker.lock.Lock()
+	defer checker.lock.Unlock()
+
+	statuses := []HealthStatus{}
+	for _, b := range checker.pool.Backends() {
+		state := checker.states[b]
+		statuses = append(statuses, HealthStatus{
+			URL:                  b.URL().String(),
+			Healthy:              b.Healthy(),
+			ConsecutiveSuccesses: state.successes,
+			ConsecutiveFailures:  state.failures,
+			LastCheck:            state.lastCheck,
+			LastError:            state.lastError,
+		})
+	}
+	return statuses
+}
+
+// HealthHandler serves the health status of the backends of every checker as json
+func HealthHandler(checkers ...HealthChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statuses := []HealthStatus{}
+		for _, checker := range checkers {
+			statuses = append(statuses, checker.Status()...)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+	})
+}
+
+// NewHealthChecker creates a health checker for the members of the pool, zero values in the health check
+// are replaced with defaults. Checks run in the background once the checker is started
+func NewHealthChecker(pool BackendPool, check HealthCheck, transport http.RoundTripper) HealthChecker {
+	if check.Interval <= 0 {
+		check.Interval = DefaultHealthCheckInterval
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = DefaultHealthCheckTimeout
+	}
+	if check.HealthyThreshold <= 0 {
+		check.HealthyThreshold = DefaultHealthyThreshold
+	}
+	if check.UnhealthyThreshold <= 0 {
+		check.UnhealthyThreshold = DefaultUnhealthyThreshold
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	states := map[Backend]*healthState{}
+	for _, b := range pool.Backends() {
+		states[b] = &healthState{}
+	}
+	return &healthChecker{
+		pool:  pool,
+		check: check,
+		client: &http.Client{
+			Transport: transport,
+			// a redirect is an answer from the backend, so it is not followed
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		states: states,
+	}
+}
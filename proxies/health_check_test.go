@@ -0,0 +1,121 @@
+package proxies_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HealthChecker", func() {
+	var (
+		server  *httptest.Server
+		status  int32
+		paths   chan string
+		pool    proxies.BackendPool
+		members []proxies.Backend
+	)
+	BeforeEach(func() {
+		atomic.StoreInt32(&status, http.StatusOK)
+		paths = make(chan string, 100)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths <- r.URL.Path
+			w.WriteHeader(int(atomic.LoadInt32(&status)))
+		}))
+		serverURL, err := url.Parse(server.URL + "/base")
+		Expect(err).To(BeNil())
+		otherURL, err := url.Parse("http://other")
+		Expect(err).To(BeNil())
+		members = []proxies.Backend{
+			proxies.NewBackend(serverURL, 1),
+			proxies.NewBackend(otherURL, 1),
+		}
+		pool = proxies.NewBackendPool(nil, members[0])
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+	It("ejects and restores backends using the thresholds", func() {
+		checker := proxies.NewHealthChecker(pool, proxies.HealthCheck{
+			Path:               "/health",
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 2,
+		}, nil)
+
+		checker.Check()
+		Expect(<-paths).To(Equal("/base/health"))
+		Expect(members[0].Healthy()).To(BeTrue())
+
+		atomic.StoreInt32(&status, http.StatusInternalServerError)
+		checker.Check()
+		Expect(members[0].Healthy()).To(BeTrue())
+		checker.Check()
+		Expect(members[0].Healthy()).To(BeFalse())
+		Expect(pool.Select(nil)).To(BeNil())
+
+		statuses := checker.Status()
+		Expect(statuses).To(HaveLen(1))
+		Expect(statuses[0].ConsecutiveFailures).To(Equal(2))
+		Expect(statuses[0].LastError).To(Equal("unexpected status 500"))
+
+		atomic.StoreInt32(&status, http.StatusOK)
+		checker.Check()
+		Expect(members[0].Healthy()).To(BeFalse())
+		checker.Check()
+		Expect(members[0].Healthy()).To(BeTrue())
+		Expect(pool.Select(nil)).To(Equal(members[0]))
+	})
+	It("uses the expected status", func() {
+		atomic.StoreInt32(&status, http.StatusNoContent)
+		checker := proxies.NewHealthChecker(pool, proxies.HealthCheck{
+			ExpectedStatus:     http.StatusOK,
+			UnhealthyThreshold: 1,
+		}, nil)
+		checker.Check()
+		Expect(members[0].Healthy()).To(BeFalse())
+	})
+	It("selects only healthy members", func() {
+		pool = proxies.NewBackendPool(nil, members...)
+		checker := proxies.NewHealthChecker(proxies.NewBackendPool(nil, members[1]), proxies.HealthCheck{
+			Timeout:            100 * time.Millisecond,
+			UnhealthyThreshold: 1,
+		}, nil)
+		checker.Check()
+		Expect(members[1].Healthy()).To(BeFalse())
+		for i := 0; i < 3; i++ {
+			Expect(pool.Select(nil)).To(Equal(members[0]))
+		}
+	})
+	It("checks in the background until stopped", func() {
+		checker := proxies.NewHealthChecker(pool, proxies.HealthCheck{
+			Interval:           10 * time.Millisecond,
+			UnhealthyThreshold: 1,
+		}, nil)
+		atomic.StoreInt32(&status, http.StatusInternalServerError)
+		checker.Start()
+		defer checker.Stop()
+		Eventually(members[0].Healthy).Should(BeFalse())
+	})
+	It("serves the status as json", func() {
+		checker := proxies.NewHealthChecker(pool, proxies.HealthCheck{}, nil)
+		checker.Check()
+
+		recorder := httptest.NewRecorder()
+		proxies.HealthHandler(checker).ServeHTTP(recorder, httptest.NewRequest("GET", "/health", nil))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		statuses := []proxies.HealthStatus{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &statuses)).To(Succeed())
+		Expect(statuses).To(HaveLen(1))
+		Expect(statuses[0].URL).To(Equal(server.URL + "/base"))
+		Expect(statuses[0].Healthy).To(BeTrue())
+		Expect(statuses[0].ConsecutiveSuccesses).To(Equal(1))
+	})
+})
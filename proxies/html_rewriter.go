@@ -0,0 +1,206 @@
+package proxies
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// HTMLOptions selects what the html rewrite changes besides the url attributes of elements
+type HTMLOptions struct {
+	// Scripts maps absolute backend urls inside script elements
+	Scripts bool
+	// Text maps absolute backend urls in the text of the document
+	Text bool
+}
+
+// htmlContentTypes are the media types the html rewrite parses
+var htmlContentTypes = contentTypes{"text/html", "application/xhtml+xml"}
+
+// htmlURLAttributes are the attributes that hold a single url
+var htmlURLAttributes = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"action":     true,
+	"formaction": true,
+}
+
+// htmlRewriter maps the urls of an html document token by token while it is read
+type htmlRewriter struct {
+	source    io.ReadCloser
+	tokenizer *html.Tokenizer
+	mapper    *urlMapper
+	options   HTMLOptions
+	// rawText is the element whose content is currently read, like script or style
+	rawText string
+	output  []byte
+	err     error
+}
+
+func (h *htmlRewriter) Read(p []byte) (int, error) {
+	for len(h.output) == 0 {
+		if h.err != nil {
+			return 0, h.err
+		}
+		h.next()
+	}
+	n := copy(p, h.output)
+	h.output = h.output[n:]
+	return n, nil
+}
+
+func (h *htmlRewriter) Close() error {
+	return h.source.Close()
+}
+
+// next rewrites the next token of the document
+func (h *htmlRewriter) next() {
+	tokenType := h.tokenizer.Next()
+	switch tokenType {
+	case html.ErrorToken:
+		h.err = h.tokenizer.Err()
+		// the tokenizer holds back nothing once the input ends
+		h.output = append(h.output[:0], h.tokenizer.Raw()...)
+	case html.StartTagToken, html.SelfClosingTagToken:
+		token := h.tokenizer.Token()
+		h.rawText = ""
+		if tokenType == html.StartTagToken && (token.Data == "script" || token.Data == "style") {
+			h.rawText = token.Data
+		}
+		if h.mapAttributes(&token) {
+			h.output = append(h.output[:0], token.String()...)
+			return
+		}
+		h.output = append(h.output[:0], h.tokenizer.Raw()...)
+	case html.EndTagToken:
+		h.rawText = ""
+		h.output = append(h.output[:0], h.tokenizer.Raw()...)
+	case html.TextToken:
+		h.output = append(h.output[:0], h.mapText(string(h.tokenizer.Raw()))...)
+	default:
+		h.output = append(h.output[:0], h.tokenizer.Raw()...)
+	}
+}
+
+// mapText maps the urls of text and raw text elements the options ask for
+func (h *htmlRewriter) mapText(text string) string {
+	switch h.rawText {
+	case "style":
+		return h.mapper.mapCSSURLs(text)
+	case "script":
+		if h.options.Scripts {
+			return h.mapper.mapAbsoluteURLs(text)
+		}
+		return text
+	}
+	if h.options.Text {
+		return h.mapper.mapAbsoluteURLs(text)
+	}
+	return text
+}
+
+// mapAttributes maps the url attributes of the element and reports whether any changed
+func (h *htmlRewriter) mapAttributes(token *html.Token) bool {
+	changed := false
+	refresh := token.Data == "meta" && strings.EqualFold(attribute(token, "http-equiv"), "refresh")
+	for i := range token.Attr {
+		attr := &token.Attr[i]
+		if attr.Namespace != "" {
+			continue
+		}
+		value := attr.Val
+		switch {
+		case htmlURLAttributes[attr.Key]:
+			value = h.mapper.mapURL(attr.Val)
+		case attr.Key == "srcset":
+			value = h.mapSrcset(attr.Val)
+		case attr.Key == "style":
+			value = h.mapper.mapCSSURLs(attr.Val)
+		case attr.Key == "content" && refresh:
+			value = h.mapRefresh(attr.Val)
+		}
+		if value != attr.Val {
+			attr.Val = value
+			changed = true
+		}
+	}
+	return changed
+}
+
+// mapSrcset maps the urls of the image candidates. Each candidate is a url up to whitespace followed by an
+// optional descriptor up to a comma, so commas inside urls like data: urls stay part of the url
+func (h *htmlRewriter) mapSrcset(srcset string) string {
+	mapped := &strings.Builder{}
+	i := 0
+	for i < len(srcset) {
+		start := i
+		for i < len(srcset) && (isHTMLSpace(srcset[i]) || srcset[i] == ',') {
+			i++
+		}
+		mapped.WriteString(srcset[start:i])
+
+		start = i
+		for i < len(srcset) && !isHTMLSpace(srcset[i]) {
+			i++
+		}
+		// commas at the end of the url end the candidate without a descriptor
+		url := strings.TrimRight(srcset[start:i], ",")
+		if url != "" {
+			mapped.WriteString(h.mapper.mapURL(url))
+		}
+		mapped.WriteString(srcset[start+len(url) : i])
+		if start+len(url) < i {
+			continue
+		}
+
+		// the descriptor ends at the next comma outside parentheses
+		start = i
+		depth := 0
+		for i < len(srcset) && (srcset[i] != ',' || depth > 0) {
+			switch srcset[i] {
+			case '(':
+				depth++
+			case ')':
+				if depth > 0 {
+					depth--
+				}
+			}
+			i++
+		}
+		mapped.WriteString(srcset[start:i])
+	}
+	return mapped.String()
+}
+
+func isHTMLSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\f' || b == '\r'
+}
+
+// mapRefresh maps the url of a refresh like "5; url=/next"
+func (h *htmlRewriter) mapRefresh(content string) string {
+	index := strings.Index(strings.ToLower(content), "url=")
+	if index < 0 {
+		return content
+	}
+	target := strings.Trim(strings.TrimSpace(content[index+len("url="):]), `'"`)
+	return content[:index+len("url=")] + h.mapper.mapURL(target)
+}
+
+func attribute(token *html.Token, key string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func newHTMLRewriter(source io.ReadCloser, mapper *urlMapper, options HTMLOptions) io.ReadCloser {
+	return &htmlRewriter{
+		source:    source,
+		tokenizer: html.NewTokenizer(source),
+		mapper:    mapper,
+		options:   options,
+	}
+}
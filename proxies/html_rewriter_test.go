@@ -0,0 +1,79 @@
+package proxies_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing/iotest"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTMLRewriter", func() {
+	backendURL, _ := url.Parse("http://backend:8080/base")
+	document := `<!DOCTYPE html><html><head>` +
+		`<base href="http://backend:8080/base/">` +
+		`<meta http-equiv="refresh" content="5; url=/base/next">` +
+		`<style>body { background: url('/base/bg.png') }</style>` +
+		`</head><body>` +
+		`<a href="/base/login">login</a><a href="/other">other</a><a href="http://elsewhere/base/x">elsewhere</a>` +
+		`<img src="//backend:8080/base/a.png" srcset="/base/a.png 1x, /base/b.png 2x">` +
+		`<form action="/base/post"><button formaction="/base/alt">alt</button></form>` +
+		`<div style="background: url(/base/d.png)"></div>` +
+		`<script>var api = "http://backend:8080/base/api";</script>` +
+		`<p>see http://backend:8080/base/doc</p>` +
+		`</body></html>`
+	rewrite := func(options proxies.HTMLOptions, contentType string) string {
+		request := httptest.NewRequest("GET", "http://frontend/app/page", nil)
+		response := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{contentType}},
+			Body:       ioutil.NopCloser(iotest.OneByteReader(strings.NewReader(document))),
+			Request:    request,
+		}
+		builder := proxies.NewReverseProxyBuilder().RewriteHTML(backendURL, "/app", options)
+		Expect(builder.ToReverseProxy(nil).ModifyResponse(response)).To(Succeed())
+		return readAll(response)
+	}
+	It("maps url attributes, refreshes and styles", func() {
+		Expect(rewrite(proxies.HTMLOptions{}, "text/html; charset=utf-8")).To(Equal(`<!DOCTYPE html><html><head>` +
+			`<base href="http://frontend/app/">` +
+			`<meta http-equiv="refresh" content="5; url=/app/next">` +
+			`<style>body { background: url('/app/bg.png') }</style>` +
+			`</head><body>` +
+			`<a href="/app/login">login</a><a href="/other">other</a><a href="http://elsewhere/base/x">elsewhere</a>` +
+			`<img src="//frontend/app/a.png" srcset="/app/a.png 1x, /app/b.png 2x">` +
+			`<form action="/app/post"><button formaction="/app/alt">alt</button></form>` +
+			`<div style="background: url(/app/d.png)"></div>` +
+			`<script>var api = "http://backend:8080/base/api";</script>` +
+			`<p>see http://backend:8080/base/doc</p>` +
+			`</body></html>`))
+	})
+	It("keeps commas inside srcset urls", func() {
+		request := httptest.NewRequest("GET", "http://frontend/app/page", nil)
+		response := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/html"}},
+			Body: ioutil.NopCloser(strings.NewReader(
+				`<img srcset="data:image/png;base64,iVBORw0KGgo= 1x,/base/a,b.png 2x, /base/c.png,, /base/d.png 3x">`)),
+			Request: request,
+		}
+		builder := proxies.NewReverseProxyBuilder().RewriteHTML(backendURL, "/app", proxies.HTMLOptions{})
+		Expect(builder.ToReverseProxy(nil).ModifyResponse(response)).To(Succeed())
+		Expect(readAll(response)).To(Equal(
+			`<img srcset="data:image/png;base64,iVBORw0KGgo= 1x,/app/a,b.png 2x, /app/c.png,, /app/d.png 3x">`))
+	})
+	It("maps scripts and text when asked to", func() {
+		rewritten := rewrite(proxies.HTMLOptions{Scripts: true, Text: true}, "text/html")
+		Expect(rewritten).To(ContainSubstring(`<script>var api = "http://frontend/app/api";</script>`))
+		Expect(rewritten).To(ContainSubstring(`<p>see http://frontend/app/doc</p>`))
+	})
+	It("leaves other content types alone", func() {
+		Expect(rewrite(proxies.HTMLOptions{}, "application/json")).To(Equal(document))
+	})
+})
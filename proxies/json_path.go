@@ -0,0 +1,136 @@
+package proxies
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonSegmentKind is the kind of step a json location takes from a value to its children
+type jsonSegmentKind int
+
+const (
+	// jsonKey selects an object member, or an array element when the key is a number
+	jsonKey jsonSegmentKind = iota
+	// jsonIndex selects an array element, negative indexes count from the end
+	jsonIndex
+	// jsonWildcard selects every member or element
+	jsonWildcard
+	// jsonDescend selects the value and all of its descendants
+	jsonDescend
+)
+
+type jsonSegment struct {
+	kind  jsonSegmentKind
+	key   string
+	index int
+}
+
+// jsonPath is a parsed JSON Pointer or JSONPath location
+type jsonPath []jsonSegment
+
+// parseJSONPath parses a JSON Pointer like /links/0/href or a JSONPath like $.links[*].href. JSONPath supports
+// member names, quoted names in brackets, indexes, wildcards and recursive descent with ..
+func parseJSONPath(path string) (jsonPath, error) {
+	if path == "" || strings.HasPrefix(path, "/") {
+		return parseJSONPointer(path), nil
+	}
+	if strings.HasPrefix(path, "$") {
+		return parseJSONPathExpression(path)
+	}
+	return nil, fmt.Errorf("json location '%s' must be a JSON Pointer starting with / or a JSONPath starting with $", path)
+}
+
+// ValidateJSONPath returns an error when the JSON Pointer or JSONPath location can not be parsed
+func ValidateJSONPath(path string) error {
+	_, err := parseJSONPath(path)
+	return err
+}
+
+func parseJSONPointer(pointer string) jsonPath {
+	if pointer == "" {
+		return jsonPath{}
+	}
+	path := jsonPath{}
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.Replace(token, "~1", "/", -1)
+		token = strings.Replace(token, "~0", "~", -1)
+		path = append(path, jsonSegment{kind: jsonKey, key: token})
+	}
+	return path
+}
+
+func parseJSONPathExpression(expression string) (jsonPath, error) {
+	path := jsonPath{}
+	rest := expression[1:]
+	invalid := func(reason string) (jsonPath, error) {
+		return nil, fmt.Errorf("invalid JSONPath '%s': %s", expression, reason)
+	}
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			path = append(path, jsonSegment{kind: jsonDescend})
+			rest = rest[2:]
+			if strings.HasPrefix(rest, "[") {
+				continue
+			}
+			segment, remaining, ok := parseJSONPathName(rest)
+			if !ok {
+				return invalid("expected a name after ..")
+			}
+			path, rest = append(path, segment), remaining
+		case strings.HasPrefix(rest, "."):
+			segment, remaining, ok := parseJSONPathName(rest[1:])
+			if !ok {
+				return invalid("expected a name after .")
+			}
+			path, rest = append(path, segment), remaining
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return invalid("missing ]")
+			}
+			selector := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			switch {
+			case selector == "*":
+				path = append(path, jsonSegment{kind: jsonWildcard})
+			case len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0]:
+				path = append(path, jsonSegment{kind: jsonKey, key: selector[1 : len(selector)-1]})
+			default:
+				index, err := strconv.Atoi(selector)
+				if err != nil {
+					return invalid(fmt.Sprintf("unsupported selector [%s]", selector))
+				}
+				path = append(path, jsonSegment{kind: jsonIndex, index: index})
+			}
+		default:
+			return invalid(fmt.Sprintf("unexpected '%s'", rest))
+		}
+	}
+	return path, nil
+}
+
+// parseJSONPathName parses a member name or * up to the next . or [
+func parseJSONPathName(s string) (jsonSegment, string, bool) {
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		end = len(s)
+	}
+	name := s[:end]
+	if name == "" {
+		return jsonSegment{}, s, false
+	}
+	if name == "*" {
+		return jsonSegment{kind: jsonWildcard}, s[end:], true
+	}
+	return jsonSegment{kind: jsonKey, key: name}, s[end:], true
+}
+
+// last returns the final segment of the location and reports false for the document root
+func (path jsonPath) last() (jsonSegment, bool) {
+	if len(path) == 0 {
+		return jsonSegment{}, false
+	}
+	return path[len(path)-1], true
+}
@@ -0,0 +1,420 @@
+package proxies
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// jsonContentTypes are the media types the json rewrites parse
+var jsonContentTypes = contentTypes{"application/json", "application/*+json", "text/json"}
+
+// jsonMember is a member of a json object
+type jsonMember struct {
+	key   string
+	value interface{}
+}
+
+// jsonObject keeps the members of an object in the order of the document
+type jsonObject struct {
+	members []*jsonMember
+}
+
+func (o *jsonObject) find(key string) int {
+	for i, member := range o.members {
+		if member.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (o *jsonObject) remove(key string) {
+	if i := o.find(key); i >= 0 {
+		o.members = append(o.members[:i], o.members[i+1:]...)
+	}
+}
+
+type jsonArray struct {
+	items []interface{}
+}
+
+// jsonDocument is a parsed json document, numbers are kept as json.Number so they are written back the way
+// they were read
+type jsonDocument struct {
+	root interface{}
+}
+
+func parseJSONDocument(data []byte) (*jsonDocument, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	root, err := parseJSONValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the json document")
+	}
+	return &jsonDocument{root: root}, nil
+}
+
+func parseJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		object := &jsonObject{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := parseJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object.members = append(object.members, &jsonMember{key: key.(string), value: value})
+		}
+		_, err = decoder.Token()
+		return object, err
+	case json.Delim('['):
+		array := &jsonArray{items: []interface{}{}}
+		for decoder.More() {
+			value, err := parseJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array.items = append(array.items, value)
+		}
+		_, err = decoder.Token()
+		return array, err
+	}
+	return token, nil
+}
+
+// bytes writes the document in compact form
+func (document *jsonDocument) bytes() []byte {
+	buf := &bytes.Buffer{}
+	writeJSONValue(buf, document.root)
+	return buf.Bytes()
+}
+
+func writeJSONValue(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case *jsonObject:
+		buf.WriteByte('{')
+		for i, member := range v.members {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, member.key)
+			buf.WriteByte(':')
+			writeJSONValue(buf, member.value)
+		}
+		buf.WriteByte('}')
+	case *jsonArray:
+		buf.WriteByte('[')
+		for i, item := range v.items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONValue(buf, item)
+		}
+		buf.WriteByte(']')
+	case string:
+		writeJSONString(buf, v)
+	case json.Number:
+		buf.WriteString(v.String())
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	default:
+		buf.WriteString("null")
+	}
+}
+
+// writeJSONString writes a quoted string without escaping html characters the backend did not escape either
+func writeJSONString(buf *bytes.Buffer, s string) {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	buf.Truncate(buf.Len() - 1)
+}
+
+// jsonSlot is a place in the document a location selects, the member of an object, the element of an array or
+// the root
+type jsonSlot struct {
+	document *jsonDocument
+	object   *jsonObject
+	array    *jsonArray
+	key      string
+	index    int
+}
+
+func (s jsonSlot) get() (interface{}, bool) {
+	switch {
+	case s.object != nil:
+		if i := s.object.find(s.key); i >= 0 {
+			return s.object.members[i].value, true
+		}
+		return nil, false
+	case s.array != nil:
+		if s.index < len(s.array.items) {
+			return s.array.items[s.index], true
+		}
+		return nil, false
+	}
+	return s.document.root, true
+}
+
+// set replaces the value, members that do not exist yet are added and an index just past the end appends
+func (s jsonSlot) set(value interface{}) {
+	switch {
+	case s.object != nil:
+		if i := s.object.find(s.key); i >= 0 {
+			s.object.members[i].value = value
+			return
+		}
+		s.object.members = append(s.object.members, &jsonMember{key: s.key, value: value})
+	case s.array != nil:
+		if s.index < len(s.array.items) {
+			s.array.items[s.index] = value
+			return
+		}
+		s.array.items = append(s.array.items, value)
+	default:
+		s.document.root = value
+	}
+}
+
+// selectSlots returns the slots of the location, with create the final member of a location is selected even
+// when it does not exist yet so it can be set
+func (document *jsonDocument) selectSlots(path jsonPath, create bool) []jsonSlot {
+	slots := []jsonSlot{{document: document}}
+	for i, segment := range path {
+		last := create && i == len(path)-1
+		next := []jsonSlot{}
+		for _, slot := range slots {
+			value, ok := slot.get()
+			if !ok {
+				continue
+			}
+			next = append(next, selectChildren(value, slot, segment, last)...)
+		}
+		slots = next
+	}
+	return slots
+}
+
+func selectChildren(value interface{}, slot jsonSlot, segment jsonSegment, create bool) []jsonSlot {
+	switch segment.kind {
+	case jsonKey:
+		switch v := value.(type) {
+		case *jsonObject:
+			if create || v.find(segment.key) >= 0 {
+				return []jsonSlot{{object: v, key: segment.key}}
+			}
+		case *jsonArray:
+			if segment.key == "-" && create {
+				return []jsonSlot{{array: v, index: len(v.items)}}
+			}
+			index, err := strconv.Atoi(segment.key)
+			if err == nil && index >= 0 && (index < len(v.items) || create && index == len(v.items)) {
+				return []jsonSlot{{array: v, index: index}}
+			}
+		}
+	case jsonIndex:
+		if v, ok := value.(*jsonArray); ok {
+			index := segment.index
+			if index < 0 {
+				index += len(v.items)
+			}
+			if index >= 0 && index < len(v.items) {
+				return []jsonSlot{{array: v, index: index}}
+			}
+		}
+	case jsonWildcard:
+		return children(value)
+	case jsonDescend:
+		slots := []jsonSlot{slot}
+		for _, child := range children(value) {
+			childValue, _ := child.get()
+			slots = append(slots, selectChildren(childValue, child, segment, false)...)
+		}
+		return slots
+	}
+	return nil
+}
+
+func children(value interface{}) []jsonSlot {
+	slots := []jsonSlot{}
+	switch v := value.(type) {
+	case *jsonObject:
+		for _, member := range v.members {
+			slots = append(slots, jsonSlot{object: v, key: member.key})
+		}
+	case *jsonArray:
+		for i := range v.items {
+			slots = append(slots, jsonSlot{array: v, index: i})
+		}
+	}
+	return slots
+}
+
+// jsonEdit changes a parsed json document
+type jsonEdit func(document *jsonDocument)
+
+// setJSON sets the value at the location to the json value, missing members are added. The value must be valid
+func setJSON(path jsonPath, value string) jsonEdit {
+	return func(document *jsonDocument) {
+		for _, slot := range document.selectSlots(path, true) {
+			// every slot gets its own copy so later edits of one do not change the others
+			parsed, _ := parseJSONDocument([]byte(value))
+			slot.set(parsed.root)
+		}
+	}
+}
+
+// deleteJSON removes the members and elements at the location
+func deleteJSON(path jsonPath) jsonEdit {
+	return func(document *jsonDocument) {
+		removed := map[*jsonArray]map[int]bool{}
+		for _, slot := range document.selectSlots(path, false) {
+			switch {
+			case slot.object != nil:
+				slot.object.remove(slot.key)
+			case slot.array != nil:
+				if removed[slot.array] == nil {
+					removed[slot.array] = map[int]bool{}
+				}
+				removed[slot.array][slot.index] = true
+			}
+		}
+		// elements are removed together so the indexes of the other selected elements stay valid
+		for array, indexes := range removed {
+			items := []interface{}{}
+			for i, item := range array.items {
+				if !indexes[i] {
+					items = append(items, item)
+				}
+			}
+			array.items = items
+		}
+	}
+}
+
+// renameJSON renames the members at the location, a member that already has the name is replaced
+func renameJSON(path jsonPath, name string) jsonEdit {
+	return func(document *jsonDocument) {
+		for _, slot := range document.selectSlots(path, false) {
+			if slot.object == nil || slot.key == name {
+				continue
+			}
+			i := slot.object.find(slot.key)
+			if i < 0 {
+				continue
+			}
+			member := slot.object.members[i]
+			slot.object.remove(name)
+			member.key = name
+		}
+	}
+}
+
+// replaceJSON replaces the matches of the regular expression in the string values at the location
+func replaceJSON(path jsonPath, regex *regexp.Regexp, replace string) jsonEdit {
+	return func(document *jsonDocument) {
+		for _, slot := range document.selectSlots(path, false) {
+			if value, ok := slot.get(); ok {
+				if s, ok := value.(string); ok {
+					slot.set(regex.ReplaceAllString(s, replace))
+				}
+			}
+		}
+	}
+}
+
+// mapJSONURLs maps every string value that is an absolute url of the backend pool to the frontend url
+func mapJSONURLs(mapper *urlMapper) jsonEdit {
+	var walk func(slot jsonSlot)
+	walk = func(slot jsonSlot) {
+		value, _ := slot.get()
+		if s, ok := value.(string); ok {
+			lower := strings.ToLower(s)
+			if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(s, "//") {
+				slot.set(mapper.mapURL(s))
+			}
+			return
+		}
+		for _, child := range children(value) {
+			walk(child)
+		}
+	}
+	return func(document *jsonDocument) {
+		walk(jsonSlot{document: document})
+	}
+}
+
+// jsonRewriter reads the whole json document on the first read, edits it and returns the written document.
+// Documents larger than maxBytes are returned unchanged, documents that do not parse are an error when strict
+// is set and returned unchanged otherwise
+type jsonRewriter struct {
+	source   io.ReadCloser
+	maxBytes int64
+	strict   bool
+	edit     jsonEdit
+	output   io.Reader
+}
+
+func (j *jsonRewriter) Read(p []byte) (int, error) {
+	if j.output == nil {
+		if err := j.rewrite(); err != nil {
+			return 0, err
+		}
+	}
+	return j.output.Read(p)
+}
+
+func (j *jsonRewriter) Close() error {
+	return j.source.Close()
+}
+
+func (j *jsonRewriter) rewrite() error {
+	buf, err := ioutil.ReadAll(io.LimitReader(j.source, j.maxBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(buf)) > j.maxBytes {
+		j.output = io.MultiReader(bytes.NewReader(buf), j.source)
+		return nil
+	}
+	j.output = bytes.NewReader(buf)
+	if len(bytes.TrimSpace(buf)) == 0 {
+		return nil
+	}
+	document, err := parseJSONDocument(buf)
+	if err != nil {
+		if j.strict {
+			return fmt.Errorf("invalid json body: %v", err)
+		}
+		return nil
+	}
+	j.edit(document)
+	j.output = bytes.NewReader(document.bytes())
+	return nil
+}
+
+func newJSONRewriter(source io.ReadCloser, maxBytes int64, strict bool, edit jsonEdit) io.ReadCloser {
+	return &jsonRewriter{
+		source:   source,
+		maxBytes: maxBytes,
+		strict:   strict,
+		edit:     edit,
+	}
+}
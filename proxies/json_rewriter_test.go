@@ -0,0 +1,104 @@
+package proxies_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JSONRewriter", func() {
+	backendURL, _ := url.Parse("http://backend:8080/base")
+	document := `{"id": 12345678901234567890, "price": 1.50, "exp": 1e3, "name": "<b>widget</b>", "secret": "x",` +
+		` "_links": {"self": {"href": "http://backend:8080/base/widgets/1"}, "other": {"href": "http://elsewhere/x"}},` +
+		` "items": [{"sku": "a-1", "tags": ["old"]}, {"sku": "b-2", "tags": []}]}`
+	respond := func(builder proxies.ReverseProxyBuilder, contentType string, body string) string {
+		response := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{contentType}},
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Request:    httptest.NewRequest("GET", "http://frontend/app/widgets/1", nil),
+		}
+		Expect(builder.ToReverseProxy(nil).ModifyResponse(response)).To(Succeed())
+		return readAll(response)
+	}
+	Context("response", func() {
+		It("sets, deletes, renames and replaces while keeping numbers and member order", func() {
+			builder := proxies.NewReverseProxyBuilder().
+				SetResponseJSON("/items/0/tags/-", `"new"`).
+				SetResponseJSON("$.version", `{"major": 2}`).
+				DeleteResponseJSON("/secret").
+				RenameResponseJSON("$.items[*].sku", "code").
+				ReplaceResponseJSON("$..code", "-", "_")
+			Expect(respond(builder, "application/json", document)).To(Equal(
+				`{"id":12345678901234567890,"price":1.50,"exp":1e3,"name":"<b>widget</b>",` +
+					`"_links":{"self":{"href":"http://backend:8080/base/widgets/1"},"other":{"href":"http://elsewhere/x"}},` +
+					`"items":[{"code":"a_1","tags":["old","new"]},{"code":"b_2","tags":[]}],"version":{"major":2}}`))
+		})
+		It("deletes array elements", func() {
+			builder := proxies.NewReverseProxyBuilder().DeleteResponseJSON("$.items[-1]")
+			Expect(respond(builder, "application/json", `{"items":[1,2,3]}`)).To(Equal(`{"items":[1,2]}`))
+		})
+		It("maps urls of the backend", func() {
+			builder := proxies.NewReverseProxyBuilder().RewriteJSONURLs(backendURL, "/app")
+			Expect(respond(builder, "application/hal+json", document)).To(ContainSubstring(
+				`"_links":{"self":{"href":"http://frontend/app/widgets/1"},"other":{"href":"http://elsewhere/x"}}`))
+		})
+		It("leaves invalid json and other content types alone", func() {
+			builder := proxies.NewReverseProxyBuilder().DeleteResponseJSON("/secret")
+			Expect(respond(builder, "application/json", `{"secret": `)).To(Equal(`{"secret": `))
+			Expect(respond(builder, "text/plain", `{"secret": 1}`)).To(Equal(`{"secret": 1}`))
+		})
+		It("rejects invalid locations", func() {
+			_, err := proxies.NewReverseProxyBuilder().
+				DeleteResponseJSON("secret").
+				DeleteResponseJSON("$.items[x]").
+				SetResponseJSON("/a", "{").
+				Build()
+			Expect(err).To(HaveLen(3))
+		})
+	})
+	Context("request", func() {
+		var (
+			received string
+			backend  *httptest.Server
+		)
+		BeforeEach(func() {
+			backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				received = string(body)
+			}))
+		})
+		AfterEach(func() {
+			backend.Close()
+		})
+		send := func(body string) int {
+			target, _ := url.Parse(backend.URL)
+			proxy := proxies.NewReverseProxyBuilder().
+				RewriteHost(target, "").
+				RenameRequestJSON("/user/name", "login").
+				DeleteRequestJSON("/user/admin").
+				ToReverseProxy(nil)
+			request := httptest.NewRequest("POST", "http://frontend/users", strings.NewReader(body))
+			request.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			proxy.ServeHTTP(recorder, request)
+			return recorder.Code
+		}
+		It("edits the body", func() {
+			Expect(send(`{"user": {"name": "bob", "admin": true, "age": 30}}`)).To(Equal(http.StatusOK))
+			Expect(received).To(Equal(`{"user":{"login":"bob","age":30}}`))
+		})
+		It("rejects invalid json", func() {
+			received = ""
+			Expect(send(`{"user": `)).To(Equal(http.StatusBadRequest))
+			Expect(received).To(BeEmpty())
+		})
+	})
+})
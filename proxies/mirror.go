@@ -0,0 +1,212 @@
+package proxies
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMirrorMaxBodyBytes is the largest request body copied to a shadow backend when no cap is configured
+	DefaultMirrorMaxBodyBytes = 1 << 20
+	// DefaultMirrorTimeout limits how long a shadow request may take
+	DefaultMirrorTimeout = 30 * time.Second
+	// maxMirrorsInFlight bounds the shadow requests of one mirror, requests are not mirrored while it is reached
+	maxMirrorsInFlight = 100
+)
+
+// mirror copies requests to a shadow backend and discards the responses
+type mirror struct {
+	shadowURL    *url.URL
+	percentage   float64
+	maxBodyBytes int64
+	inFlight     chan struct{}
+}
+
+// send copies the rewritten request to the shadow backend in the background. Bodies the request can not get
+// again are copied up to the cap while the primary request sends them, the copy is sent once the primary
+// request has sent the whole body
+func (m *mirror) send(request *http.Request, transport http.RoundTripper) {
+	transport = baseTransport(transport)
+	if m.percentage < 100 && rand.Float64()*100 >= m.percentage {
+		return
+	}
+	// upgraded connections can not be copied
+	if request.Header.Get("Upgrade") != "" {
+		return
+	}
+	if state := requestStateOf(request); state != nil && state.failed() != nil {
+		return
+	}
+	if request.ContentLength > m.maxBodyBytes {
+		return
+	}
+
+	shadow := m.shadowRequest(request)
+	switch {
+	case request.Body == nil || request.Body == http.NoBody:
+		m.dispatch(shadow, nil, transport)
+	case request.GetBody != nil:
+		body, err := request.GetBody()
+		if err != nil {
+			return
+		}
+		defer body.Close()
+		content, err := ioutil.ReadAll(io.LimitReader(body, m.maxBodyBytes+1))
+		if err != nil || int64(len(content)) > m.maxBodyBytes {
+			return
+		}
+		m.dispatch(shadow, content, transport)
+	default:
+		request.Body = &mirrorBody{
+			ReadCloser: request.Body,
+			maxBytes:   m.maxBodyBytes,
+			read: func(content []byte) {
+				m.dispatch(shadow, content, transport)
+			},
+		}
+	}
+}
+
+// dispatch sends the shadow request with the body in the background unless too many are in flight
+func (m *mirror) dispatch(shadow *http.Request, body []byte, transport http.RoundTripper) {
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		return
+	}
+
+	if body == nil {
+		shadow.ContentLength = 0
+		shadow.Body = nil
+	} else {
+		shadow.ContentLength = int64(len(body))
+		shadow.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	go func() {
+		defer func() { <-m.inFlight }()
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultMirrorTimeout)
+		defer cancel()
+
+		response, err := transport.RoundTrip(shadow.WithContext(ctx))
+		if err != nil {
+			log.Printf("unable to mirror %s %s to %s: %v", shadow.Method, shadow.URL.Path, m.shadowURL.Host, err)
+			return
+		}
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+	}()
+}
+
+// mirrorBody copies the body up to maxBytes while the primary request reads it and hands the copy to read
+// once the whole body was read. Bodies larger than maxBytes or closed before the end are not handed over
+type mirrorBody struct {
+	io.ReadCloser
+	maxBytes int64
+	read     func(content []byte)
+	content  bytes.Buffer
+	finished bool
+}
+
+func (b *mirrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.finished {
+		return n, err
+	}
+	if room := b.maxBytes + 1 - int64(b.content.Len()); room > 0 {
+		if int64(n) < room {
+			room = int64(n)
+		}
+		b.content.Write(p[:room])
+	}
+	if err == io.EOF {
+		b.finished = true
+		if int64(b.content.Len()) <= b.maxBytes {
+			b.read(b.content.Bytes())
+		}
+	} else if err != nil {
+		b.finished = true
+	}
+	return n, err
+}
+
+// shadowRequest creates the request for the shadow backend without a body, the path of the selected backend
+// is replaced with the path of the shadow url
+func (m *mirror) shadowRequest(request *http.Request) *http.Request {
+	shadow := request.Clone(context.Background())
+	shadowURL := *request.URL
+	path := shadowURL.Path
+	if state := requestStateOf(request); state != nil {
+		if b := state.selectedBackend(); b != nil {
+			path = strings.TrimPrefix(path, b.URL().Path)
+		}
+	}
+	if m.shadowURL.Path != "" {
+		path = SingleJoiningSlash(m.shadowURL.Path, path)
+	} else if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	shadowURL.Scheme = m.shadowURL.Scheme
+	shadowURL.Host = m.shadowURL.Host
+	shadowURL.Path = path
+	shadowURL.RawPath = ""
+	shadow.URL = &shadowURL
+	shadow.Host = m.shadowURL.Host
+	shadow.RequestURI = ""
+	for _, name := range hopHeaders {
+		shadow.Header.Del(name)
+	}
+
+	shadow.Body = nil
+	shadow.GetBody = nil
+	return shadow
+}
+
+// wrappingTransport is a transport that adds behaviour like retries to the transport it wraps
+type wrappingTransport interface {
+	unwrap() http.RoundTripper
+}
+
+// baseTransport returns the transport below the retry and circuit breaker transports so shadow requests are not
+// retried and do not count against the circuit of the primary backend
+func baseTransport(transport http.RoundTripper) http.RoundTripper {
+	for {
+		wrapping, ok := transport.(wrappingTransport)
+		if !ok {
+			return transport
+		}
+		transport = wrapping.unwrap()
+	}
+}
+
+// hopHeaders apply to a single connection and are not copied to the shadow request
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func newMirror(shadowURL *url.URL, percentage float64, maxBodyBytes int64) *mirror {
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = DefaultMirrorMaxBodyBytes
+	}
+	return &mirror{
+		shadowURL:    shadowURL,
+		percentage:   percentage,
+		maxBodyBytes: maxBodyBytes,
+		inFlight:     make(chan struct{}, maxMirrorsInFlight),
+	}
+}
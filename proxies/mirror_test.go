@@ -0,0 +1,158 @@
+package proxies_test
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Mirror", func() {
+	type shadowed struct {
+		path   string
+		header string
+		body   string
+	}
+	var (
+		primary   *httptest.Server
+		shadow    *httptest.Server
+		frontend  *httptest.Server
+		received  chan shadowed
+		release   chan struct{}
+		arrived   chan struct{}
+		transport http.RoundTripper
+	)
+	BeforeEach(func() {
+		received = make(chan shadowed, 10)
+		release = make(chan struct{})
+		arrived = make(chan struct{}, 10)
+		transport = nil
+		primary = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			arrived <- struct{}{}
+			body, _ := ioutil.ReadAll(r.Body)
+			fmt.Fprintf(w, "primary %s %s", r.URL.Path, body)
+		}))
+		shadow = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			received <- shadowed{path: r.URL.Path, header: r.Header.Get("X-Rewritten"), body: string(body)}
+			<-release
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+	})
+	AfterEach(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+		frontend.Close()
+		shadow.Close()
+		primary.Close()
+	})
+	start := func(percentage float64, maxBodyBytes int64) {
+		primaryURL, err := url.Parse(primary.URL + "/primary")
+		Expect(err).To(BeNil())
+		shadowURL, err := url.Parse(shadow.URL + "/shadow")
+		Expect(err).To(BeNil())
+		builder := proxies.NewReverseProxyBuilder().
+			RewriteHost(primaryURL, "/app").
+			Mirror(shadowURL, percentage, maxBodyBytes).
+			SetRequestHeader("X-Rewritten", "true")
+		frontend = httptest.NewServer(builder.ToReverseProxy(transport))
+	}
+	post := func(body string) string {
+		res, err := http.Post(frontend.URL+"/app/ok", "text/plain", strings.NewReader(body))
+		Expect(err).To(BeNil())
+		defer res.Body.Close()
+		content, err := ioutil.ReadAll(res.Body)
+		Expect(err).To(BeNil())
+		return string(content)
+	}
+	It("copies the rewritten request without waiting for the shadow", func() {
+		start(100, 0)
+		done := make(chan string)
+		go func() {
+			defer GinkgoRecover()
+			done <- post("data")
+		}()
+		Eventually(done, time.Second).Should(Receive(Equal("primary /primary/ok data")))
+
+		var copy shadowed
+		Eventually(received).Should(Receive(&copy))
+		Expect(copy).To(Equal(shadowed{path: "/shadow/ok", header: "true", body: "data"}))
+	})
+	It("sends the primary request while the body is still being uploaded", func() {
+		start(100, 0)
+		reader, writer := io.Pipe()
+		done := make(chan string)
+		go func() {
+			defer GinkgoRecover()
+			res, err := http.Post(frontend.URL+"/app/ok", "text/plain", reader)
+			Expect(err).To(BeNil())
+			defer res.Body.Close()
+			content, err := ioutil.ReadAll(res.Body)
+			Expect(err).To(BeNil())
+			done <- string(content)
+		}()
+		writer.Write([]byte("da"))
+		Eventually(arrived, time.Second).Should(Receive())
+		Consistently(received, 100*time.Millisecond).ShouldNot(Receive())
+
+		writer.Write([]byte("ta"))
+		writer.Close()
+		Eventually(done, time.Second).Should(Receive(Equal("primary /primary/ok data")))
+		var copy shadowed
+		Eventually(received).Should(Receive(&copy))
+		Expect(copy.body).To(Equal("data"))
+	})
+	It("does not mirror requests outside the sample", func() {
+		start(0, 0)
+		Expect(post("data")).To(Equal("primary /primary/ok data"))
+		Consistently(received, 100*time.Millisecond).ShouldNot(Receive())
+	})
+	It("does not mirror bodies larger than the cap", func() {
+		start(100, 4)
+		Expect(post("larger")).To(Equal("primary /primary/ok larger"))
+		Consistently(received, 100*time.Millisecond).ShouldNot(Receive())
+
+		Expect(post("tiny")).To(Equal("primary /primary/ok tiny"))
+		Eventually(received).Should(Receive())
+	})
+	It("sends shadow requests without retries and without counting them in the circuit breaker", func() {
+		close(release)
+		breaker := proxies.NewCircuitBreaker(nil, proxies.CircuitBreakerSettings{ConsecutiveFailures: 1})
+		transport = proxies.NewRetryTransport(breaker, proxies.RetryPolicy{MaxAttempts: 3, RetryOn: []int{http.StatusInternalServerError}})
+		start(100, 0)
+		for i := 0; i < 2; i++ {
+			res, err := http.Get(frontend.URL + "/app/ok")
+			Expect(err).To(BeNil())
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Eventually(received).Should(Receive())
+		}
+		Consistently(received, 100*time.Millisecond).ShouldNot(Receive())
+		statuses := breaker.Status()
+		Expect(statuses).To(HaveLen(1))
+		Expect(statuses[0].Host).To(Equal(strings.TrimPrefix(primary.URL, "http://")))
+	})
+	It("reports a missing shadow url", func() {
+		_, err := proxies.NewReverseProxyBuilder().Mirror(nil, 100, 0).Build()
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("Mirror: the shadow url must be an absolute url"))
+	})
+	It("does not fail the primary request when the shadow is down", func() {
+		start(100, 0)
+		shadow.CloseClientConnections()
+		shadow.Listener.Close()
+		Expect(post("data")).To(Equal("primary /primary/ok data"))
+	})
+})
@@ -0,0 +1,241 @@
+package proxies
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+)
+
+// pathRewrite maps the path below the route path prefix to a backend path and query. When the pattern is anchored
+// at both ends and made of literal text and capture groups that the template uses once each, the backend path is
+// mapped back with the reverse pattern
+type pathRewrite struct {
+	regex    *regexp.Regexp
+	template string
+	// reverse matches the backend path, and the query when the template has one, it is nil when the rewrite can not
+	// be mapped back
+	reverse         *regexp.Regexp
+	reverseTemplate string
+	// query is set when the template adds a query, the rest of the backend query follows in the last group
+	query bool
+}
+
+func newPathRewrite(regex *regexp.Regexp, template string) *pathRewrite {
+	rewrite := &pathRewrite{
+		regex:    regex,
+		template: template,
+		query:    strings.Contains(template, "?"),
+	}
+	rewrite.reverse, rewrite.reverseTemplate = reversePathRewrite(regex, template, rewrite.query)
+	return rewrite
+}
+
+// forward returns the backend path and the query the template adds, ok is false when the path does not match
+func (rewrite *pathRewrite) forward(path string) (string, string, bool) {
+	if !rewrite.regex.MatchString(path) {
+		return path, "", false
+	}
+	mapped := rewrite.regex.ReplaceAllString(path, rewrite.template)
+	if i := strings.Index(mapped, "?"); i >= 0 {
+		return mapped[:i], mapped[i+1:], true
+	}
+	return mapped, "", true
+}
+
+// backward returns the frontend path and query of the backend path and query, ok is false when the rewrite can not
+// be mapped back or the backend path does not match
+func (rewrite *pathRewrite) backward(path string, query string) (string, string, bool) {
+	if rewrite.reverse == nil {
+		return path, query, false
+	}
+	subject := path
+	if rewrite.query {
+		subject = path + "?" + query
+	}
+	match := rewrite.reverse.FindStringSubmatchIndex(subject)
+	if match == nil {
+		return path, query, false
+	}
+	mapped := string(rewrite.reverse.ExpandString(nil, rewrite.reverseTemplate, subject, match))
+	if rewrite.query {
+		query = ""
+		if rest := match[len(match)-2]; rest >= 0 {
+			query = subject[rest:match[len(match)-1]]
+		}
+	}
+	return mapped, query, true
+}
+
+// pathRewrites are tried in order, the first that matches maps the path
+type pathRewrites []*pathRewrite
+
+func (rewrites pathRewrites) forward(path string) (string, string) {
+	for _, rewrite := range rewrites {
+		if mapped, query, ok := rewrite.forward(path); ok {
+			return mapped, query
+		}
+	}
+	return path, ""
+}
+
+func (rewrites pathRewrites) backward(path string, query string) (string, string) {
+	for _, rewrite := range rewrites {
+		if mapped, mappedQuery, ok := rewrite.backward(path, query); ok {
+			return mapped, mappedQuery
+		}
+	}
+	return path, query
+}
+
+// templatePart is literal text or the capture group of a replacement template
+type templatePart struct {
+	literal string
+	group   int
+}
+
+// reversePathRewrite builds the pattern that matches the output of the template and the template that rebuilds the
+// input of the pattern, it returns nil when the rewrite is not reversible
+func reversePathRewrite(regex *regexp.Regexp, template string, query bool) (*regexp.Regexp, string) {
+	parsed, err := syntax.Parse(regex.String(), syntax.Perl)
+	if err != nil {
+		return nil, ""
+	}
+	items := []*syntax.Regexp{parsed}
+	if parsed.Op == syntax.OpConcat {
+		items = parsed.Sub
+	}
+	if len(items) < 2 || items[0].Op != syntax.OpBeginText || items[len(items)-1].Op != syntax.OpEndText {
+		return nil, ""
+	}
+	items = items[1 : len(items)-1]
+
+	groups := map[int]string{}
+	for _, item := range items {
+		switch item.Op {
+		case syntax.OpLiteral:
+			if item.Flags&syntax.FoldCase != 0 {
+				return nil, ""
+			}
+		case syntax.OpCapture:
+			groups[item.Cap] = item.Sub[0].String()
+		default:
+			return nil, ""
+		}
+	}
+
+	parts, ok := parseTemplate(template, regex)
+	if !ok {
+		return nil, ""
+	}
+
+	// every group of the pattern must be used once so the input can be rebuilt from the output
+	pattern := &strings.Builder{}
+	pattern.WriteString("^")
+	reverseGroups := map[int]int{}
+	next := 1
+	for _, part := range parts {
+		if part.group == 0 {
+			pattern.WriteString(regexp.QuoteMeta(part.literal))
+			continue
+		}
+		inner, ok := groups[part.group]
+		if _, used := reverseGroups[part.group]; !ok || used {
+			return nil, ""
+		}
+		reverseGroups[part.group] = next
+		pattern.WriteString("(" + inner + ")")
+		compiled, err := regexp.Compile(inner)
+		if err != nil {
+			return nil, ""
+		}
+		next += 1 + compiled.NumSubexp()
+	}
+	if len(reverseGroups) != len(groups) {
+		return nil, ""
+	}
+	if query {
+		pattern.WriteString("(?:&(.*))?")
+	}
+	pattern.WriteString("$")
+	reverse, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, ""
+	}
+
+	reverseTemplate := &strings.Builder{}
+	for _, item := range items {
+		if item.Op == syntax.OpLiteral {
+			reverseTemplate.WriteString(strings.Replace(string(item.Rune), "$", "$$", -1))
+			continue
+		}
+		reverseTemplate.WriteString("${" + strconv.Itoa(reverseGroups[item.Cap]) + "}")
+	}
+	return reverse, reverseTemplate.String()
+}
+
+// parseTemplate splits the template the way regexp.Expand reads it, ok is false when it refers to a group the
+// pattern does not have
+func parseTemplate(template string, regex *regexp.Regexp) ([]templatePart, bool) {
+	parts := []templatePart{}
+	literal := &strings.Builder{}
+	for len(template) > 0 {
+		i := strings.Index(template, "$")
+		if i < 0 {
+			literal.WriteString(template)
+			break
+		}
+		literal.WriteString(template[:i])
+		template = template[i+1:]
+		if strings.HasPrefix(template, "$") {
+			literal.WriteString("$")
+			template = template[1:]
+			continue
+		}
+		name, rest, ok := templateName(template)
+		if !ok {
+			literal.WriteString("$")
+			continue
+		}
+		template = rest
+		group := regex.SubexpIndex(name)
+		if number, err := strconv.Atoi(name); err == nil {
+			group = number
+		}
+		if group <= 0 || group > regex.NumSubexp() {
+			return nil, false
+		}
+		if literal.Len() > 0 {
+			parts = append(parts, templatePart{literal: literal.String()})
+			literal.Reset()
+		}
+		parts = append(parts, templatePart{group: group})
+	}
+	if literal.Len() > 0 {
+		parts = append(parts, templatePart{literal: literal.String()})
+	}
+	return parts, true
+}
+
+// templateName reads the name or number that follows a $ in a template, like 1, name or {1}
+func templateName(template string) (string, string, bool) {
+	if strings.HasPrefix(template, "{") {
+		end := strings.Index(template, "}")
+		if end < 2 {
+			return "", template, false
+		}
+		return template[1:end], template[end+1:], true
+	}
+	end := 0
+	for end < len(template) && isTemplateNameByte(template[end]) {
+		end++
+	}
+	if end == 0 {
+		return "", template, false
+	}
+	return template[:end], template[end:], true
+}
+
+func isTemplateNameByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
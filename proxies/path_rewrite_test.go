@@ -0,0 +1,89 @@
+package proxies_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PathRewrite", func() {
+	var (
+		backend  *httptest.Server
+		frontend *httptest.Server
+		received string
+	)
+	BeforeEach(func() {
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.URL.RequestURI()
+			switch r.URL.Path {
+			case "/base/redirect":
+				w.Header().Set("Location", "http://"+r.Host+"/base/api/v2/profile?id=7&tab=a")
+				w.WriteHeader(http.StatusFound)
+			case "/base/storage/docs/a.txt":
+				http.SetCookie(w, &http.Cookie{Name: "folder", Value: "docs", Path: "/base/storage/docs"})
+			case "/base/links":
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"profile": "http://%s/base/api/v2/profile?id=3#top", "other": "http://%s/base/old/x"}`, r.Host, r.Host)
+			}
+		}))
+		backendURL, _ := url.Parse(backend.URL + "/base")
+		frontend = httptest.NewServer(proxies.NewReverseProxyBuilder().
+			RewriteHost(backendURL, "/app").
+			RewritePath(`^/users/(\d+)/profile$`, "/api/v2/profile?id=$1").
+			RewritePath(`^/files/(?P<rest>.*)$`, "/storage/${rest}").
+			RewritePath(`^/old/.*$`, "/new").
+			RewriteRedirect(backendURL, "/app").
+			RewriteResponseCookies(backendURL, "/app").
+			RewriteJSONURLs(backendURL, "/app").
+			ToReverseProxy(nil))
+	})
+	AfterEach(func() {
+		backend.Close()
+		frontend.Close()
+	})
+	get := func(path string) (*http.Response, string) {
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		res, err := client.Get(frontend.URL + path)
+		Expect(err).To(BeNil())
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).To(BeNil())
+		return res, string(body)
+	}
+	It("maps paths with groups and templates", func() {
+		get("/app/users/42/profile?x=1")
+		Expect(received).To(Equal("/base/api/v2/profile?id=42&x=1"))
+
+		get("/app/old/anything")
+		Expect(received).To(Equal("/base/new"))
+
+		get("/app/other")
+		Expect(received).To(Equal("/base/other"))
+	})
+	It("maps redirects back", func() {
+		res, _ := get("/app/redirect")
+		Expect(res.Header.Get("Location")).To(Equal(frontend.URL + "/app/users/7/profile?tab=a"))
+	})
+	It("maps cookie paths back", func() {
+		res, _ := get("/app/files/docs/a.txt")
+		Expect(received).To(Equal("/base/storage/docs/a.txt"))
+		Expect(res.Cookies()).To(HaveLen(1))
+		Expect(res.Cookies()[0].Path).To(Equal("/app/files/docs"))
+	})
+	It("maps urls in bodies back and leaves rewrites that can not be reversed alone", func() {
+		_, body := get("/app/links")
+		Expect(body).To(Equal(fmt.Sprintf(`{"profile":"%s/app/users/3/profile#top","other":"%s/app/old/x"}`, frontend.URL, frontend.URL)))
+	})
+	It("reports invalid patterns", func() {
+		_, err := proxies.NewReverseProxyBuilder().RewritePath("(", "/").Build()
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("RewritePath: invalid regular expression '('"))
+	})
+})
@@ -0,0 +1,143 @@
+package proxies
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// DefaultMaxRequestBodyBytes is the largest request body the body rewrites buffer when no limit is configured
+const DefaultMaxRequestBodyBytes = 10 << 20
+
+var (
+	// ErrRequestBodyTooLarge is returned when a request body that has to be rewritten is larger than the limit
+	ErrRequestBodyTooLarge = errors.New("request body too large")
+	// ErrMalformedRequestBody is returned when the request body can not be read
+	ErrMalformedRequestBody = errors.New("malformed request body")
+)
+
+// requestBodyLimit decides how much of a request body the body rewrites buffer and what happens to larger
+// bodies
+type requestBodyLimit struct {
+	maxBytes    int64
+	passthrough bool
+}
+
+// rewriteRequestBody streams the request body through the rewrite into a buffer of at most the limit so the
+// rewritten body has a length and can be replayed. Bodies larger than the limit are rejected, or sent on
+// unchanged in passthrough mode. Only bodies with a content type in the allow list are rewritten, in their
+// own charset, and compressed bodies are decompressed first
+func rewriteRequestBody(request *http.Request, rewrite func(io.ReadCloser) io.ReadCloser, options bodyOptions) {
+	limit, types := options.limit, options.types
+	if request.Body == nil || request.Body == http.NoBody {
+		return
+	}
+	state := requestStateOf(request)
+	if state != nil && (state.failed() != nil || state.bodyPassedThrough()) {
+		return
+	}
+	coding, ok := lookupContentCoding(request.Header)
+	if !ok {
+		return
+	}
+	contentType := request.Header.Get("Content-Type")
+	if contentType != "" {
+		if _, ok := types.textEncoding(contentType); !ok {
+			return
+		}
+	}
+	if request.ContentLength > limit.maxBytes {
+		limit.exceeded(request, state, nil)
+		return
+	}
+
+	original := request.Body
+	raw, err := ioutil.ReadAll(io.LimitReader(original, limit.maxBytes+1))
+	if err != nil {
+		original.Close()
+		fail(request, state, fmt.Errorf("%w: %v", ErrMalformedRequestBody, err))
+		return
+	}
+	if int64(len(raw)) > limit.maxBytes {
+		limit.exceeded(request, state, raw)
+		return
+	}
+	original.Close()
+
+	content := raw
+	if coding != nil {
+		decoded, exceeded, err := coding.decodeBytes(raw, limit.maxBytes)
+		if err != nil {
+			fail(request, state, fmt.Errorf("%w: unable to decode %s body: %v", ErrMalformedRequestBody, coding.name, err))
+			return
+		}
+		if exceeded {
+			request.Body = ioutil.NopCloser(bytes.NewReader(raw))
+			limit.exceeded(request, state, nil)
+			return
+		}
+		content = decoded
+	}
+
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+	e, ok := types.textEncoding(contentType)
+	if !ok {
+		setRequestBody(request, raw)
+		return
+	}
+
+	source := io.Reader(bytes.NewReader(content))
+	if e != nil {
+		source = transform.NewReader(source, e.NewDecoder())
+	}
+	output := io.Reader(rewrite(ioutil.NopCloser(source)))
+	if e != nil {
+		output = transform.NewReader(output, encoding.ReplaceUnsupported(e.NewEncoder()))
+	}
+	rewritten := bytes.NewBuffer(make([]byte, 0, len(raw)))
+	if _, err := rewritten.ReadFrom(output); err != nil {
+		fail(request, state, fmt.Errorf("%w: %v", ErrMalformedRequestBody, err))
+		return
+	}
+	if coding == nil {
+		setRequestBody(request, rewritten.Bytes())
+	} else if options.encoding == DecodeBody {
+		request.Header.Del("Content-Encoding")
+		setRequestBody(request, rewritten.Bytes())
+	} else {
+		setRequestBody(request, coding.encodeBytes(rewritten.Bytes()))
+	}
+}
+
+// exceeded rejects the request or, in passthrough mode, restores the part of the body that was already read
+// so the backend receives the original upload
+func (limit requestBodyLimit) exceeded(request *http.Request, state *requestState, read []byte) {
+	if !limit.passthrough {
+		fail(request, state, fmt.Errorf("%w: limit is %d bytes", ErrRequestBodyTooLarge, limit.maxBytes))
+		return
+	}
+	if state != nil {
+		state.passBodyThrough()
+	}
+	if read != nil {
+		request.Body = readCloser{io.MultiReader(bytes.NewReader(read), request.Body), request.Body}
+	}
+}
+
+// fail records the error so the request is answered without being sent to the backend
+func fail(request *http.Request, state *requestState, err error) {
+	if state == nil {
+		log.Printf("unable to rewrite request body of %s %s: %v", request.Method, request.URL.Path, err)
+		return
+	}
+	state.fail(err)
+}
@@ -0,0 +1,104 @@
+package proxies_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing/iotest"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RequestBody", func() {
+	var (
+		backend  *httptest.Server
+		frontend *httptest.Server
+		requests int32
+	)
+	BeforeEach(func() {
+		atomic.StoreInt32(&requests, 0)
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			body, _ := ioutil.ReadAll(r.Body)
+			fmt.Fprintf(w, "%d %s", r.ContentLength, body)
+		}))
+	})
+	AfterEach(func() {
+		if frontend != nil {
+			frontend.Close()
+		}
+		backend.Close()
+	})
+	start := func(maxBytes int64, passthrough bool) proxies.ReverseProxyBuilder {
+		backendURL, err := url.Parse(backend.URL)
+		Expect(err).To(BeNil())
+		builder := proxies.NewReverseProxyBuilder().
+			RewriteHost(backendURL, "/").
+			ReplaceRequestBody("frontend", "backend").
+			RequestBodyLimit(maxBytes, passthrough)
+		frontend = httptest.NewServer(builder.ToReverseProxy(nil))
+		return builder
+	}
+	post := func(body *strings.Reader, chunked bool) (int, string) {
+		req, err := http.NewRequest("POST", frontend.URL+"/upload", body)
+		Expect(err).To(BeNil())
+		if chunked {
+			req.Body = ioutil.NopCloser(iotest.OneByteReader(body))
+			req.ContentLength = -1
+		}
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).To(BeNil())
+		defer res.Body.Close()
+		content, err := ioutil.ReadAll(res.Body)
+		Expect(err).To(BeNil())
+		return res.StatusCode, string(content)
+	}
+	It("rewrites chunked uploads and sends them with a length", func() {
+		start(64, false)
+		status, body := post(strings.NewReader("to frontend and frontend"), true)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("22 to backend and backend"))
+	})
+	It("rejects bodies larger than the limit", func() {
+		start(4, false)
+		status, _ := post(strings.NewReader("frontend"), false)
+		Expect(status).To(Equal(http.StatusRequestEntityTooLarge))
+
+		status, _ = post(strings.NewReader("frontend"), true)
+		Expect(status).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(0)))
+	})
+	It("sends larger bodies unchanged in passthrough mode", func() {
+		start(4, true)
+		status, body := post(strings.NewReader("frontend"), false)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("8 frontend"))
+
+		status, body = post(strings.NewReader("to frontend"), true)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("-1 to frontend"))
+	})
+	It("rejects bodies that can not be read", func() {
+		builder := start(64, false)
+		req := httptest.NewRequest("POST", "/upload", errorReader{})
+		recorder := httptest.NewRecorder()
+		builder.ToReverseProxy(nil).ServeHTTP(recorder, req)
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(0)))
+	})
+})
+
+// errorReader fails like an upload that was cut off
+type errorReader struct{}
+
+func (errorReader) Read(p []byte) (int, error) {
+	return 0, errors.New("unexpected EOF")
+}
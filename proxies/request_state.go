@@ -0,0 +1,194 @@
+package proxies
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+type requestStateKey struct{}
+
+// requestState tracks what the rewrite chain decided for a single proxied request
+type requestState struct {
+	lock    sync.Mutex
+	pool    BackendPool
+	backend Backend
+	once    *sync.Once
+	issued  map[string]bool
+	err     error
+	// passthrough is set once the request body is sent on without being rewritten
+	passthrough bool
+}
+
+// withRequestState attaches a new request state to the outgoing request
+func withRequestState(request *http.Request) *requestState {
+	state := &requestState{}
+	ctx := context.WithValue(request.Context(), requestStateKey{}, state)
+	*request = *request.WithContext(ctx)
+	return state
+}
+
+// requestStateOf returns the state attached to the request or nil if the request is not proxied
+func requestStateOf(request *http.Request) *requestState {
+	if request == nil {
+		return nil
+	}
+	state, _ := request.Context().Value(requestStateKey{}).(*requestState)
+	return state
+}
+
+// selectBackend records the pool member the request is sent to and counts it as outstanding
+func (state *requestState) selectBackend(pool BackendPool, b Backend) {
+	state.releaseBackend()
+
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	b.acquire()
+	state.pool = pool
+	state.backend = b
+	state.once = &sync.Once{}
+}
+
+// releaseBackend stops counting the request as outstanding against the selected backend
+func (state *requestState) releaseBackend() {
+	state.lock.Lock()
+	b, once := state.backend, state.once
+	state.lock.Unlock()
+	if b == nil {
+		return
+	}
+	once.Do(b.release)
+}
+
+func (state *requestState) selectedBackend() Backend {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.backend
+}
+
+func (state *requestState) selectedPool() BackendPool {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.pool
+}
+
+// issueCookie records a cookie the proxy added to the response so cookie rewrites leave it alone
+func (state *requestState) issueCookie(name string) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.issued == nil {
+		state.issued = map[string]bool{}
+	}
+	state.issued[name] = true
+}
+
+func (state *requestState) issuedCookie(name string) bool {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.issued[name]
+}
+
+// fail records an error that stops the request from being sent to the backend
+func (state *requestState) fail(err error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.err == nil {
+		state.err = err
+	}
+}
+
+func (state *requestState) failed() error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.err
+}
+
+func (state *requestState) passBodyThrough() {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.passthrough = true
+}
+
+func (state *requestState) bodyPassedThrough() bool {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.passthrough
+}
+
+// selectedURL returns the url of the backend selected from the pool for the request, falling back to the
+// first member of the pool when the request was not sent to one of its members
+func selectedURL(pool BackendPool, request *http.Request) *url.URL {
+	if state := requestStateOf(request); state != nil {
+		if b := state.selectedBackend(); b != nil && pool.Find(b.URL().Host) == b {
+			return b.URL()
+		}
+	}
+	backends := pool.Backends()
+	if len(backends) == 0 {
+		return nil
+	}
+	return backends[0].URL()
+}
+
+// backendTransport releases the selected backend once the backend response is complete and issues the
+// affinity cookie of the pool it was selected from, requests that failed while they were rewritten are not sent
+type backendTransport struct {
+	transport http.RoundTripper
+}
+
+func (t *backendTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	state := requestStateOf(request)
+	if state != nil {
+		if err := state.failed(); err != nil {
+			state.releaseBackend()
+			return nil, err
+		}
+	}
+	response, err := t.transport.RoundTrip(request)
+	if state == nil {
+		return response, err
+	}
+	if err != nil {
+		state.releaseBackend()
+		return response, err
+	}
+	if pool, ok := state.selectedPool().(*affinityPool); ok {
+		pool.issue(request, response, state.selectedBackend())
+	}
+	response.Body = releaseOnClose(response.Body, state.releaseBackend)
+	return response, nil
+}
+
+type releaseReadCloser struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseReadCloser) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
+
+// releaseReadWriteCloser keeps the body writable so protocol upgrades still work
+type releaseReadWriteCloser struct {
+	io.ReadWriteCloser
+	release func()
+}
+
+func (r *releaseReadWriteCloser) Close() error {
+	defer r.release()
+	return r.ReadWriteCloser.Close()
+}
+
+func releaseOnClose(body io.ReadCloser, release func()) io.ReadCloser {
+	if body == nil {
+		release()
+		return body
+	}
+	if readWriteCloser, ok := body.(io.ReadWriteCloser); ok {
+		return &releaseReadWriteCloser{ReadWriteCloser: readWriteCloser, release: release}
+	}
+	return &releaseReadCloser{ReadCloser: body, release: release}
+}
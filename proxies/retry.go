@@ -0,0 +1,250 @@
+package proxies
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRetryAttempts is the number of attempts, including the first, made for a request
+	DefaultRetryAttempts = 3
+	// DefaultRetryBackoff is the base delay before the first retry, it doubles for every later retry
+	DefaultRetryBackoff = 25 * time.Millisecond
+	// DefaultRetryMaxBackoff is the longest delay between two attempts
+	DefaultRetryMaxBackoff = time.Second
+)
+
+// DefaultRetryOn is the list of status codes that are retried when no status codes are configured
+var DefaultRetryOn = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// RetryPolicy describes when and how often failed requests are sent again, zero values are replaced with
+// defaults. Requests are retried on transport errors, on the RetryOn status codes and when the condition
+// returns true
+type RetryPolicy struct {
+	MaxAttempts int
+	// PerTryTimeout limits every attempt separately, attempts are only limited by the request when zero
+	PerTryTimeout time.Duration
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	RetryOn       []int
+	// RetryNonIdempotent allows retrying methods like POST that are not idempotent
+	RetryNonIdempotent bool
+	Condition          func(response *http.Response, err error) bool
+}
+
+type retryTransport struct {
+	transport http.RoundTripper
+	policy    RetryPolicy
+	retryOn   map[int]bool
+}
+
+func (t *retryTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if !t.retryable(request) {
+		return t.transport.RoundTrip(request)
+	}
+
+	target := *request.URL
+	host := request.Host
+	tried := map[Backend]bool{}
+	for attempt := 1; ; attempt++ {
+		attemptRequest, cancel, err := t.prepare(request, attempt, &target, host)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := t.transport.RoundTrip(attemptRequest)
+		if attempt >= t.policy.MaxAttempts || !t.shouldRetry(request, response, err) {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			response.Body = releaseOnClose(response.Body, cancel)
+			return response, nil
+		}
+		if err == nil {
+			discard(response)
+		}
+		cancel()
+
+		if err := t.wait(request.Context(), attempt); err != nil {
+			return nil, err
+		}
+		host = t.reselect(request, tried, &target, host)
+	}
+}
+
+func (t *retryTransport) unwrap() http.RoundTripper {
+	return t.transport
+}
+
+// retryable reports whether the method may be retried and the body can be sent again
+func (t *retryTransport) retryable(request *http.Request) bool {
+	if !t.policy.RetryNonIdempotent && !idempotent(request) {
+		return false
+	}
+	return request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+}
+
+// prepare creates the request for the attempt with its own timeout and a fresh copy of the body
+func (t *retryTransport) prepare(request *http.Request, attempt int, target *url.URL, host string) (*http.Request, context.CancelFunc, error) {
+	ctx, cancel := request.Context(), context.CancelFunc(func() {})
+	if t.policy.PerTryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.policy.PerTryTimeout)
+	}
+
+	attemptRequest := request.Clone(ctx)
+	attemptURL := *target
+	attemptRequest.URL = &attemptURL
+	attemptRequest.Host = host
+	if attempt > 1 && request.Body != nil && request.Body != http.NoBody {
+		body, err := request.GetBody()
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		attemptRequest.Body = body
+	}
+	return attemptRequest, cancel, nil
+}
+
+func (t *retryTransport) shouldRetry(request *http.Request, response *http.Response, err error) bool {
+	// the client is gone, so there is nobody to retry for
+	if request.Context().Err() != nil {
+		return false
+	}
+	if t.policy.Condition != nil && t.policy.Condition(response, err) {
+		return true
+	}
+	if err != nil {
+		return true
+	}
+	return t.retryOn[response.StatusCode]
+}
+
+// wait sleeps for the exponential backoff of the attempt with jitter so retries from many clients spread out
+func (t *retryTransport) wait(ctx context.Context, attempt int) error {
+	backoff := t.policy.Backoff
+	for i := 1; i < attempt && backoff < t.policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > t.policy.MaxBackoff {
+		backoff = t.policy.MaxBackoff
+	}
+	backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reselect moves the request to a pool member that has not been tried yet, the target and host are
+// updated and the new host is returned
+func (t *retryTransport) reselect(request *http.Request, tried map[Backend]bool, target *url.URL, host string) string {
+	state := requestStateOf(request)
+	if state == nil {
+		return host
+	}
+	pool, current := state.selectedPool(), state.selectedBackend()
+	if pool == nil || current == nil {
+		return host
+	}
+	tried[current] = true
+
+	// the affinity cookie names the member that failed, so the retry is selected without it
+	selector := pool
+	if affinity, ok := pool.(*affinityPool); ok {
+		selector = affinity.BackendPool
+	}
+
+	var next Backend
+	for range pool.Backends() {
+		next = selector.Select(request)
+		if next == nil || !tried[next] {
+			break
+		}
+	}
+	if next == nil || next == current {
+		return host
+	}
+	state.selectBackend(pool, next)
+
+	from, to := current.URL(), next.URL()
+	path := strings.TrimPrefix(target.Path, from.Path)
+	if to.Path != "" {
+		path = SingleJoiningSlash(to.Path, path)
+	} else if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	target.Scheme = to.Scheme
+	target.Host = to.Host
+	target.Path = path
+	target.RawPath = ""
+	if strings.EqualFold(host, from.Host) {
+		return to.Host
+	}
+	return host
+}
+
+// idempotent reports whether the request may be sent more than once, requests with an idempotency key
+// are treated as idempotent like they are by net/http
+func idempotent(request *http.Request) bool {
+	switch request.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	_, ok := request.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = request.Header["X-Idempotency-Key"]
+	}
+	return ok
+}
+
+// discard drains a little of the abandoned response so the connection can be reused
+func discard(response *http.Response) {
+	io.CopyN(ioutil.Discard, response.Body, 4096)
+	response.Body.Close()
+}
+
+// NewRetryTransport wraps the transport so failed requests are retried according to the policy, retries
+// are sent to a different member of the pool selected by RewriteHostPool when one is available
+func NewRetryTransport(transport http.RoundTripper, policy RetryPolicy) http.RoundTripper {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = DefaultRetryAttempts
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = DefaultRetryBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	if policy.MaxBackoff < policy.Backoff {
+		policy.MaxBackoff = policy.Backoff
+	}
+	if policy.RetryOn == nil {
+		policy.RetryOn = DefaultRetryOn
+	}
+
+	retryOn := map[int]bool{}
+	for _, status := range policy.RetryOn {
+		retryOn[status] = true
+	}
+	return &retryTransport{
+		transport: transport,
+		policy:    policy,
+		retryOn:   retryOn,
+	}
+}
@@ -0,0 +1,141 @@
+package proxies_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RetryTransport", func() {
+	var (
+		server   *httptest.Server
+		frontend *httptest.Server
+		requests int32
+		handle   func(w http.ResponseWriter, r *http.Request, attempt int32)
+	)
+	BeforeEach(func() {
+		atomic.StoreInt32(&requests, 0)
+		handle = func(w http.ResponseWriter, r *http.Request, attempt int32) {
+			body, _ := ioutil.ReadAll(r.Body)
+			fmt.Fprintf(w, "%s %s", r.URL.Path, body)
+		}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handle(w, r, atomic.AddInt32(&requests, 1))
+		}))
+	})
+	AfterEach(func() {
+		if frontend != nil {
+			frontend.Close()
+		}
+		server.Close()
+	})
+	// closedURL returns the url of a port that refuses connections
+	closedURL := func(path string) *url.URL {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		address := listener.Addr().String()
+		listener.Close()
+		closed, err := url.Parse("http://" + address + path)
+		Expect(err).To(BeNil())
+		return closed
+	}
+	serverURL := func(path string) *url.URL {
+		u, err := url.Parse(server.URL + path)
+		Expect(err).To(BeNil())
+		return u
+	}
+	start := func(builder proxies.ReverseProxyBuilder, policy proxies.RetryPolicy) {
+		policy.Backoff = time.Millisecond
+		frontend = httptest.NewServer(builder.ToReverseProxy(proxies.NewRetryTransport(nil, policy)))
+	}
+	send := func(method string, body string) (int, string) {
+		req, err := http.NewRequest(method, frontend.URL+"/app/ok", strings.NewReader(body))
+		Expect(err).To(BeNil())
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).To(BeNil())
+		defer res.Body.Close()
+		content, err := ioutil.ReadAll(res.Body)
+		Expect(err).To(BeNil())
+		return res.StatusCode, string(content)
+	}
+	It("retries connection failures on a different member", func() {
+		pool := proxies.NewBackendPool(nil,
+			proxies.NewBackend(closedURL("/closed"), 1),
+			proxies.NewBackend(serverURL("/base"), 1))
+		start(proxies.NewReverseProxyBuilder().RewriteHostPool(pool, "/app"), proxies.RetryPolicy{})
+
+		for i := 0; i < 4; i++ {
+			status, body := send("GET", "")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("/base/ok "))
+		}
+	})
+	It("does not retry methods that are not idempotent", func() {
+		pool := proxies.NewBackendPool(nil,
+			proxies.NewBackend(closedURL(""), 1),
+			proxies.NewBackend(serverURL(""), 1))
+		start(proxies.NewReverseProxyBuilder().RewriteHostPool(pool, "/app"), proxies.RetryPolicy{})
+
+		statuses := []int{}
+		for i := 0; i < 2; i++ {
+			status, _ := send("POST", "data")
+			statuses = append(statuses, status)
+		}
+		Expect(statuses).To(ConsistOf(http.StatusOK, http.StatusBadGateway))
+	})
+	It("replays buffered bodies", func() {
+		pool := proxies.NewBackendPool(nil,
+			proxies.NewBackend(closedURL(""), 1),
+			proxies.NewBackend(serverURL(""), 1))
+		builder := proxies.NewReverseProxyBuilder().
+			RewriteHostPool(pool, "/app").
+			ReplaceRequestBody("data", "replayed")
+		start(builder, proxies.RetryPolicy{RetryNonIdempotent: true})
+
+		for i := 0; i < 2; i++ {
+			status, body := send("POST", "data")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("/ok replayed"))
+		}
+	})
+	It("retries status codes until the attempts are exhausted", func() {
+		handle = func(w http.ResponseWriter, r *http.Request, attempt int32) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		start(proxies.NewReverseProxyBuilder().RewriteHost(serverURL(""), "/app"), proxies.RetryPolicy{MaxAttempts: 4})
+
+		status, _ := send("GET", "")
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(4)))
+	})
+	It("retries attempts that exceed the per try timeout", func() {
+		handle = func(w http.ResponseWriter, r *http.Request, attempt int32) {
+			if attempt == 1 {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(time.Second):
+				}
+				return
+			}
+			fmt.Fprint(w, "second")
+		}
+		start(proxies.NewReverseProxyBuilder().RewriteHost(serverURL(""), "/app"), proxies.RetryPolicy{
+			PerTryTimeout: 50 * time.Millisecond,
+		})
+
+		status, body := send("GET", "")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("second"))
+	})
+})
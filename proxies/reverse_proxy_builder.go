@@ -2,11 +2,11 @@ package proxies
 
 import (
 	"bytes"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"regexp"
 	"strconv"
 	"strings"
 )
@@ -27,10 +27,40 @@ type RequestRewrite func(request *http.Request)
 // ResponseRewrite defines a function interface for rewriting responses
 type ResponseRewrite func(response *http.Response)
 
+// RequestRewriteFunc defines a function interface for rewriting requests that can fail, a failed request is
+// answered by the error handler instead of being sent to the backend
+type RequestRewriteFunc func(request *http.Request) error
+
+// ResponseRewriteFunc defines a function interface for rewriting responses that can fail, a failed response is
+// replaced by the answer of the error handler
+type ResponseRewriteFunc func(response *http.Response) error
+
+// Func adapts the rewrite to a rewrite that never fails
+func (rewrite RequestRewrite) Func() RequestRewriteFunc {
+	return func(request *http.Request) error {
+		rewrite(request)
+		return nil
+	}
+}
+
+// Func adapts the rewrite to a rewrite that never fails
+func (rewrite ResponseRewrite) Func() ResponseRewriteFunc {
+	return func(response *http.Response) error {
+		rewrite(response)
+		return nil
+	}
+}
+
 type reverseProxyBuilder struct {
-	requestRewrites  []RequestRewrite
-	responseRewrites []ResponseRewrite
+	requestRewrites  []RequestRewriteFunc
+	responseRewrites []ResponseRewriteFunc
+	errorHandler     ErrorHandler
+	mirrors          []*mirror
+	body             bodyOptions
 	sourcePathPrefix string
+	transport        http.RoundTripper
+	errors           BuildErrors
+	paths            pathRewrites
 }
 
 // RequestCondition provides a function interface for checking http request condition
@@ -50,13 +80,43 @@ func allResponses(r *http.Response) bool {
 // ReverseProxyBuilder provides a builder interface for creating a reverse proxy
 type ReverseProxyBuilder interface {
 	ToReverseProxy(transport http.RoundTripper) *httputil.ReverseProxy
+	Build() (http.Handler, error)
+	Transport(transport http.RoundTripper) ReverseProxyBuilder
 	RequestRewrite(rewrite RequestRewrite) ReverseProxyBuilder
+	RequestRewriteFunc(rewrite RequestRewriteFunc) ReverseProxyBuilder
 	RewriteHost(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder
 	RewriteRedirect(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder
 	RewriteRequestBody(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder
 	RewriteResponseBody(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder
 	RewriteRequestCookies(forwardeURL *url.URL, pathPrefix string) ReverseProxyBuilder
 	RewriteResponseCookies(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder
+	RewriteHostPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder
+	RewritePath(match string, replace string) ReverseProxyBuilder
+	RewriteRedirectPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder
+	RewriteRequestBodyPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder
+	RewriteResponseBodyPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder
+	RewriteRequestCookiesPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder
+	RewriteResponseCookiesPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder
+	RewriteHTML(forwardedURL *url.URL, pathPrefix string, options HTMLOptions) ReverseProxyBuilder
+	RewriteHTMLPool(pool BackendPool, pathPrefix string, options HTMLOptions) ReverseProxyBuilder
+	RewriteCSS(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder
+	RewriteCSSPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder
+	RewriteJavaScript(forwardedURL *url.URL, pathPrefix string, patterns ...string) ReverseProxyBuilder
+	RewriteJavaScriptPool(pool BackendPool, pathPrefix string, patterns ...string) ReverseProxyBuilder
+	RewriteJSONURLs(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder
+	RewriteJSONURLsPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder
+	SetRequestJSON(path string, value string) ReverseProxyBuilder
+	DeleteRequestJSON(path string) ReverseProxyBuilder
+	RenameRequestJSON(path string, name string) ReverseProxyBuilder
+	ReplaceRequestJSON(path string, match string, replace string) ReverseProxyBuilder
+	SetResponseJSON(path string, value string) ReverseProxyBuilder
+	DeleteResponseJSON(path string) ReverseProxyBuilder
+	RenameResponseJSON(path string, name string) ReverseProxyBuilder
+	ReplaceResponseJSON(path string, match string, replace string) ReverseProxyBuilder
+	RewriteWSDL(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder
+	RewriteWSDLPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder
+	ReplaceRequestXML(path string, match string, replace string) ReverseProxyBuilder
+	ReplaceResponseXML(path string, match string, replace string) ReverseProxyBuilder
 	AddRequestHeader(name string, value string) ReverseProxyBuilder
 	AddRequestHeaderIf(name string, value string, condition RequestCondition) ReverseProxyBuilder
 	SetRequestHeader(name string, value string) ReverseProxyBuilder
@@ -70,32 +130,91 @@ type ReverseProxyBuilder interface {
 	ReplaceRequestHeaderValue(name string, match string, replace string) ReverseProxyBuilder
 	ReplaceRequestHeaderValueIf(name string, match string, replace string, condition RequestCondition) ReverseProxyBuilder
 	ReplaceRequestBody(match, replace string) ReverseProxyBuilder
+	ReplaceRequestBodyIf(match, replace string, condition RequestCondition) ReverseProxyBuilder
+	ReplaceRequestFormField(name, match, replace string) ReverseProxyBuilder
+	ReplaceRequestFormFieldIf(name, match, replace string, condition RequestCondition) ReverseProxyBuilder
 	ResponseRewrite(rewrite ResponseRewrite) ReverseProxyBuilder
+	ResponseRewriteFunc(rewrite ResponseRewriteFunc) ReverseProxyBuilder
+	AddResponseHeader(name string, value string) ReverseProxyBuilder
+	AddResponseHeaderIf(name string, value string, condition ResponseCondition) ReverseProxyBuilder
+	SetResponseHeader(name string, value string) ReverseProxyBuilder
+	SetResponseHeaderIf(name string, value string, condition ResponseCondition) ReverseProxyBuilder
+	CopyResponseHeader(source string, destination string) ReverseProxyBuilder
+	CopyResponseHeaderIf(source string, destination string, condition ResponseCondition) ReverseProxyBuilder
+	DeleteResponseHeader(name string) ReverseProxyBuilder
+	DeleteResponseHeaderIf(name string, condition ResponseCondition) ReverseProxyBuilder
 	ReplaceResponseHeader(name, match, replace string) ReverseProxyBuilder
+	ReplaceResponseHeaderIf(name, match, replace string, condition ResponseCondition) ReverseProxyBuilder
+	ReplaceResponseHeaderValue(name, match, replace string) ReverseProxyBuilder
+	ReplaceResponseHeaderValueIf(name, match, replace string, condition ResponseCondition) ReverseProxyBuilder
 	ReplaceResponseBody(match, replace string) ReverseProxyBuilder
+	ReplaceResponseBodyIf(match, replace string, condition ResponseCondition) ReverseProxyBuilder
+	Mirror(shadowURL *url.URL, percentage float64, maxBodyBytes int64) ReverseProxyBuilder
+	RequestBodyLimit(maxBytes int64, passthrough bool) ReverseProxyBuilder
+	RewriteContentTypes(types ...string) ReverseProxyBuilder
+	RewrittenBodyEncoding(encoding BodyEncoding) ReverseProxyBuilder
+	ErrorHandler(handler ErrorHandler) ReverseProxyBuilder
 }
 
+// Build returns the reverse proxy that sends requests through the transport, or the errors of every builder
+// method that was called with an invalid argument
+func (builder *reverseProxyBuilder) Build() (http.Handler, error) {
+	if len(builder.errors) > 0 {
+		return nil, builder.errors
+	}
+	return builder.ToReverseProxy(builder.transport), nil
+}
+
+// Transport sets the round tripper the reverse proxy returned by Build sends requests with, the default
+// transport is used when it is not set
+func (builder *reverseProxyBuilder) Transport(transport http.RoundTripper) ReverseProxyBuilder {
+	builder.transport = transport
+	return builder
+}
+
+// ToReverseProxy creates the reverse proxy, it panics when a builder method was called with an invalid argument.
+// Use Build to get the errors instead
 func (builder *reverseProxyBuilder) ToReverseProxy(transport http.RoundTripper) *httputil.ReverseProxy {
+	if len(builder.errors) > 0 {
+		panic(builder.errors)
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
 	reverseProxy := &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
+			withRequestState(req)
 			for _, rewrite := range builder.requestRewrites {
-				rewrite(req)
+				if err := rewrite(req); err != nil {
+					// the backend transport answers failed requests without sending them
+					fail(req, requestStateOf(req), err)
+					break
+				}
+			}
+			for _, m := range builder.mirrors {
+				m.send(req, transport)
 			}
 		},
 		ModifyResponse: func(resp *http.Response) error {
 			for _, rewrite := range builder.responseRewrites {
-				rewrite(resp)
+				if err := rewrite(resp); err != nil {
+					return err
+				}
 			}
 			return nil
 		},
-		Transport: transport,
+		Transport:    &backendTransport{transport: transport},
+		ErrorHandler: logErrors(builder.errorHandler),
 	}
 
 	return reverseProxy
 }
 
 func (builder *reverseProxyBuilder) RewriteHost(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder {
-	targetQuery := forwardedURL.RawQuery
+	return builder.RewriteHostPool(singleBackendPool(forwardedURL), pathPrefix)
+}
+
+func (builder *reverseProxyBuilder) RewriteHostPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder {
 	return builder.RequestRewrite(func(r *http.Request) {
 
 		originalHost := r.Header.Get(HeaderXForwardedHost)
@@ -120,6 +239,19 @@ func (builder *reverseProxyBuilder) RewriteHost(forwardedURL *url.URL, pathPrefi
 
 		// todo add X-Forwarded-For
 
+		backend := pool.Select(r)
+		if backend == nil {
+			// no backend is available, leave the host empty so the transport fails the request
+			r.URL.Host = ""
+			r.Host = ""
+			return
+		}
+		if state := requestStateOf(r); state != nil {
+			state.selectBackend(pool, backend)
+		}
+		forwardedURL := backend.URL()
+		targetQuery := forwardedURL.RawQuery
+
 		r.URL.Host = forwardedURL.Host
 		r.URL.Scheme = forwardedURL.Scheme
 		r.Host = forwardedURL.Host
@@ -132,6 +264,15 @@ func (builder *reverseProxyBuilder) RewriteHost(forwardedURL *url.URL, pathPrefi
 			}
 		}
 
+		// map the path below the prefix, the query of the template comes before the query of the request
+		path, query := builder.paths.forward(r.URL.Path)
+		r.URL.Path = path
+		if query != "" && r.URL.RawQuery != "" {
+			r.URL.RawQuery = query + "&" + r.URL.RawQuery
+		} else if query != "" {
+			r.URL.RawQuery = query
+		}
+
 		// if the forwarded URL has a path, prepend the forwarded path to the current path
 		if strings.TrimSpace(forwardedURL.Path) != "" {
 			r.URL.Path = SingleJoiningSlash(forwardedURL.Path, r.URL.Path)
@@ -146,20 +287,28 @@ func (builder *reverseProxyBuilder) RewriteHost(forwardedURL *url.URL, pathPrefi
 }
 
 func (builder *reverseProxyBuilder) RewriteRedirect(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder {
-	return builder.ResponseRewrite(func(response *http.Response) {
+	return builder.RewriteRedirectPool(singleBackendPool(forwardedURL), pathPrefix)
+}
+
+func (builder *reverseProxyBuilder) RewriteRedirectPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder {
+	return builder.ResponseRewriteFunc(func(response *http.Response) error {
 
 		// check the response header 'location', if missing bail
 		location := response.Header.Get(HeaderLocation)
-		if strings.TrimSpace(location) == "" {
-			return
+		if strings.TrimSpace(location) == "" || response.Request == nil {
+			return nil
 		}
 
 		request := response.Request
 
-		target, _ := url.Parse(location)
+		target, err := url.Parse(location)
+		if err != nil {
+			return NewRewriteError(http.StatusBadGateway, "invalid location '%s': %w", location, err)
+		}
 
-		// the target matches the forwarded URL host, so replace it
-		if target.Host == forwardedURL.Host {
+		// the target matches the host of a pool member, so replace it
+		if backend := pool.Find(target.Host); backend != nil {
+			forwardedURL := backend.URL()
 
 			// rewrite the host
 			forwardedHost := request.Header.Get(HeaderXForwardedHost)
@@ -172,8 +321,9 @@ func (builder *reverseProxyBuilder) RewriteRedirect(forwardedURL *url.URL, pathP
 			}
 
 			// remove the forwardedURL prefix
-			// add the pathPrefix for the souce
+			// map the path back and add the pathPrefix for the souce
 			target.Path = strings.TrimPrefix(target.Path, forwardedURL.Path)
+			target.Path, target.RawQuery = builder.paths.backward(SingleJoiningSlash("/", target.Path), target.RawQuery)
 			target.Path = SingleJoiningSlash(pathPrefix, target.Path)
 		}
 
@@ -184,27 +334,40 @@ func (builder *reverseProxyBuilder) RewriteRedirect(forwardedURL *url.URL, pathP
 			Scheme: request.Header.Get(HeaderXForwardedProto),
 			Host:   request.Header.Get(HeaderXForwardedHost),
 		}
-
-		forwardedURLString := strings.TrimSuffix(forwardedURL.String(), "/")
 		queryURLString := strings.TrimSuffix(queryURL.String(), "/")
-		target.RawQuery = strings.Replace(target.RawQuery, forwardedURLString, queryURLString, -1)
-
-		forwardedURLStringEncoded := url.QueryEscape(forwardedURLString)
 		queryURLStringEncoded := url.QueryEscape(queryURLString)
-		target.RawQuery = strings.Replace(target.RawQuery, forwardedURLStringEncoded, queryURLStringEncoded, -1)
+
+		for _, forwardedURL := range backendURLs(pool) {
+			forwardedURLString := strings.TrimSuffix(forwardedURL.String(), "/")
+			target.RawQuery = strings.Replace(target.RawQuery, forwardedURLString, queryURLString, -1)
+
+			forwardedURLStringEncoded := url.QueryEscape(forwardedURLString)
+			target.RawQuery = strings.Replace(target.RawQuery, forwardedURLStringEncoded, queryURLStringEncoded, -1)
+		}
 
 		response.Header.Set(HeaderLocation, target.String())
+		return nil
 	})
 }
+
 func (builder *reverseProxyBuilder) RewriteRequestBody(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder {
-	return builder.RequestRewrite(func(request *http.Request) {
+	return builder.RewriteRequestBodyPool(singleBackendPool(forwardedURL), pathPrefix)
+}
+
+func (builder *reverseProxyBuilder) RewriteRequestBodyPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder {
+	return builder.RequestRewriteFunc(func(request *http.Request) error {
 		if request.Body == nil {
-			return
+			return nil
+		}
+		forwardedURL := selectedURL(pool, request)
+		if forwardedURL == nil {
+			return nil
 		}
-		bodyBytes, _ := ioutil.ReadAll(request.Body)
-		bodyString := string(bodyBytes)
 
-		source, _ := url.Parse(request.RequestURI)
+		source, err := url.Parse(request.RequestURI)
+		if err != nil {
+			return NewRewriteError(http.StatusBadRequest, "invalid request uri '%s': %w", request.RequestURI, err)
+		}
 
 		originalHost := request.Header.Get(HeaderXForwardedHost)
 		if strings.TrimSpace(originalHost) != "" {
@@ -218,51 +381,302 @@ func (builder *reverseProxyBuilder) RewriteRequestBody(forwardedURL *url.URL, pa
 
 		source.Path = SingleJoiningSlash(pathPrefix, source.Path)
 
-		// rewrite any matching urls in the body with the forwarded URL
-		bodyString = strings.Replace(bodyString, source.String(), forwardedURL.String(), -1)
-		bodyBytes = []byte(bodyString)
-		request.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
+		// form fields are rewritten decoded so url encoded values like redirect uris are found too
+		rewriteFormBody(request, func(name string, value string) string {
+			return strings.Replace(value, source.String(), forwardedURL.String(), -1)
+		}, builder.body)
 
-		request.ContentLength = int64(len(bodyBytes))
-		request.Header.Set("Content-Length", strconv.Itoa(len(bodyBytes)))
-		request.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
+		// rewrite any matching urls in the body with the forwarded URL
+		matcher := newLiteralMatcher([]string{source.String()}, []string{forwardedURL.String()})
+		rewriteRequestBody(request, streamRewrite(matcher), builder.body)
+		return nil
 	})
 }
 
 func (builder *reverseProxyBuilder) RewriteResponseBody(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder {
+	return builder.RewriteResponseBodyPool(singleBackendPool(forwardedURL), pathPrefix)
+}
+
+func (builder *reverseProxyBuilder) RewriteResponseBodyPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder {
+	return builder.ResponseRewriteFunc(func(response *http.Response) error {
+		if response.Body == nil || response.Request == nil {
+			return nil
+		}
+
+		request := response.Request
+
+		olds := []string{}
+		news := []string{}
+		for _, forwardedURL := range backendURLs(pool) {
+			source, err := url.Parse(request.RequestURI)
+			if err != nil {
+				return NewRewriteError(http.StatusBadRequest, "invalid request uri '%s': %w", request.RequestURI, err)
+			}
+			originalHost := request.Header.Get(HeaderXForwardedHost)
+			if strings.TrimSpace(originalHost) != "" {
+				source.Host = originalHost
+			}
+			originalScheme := request.Header.Get(HeaderXForwardedProto)
+			if strings.TrimSpace(originalScheme) != "" {
+				source.Scheme = originalScheme
+			} else {
+				source.Scheme = forwardedURL.Scheme
+			}
+			source.Path = strings.TrimSuffix(pathPrefix, "/")
+
+			olds = append(olds, forwardedURL.String())
+			news = append(news, source.String())
+		}
+		rewriteResponseBody(response, streamRewrite(newLiteralMatcher(olds, news)), builder.body)
+		return nil
+	})
+}
+
+func (builder *reverseProxyBuilder) RewriteHTML(forwardedURL *url.URL, pathPrefix string, options HTMLOptions) ReverseProxyBuilder {
+	return builder.RewriteHTMLPool(singleBackendPool(forwardedURL), pathPrefix, options)
+}
+
+// RewriteHTMLPool parses html responses and maps the urls of links, images, forms, base elements, refreshes
+// and inline styles from the backend pool to the frontend host and path prefix, including root relative urls
+func (builder *reverseProxyBuilder) RewriteHTMLPool(pool BackendPool, pathPrefix string, options HTMLOptions) ReverseProxyBuilder {
 	return builder.ResponseRewrite(func(response *http.Response) {
-		if response.Body == nil {
+		if response.Request == nil {
 			return
 		}
-		bodyBytes, _ := ioutil.ReadAll(response.Body)
-		bodyString := string(bodyBytes)
-		response.Body.Close()
+		mapper := builder.urlMapper(pool, pathPrefix, response)
+		htmlOptions := builder.body
+		htmlOptions.types = htmlContentTypes
+		rewriteResponseBody(response, func(body io.ReadCloser) io.ReadCloser {
+			return newHTMLRewriter(body, mapper, options)
+		}, htmlOptions)
+	})
+}
 
-		request := response.Request
+func (builder *reverseProxyBuilder) RewriteCSS(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder {
+	return builder.RewriteCSSPool(singleBackendPool(forwardedURL), pathPrefix)
+}
 
-		source, _ := url.Parse(request.RequestURI)
-		originalHost := request.Header.Get(HeaderXForwardedHost)
-		if strings.TrimSpace(originalHost) != "" {
-			source.Host = originalHost
+// RewriteCSSPool maps the urls of url() functions and @import rules in stylesheets from the backend pool to
+// the frontend host and path prefix
+func (builder *reverseProxyBuilder) RewriteCSSPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder {
+	return builder.ResponseRewrite(func(response *http.Response) {
+		if response.Request == nil {
+			return
 		}
-		originalScheme := request.Header.Get(HeaderXForwardedProto)
-		if strings.TrimSpace(originalScheme) != "" {
-			source.Scheme = originalScheme
-		} else {
-			source.Scheme = forwardedURL.Scheme
+		options := builder.body
+		options.types = cssContentTypes
+		matcher := newCSSMatcher(builder.urlMapper(pool, pathPrefix, response))
+		rewriteResponseBody(response, streamRewrite(matcher), options)
+	})
+}
+
+func (builder *reverseProxyBuilder) RewriteJavaScript(forwardedURL *url.URL, pathPrefix string, patterns ...string) ReverseProxyBuilder {
+	return builder.RewriteJavaScriptPool(singleBackendPool(forwardedURL), pathPrefix, patterns...)
+}
+
+// RewriteJavaScriptPool maps absolute backend urls in the string literals of scripts, and string literals
+// that start with one of the patterns like "/api/", to the frontend host and path prefix
+func (builder *reverseProxyBuilder) RewriteJavaScriptPool(pool BackendPool, pathPrefix string, patterns ...string) ReverseProxyBuilder {
+	regex := javaScriptRegex(patterns)
+	return builder.ResponseRewrite(func(response *http.Response) {
+		if response.Request == nil {
+			return
+		}
+		options := builder.body
+		options.types = javaScriptContentTypes
+		matcher := newMappingMatcher(regex, builder.urlMapper(pool, pathPrefix, response))
+		rewriteResponseBody(response, streamRewrite(matcher), options)
+	})
+}
+
+func (builder *reverseProxyBuilder) RewriteJSONURLs(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder {
+	return builder.RewriteJSONURLsPool(singleBackendPool(forwardedURL), pathPrefix)
+}
+
+// RewriteJSONURLsPool maps every string value of json responses that is an absolute url of the backend pool,
+// like the links of HAL or JSON:API documents, to the frontend host and path prefix
+func (builder *reverseProxyBuilder) RewriteJSONURLsPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder {
+	return builder.ResponseRewrite(func(response *http.Response) {
+		if response.Request == nil {
+			return
+		}
+		builder.editResponseJSON(response, mapJSONURLs(builder.urlMapper(pool, pathPrefix, response)))
+	})
+}
+
+// SetRequestJSON sets the value at the JSON Pointer or JSONPath location of json request bodies to the json
+// value, missing members are added
+func (builder *reverseProxyBuilder) SetRequestJSON(path string, value string) ReverseProxyBuilder {
+	parsed, ok := builder.jsonPath("SetRequestJSON", path)
+	if !ok || !builder.jsonValue("SetRequestJSON", value) {
+		return builder
+	}
+	return builder.requestJSON(setJSON(parsed, value))
+}
+
+// DeleteRequestJSON removes the members or elements at the location of json request bodies
+func (builder *reverseProxyBuilder) DeleteRequestJSON(path string) ReverseProxyBuilder {
+	parsed, ok := builder.jsonPath("DeleteRequestJSON", path)
+	if !ok {
+		return builder
+	}
+	return builder.requestJSON(deleteJSON(parsed))
+}
+
+// RenameRequestJSON renames the members at the location of json request bodies
+func (builder *reverseProxyBuilder) RenameRequestJSON(path string, name string) ReverseProxyBuilder {
+	parsed, ok := builder.jsonPath("RenameRequestJSON", path)
+	if !ok {
+		return builder
+	}
+	return builder.requestJSON(renameJSON(parsed, name))
+}
+
+// ReplaceRequestJSON replaces the matches of the regular expression in the string values at the location of
+// json request bodies
+func (builder *reverseProxyBuilder) ReplaceRequestJSON(path string, match string, replace string) ReverseProxyBuilder {
+	parsed, ok := builder.jsonPath("ReplaceRequestJSON", path)
+	regex := builder.regex("ReplaceRequestJSON", match)
+	if !ok || regex == nil {
+		return builder
+	}
+	return builder.requestJSON(replaceJSON(parsed, regex, replace))
+}
+
+// SetResponseJSON sets the value at the JSON Pointer or JSONPath location of json response bodies to the json
+// value, missing members are added
+func (builder *reverseProxyBuilder) SetResponseJSON(path string, value string) ReverseProxyBuilder {
+	parsed, ok := builder.jsonPath("SetResponseJSON", path)
+	if !ok || !builder.jsonValue("SetResponseJSON", value) {
+		return builder
+	}
+	return builder.responseJSON(setJSON(parsed, value))
+}
+
+// DeleteResponseJSON removes the members or elements at the location of json response bodies
+func (builder *reverseProxyBuilder) DeleteResponseJSON(path string) ReverseProxyBuilder {
+	parsed, ok := builder.jsonPath("DeleteResponseJSON", path)
+	if !ok {
+		return builder
+	}
+	return builder.responseJSON(deleteJSON(parsed))
+}
+
+// RenameResponseJSON renames the members at the location of json response bodies
+func (builder *reverseProxyBuilder) RenameResponseJSON(path string, name string) ReverseProxyBuilder {
+	parsed, ok := builder.jsonPath("RenameResponseJSON", path)
+	if !ok {
+		return builder
+	}
+	return builder.responseJSON(renameJSON(parsed, name))
+}
+
+// ReplaceResponseJSON replaces the matches of the regular expression in the string values at the location of
+// json response bodies
+func (builder *reverseProxyBuilder) ReplaceResponseJSON(path string, match string, replace string) ReverseProxyBuilder {
+	parsed, ok := builder.jsonPath("ReplaceResponseJSON", path)
+	regex := builder.regex("ReplaceResponseJSON", match)
+	if !ok || regex == nil {
+		return builder
+	}
+	return builder.responseJSON(replaceJSON(parsed, regex, replace))
+}
+
+// requestJSON edits json request bodies, bodies that are not valid json are rejected with 400 Bad Request
+func (builder *reverseProxyBuilder) requestJSON(edit jsonEdit) ReverseProxyBuilder {
+	return builder.RequestRewrite(func(request *http.Request) {
+		options := builder.body
+		options.types = jsonContentTypes
+		rewriteRequestBody(request, func(body io.ReadCloser) io.ReadCloser {
+			return newJSONRewriter(body, options.limit.maxBytes, true, edit)
+		}, options)
+	})
+}
+
+func (builder *reverseProxyBuilder) responseJSON(edit jsonEdit) ReverseProxyBuilder {
+	return builder.ResponseRewrite(func(response *http.Response) {
+		builder.editResponseJSON(response, edit)
+	})
+}
+
+// editResponseJSON edits json response bodies up to the body limit, larger bodies and bodies that are not
+// valid json are sent unchanged
+func (builder *reverseProxyBuilder) editResponseJSON(response *http.Response, edit jsonEdit) {
+	options := builder.body
+	options.types = jsonContentTypes
+	rewriteResponseBody(response, func(body io.ReadCloser) io.ReadCloser {
+		return newJSONRewriter(body, options.limit.maxBytes, false, edit)
+	}, options)
+}
+
+func (builder *reverseProxyBuilder) RewriteWSDL(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder {
+	return builder.RewriteWSDLPool(singleBackendPool(forwardedURL), pathPrefix)
+}
+
+// RewriteWSDLPool maps the soap:address locations, imports and schema locations of WSDL and XSD responses from
+// the backend pool to the frontend host and path prefix
+func (builder *reverseProxyBuilder) RewriteWSDLPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder {
+	return builder.ResponseRewrite(func(response *http.Response) {
+		if response.Request == nil {
+			return
 		}
-		source.Path = strings.TrimSuffix(pathPrefix, "/")
+		builder.editResponseXML(response, mapWSDLLocations(builder.urlMapper(pool, pathPrefix, response)))
+	})
+}
 
-		bodyString = strings.Replace(bodyString, forwardedURL.String(), source.String(), -1)
-		bodyBytes = []byte(bodyString)
-		response.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
-		response.ContentLength = int64(len(bodyBytes))
-		response.Header.Set("Content-Length", strconv.Itoa(len(bodyBytes)))
+// ReplaceRequestXML replaces the matches of the regular expression in the element text or attribute at the
+// location of xml request bodies, like /Envelope/Body//user/text() or //soap:address/@location. Bodies that are
+// not valid xml are rejected with 400 Bad Request
+func (builder *reverseProxyBuilder) ReplaceRequestXML(path string, match string, replace string) ReverseProxyBuilder {
+	parsed := builder.xmlPath("ReplaceRequestXML", path)
+	regex := builder.regex("ReplaceRequestXML", match)
+	if parsed == nil || regex == nil {
+		return builder
+	}
+	edits := replaceXML(parsed, regex, replace)
+	return builder.RequestRewrite(func(request *http.Request) {
+		options := builder.body
+		options.types = xmlContentTypes
+		rewriteRequestBody(request, func(body io.ReadCloser) io.ReadCloser {
+			return newXMLRewriter(body, edits, true)
+		}, options)
 	})
 }
 
+// ReplaceResponseXML replaces the matches of the regular expression in the element text or attribute at the
+// location of xml response bodies
+func (builder *reverseProxyBuilder) ReplaceResponseXML(path string, match string, replace string) ReverseProxyBuilder {
+	parsed := builder.xmlPath("ReplaceResponseXML", path)
+	regex := builder.regex("ReplaceResponseXML", match)
+	if parsed == nil || regex == nil {
+		return builder
+	}
+	edits := replaceXML(parsed, regex, replace)
+	return builder.ResponseRewrite(func(response *http.Response) {
+		builder.editResponseXML(response, edits)
+	})
+}
+
+// editResponseXML rewrites xml response bodies while they stream, the rest of a body that is not valid xml is
+// sent unchanged
+func (builder *reverseProxyBuilder) editResponseXML(response *http.Response, edits []xmlEdit) {
+	options := builder.body
+	options.types = xmlContentTypes
+	rewriteResponseBody(response, func(body io.ReadCloser) io.ReadCloser {
+		return newXMLRewriter(body, edits, false)
+	}, options)
+}
+
 func (builder *reverseProxyBuilder) RewriteRequestCookies(forwardeURL *url.URL, pathPrefix string) ReverseProxyBuilder {
+	return builder.RewriteRequestCookiesPool(singleBackendPool(forwardeURL), pathPrefix)
+}
+
+func (builder *reverseProxyBuilder) RewriteRequestCookiesPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder {
 	return builder.RequestRewrite(func(request *http.Request) {
+		forwardedURL := selectedURL(pool, request)
+		if forwardedURL == nil {
+			return
+		}
 		cookies := []*http.Cookie{}
 		for _, c := range request.Cookies() {
 			cookies = append(cookies, c)
@@ -275,7 +689,7 @@ func (builder *reverseProxyBuilder) RewriteRequestCookies(forwardeURL *url.URL,
 			c.Path = strings.TrimPrefix(c.Path, pathPrefix)
 
 			// add the path prefix of the back end
-			c.Path = SingleJoiningSlash(c.Path, forwardeURL.Path)
+			c.Path = SingleJoiningSlash(c.Path, forwardedURL.Path)
 		}
 
 		// remove all cookies
@@ -289,21 +703,40 @@ func (builder *reverseProxyBuilder) RewriteRequestCookies(forwardeURL *url.URL,
 }
 
 func (builder *reverseProxyBuilder) RewriteResponseCookies(forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder {
+	return builder.RewriteResponseCookiesPool(singleBackendPool(forwardedURL), pathPrefix)
+}
+
+func (builder *reverseProxyBuilder) RewriteResponseCookiesPool(pool BackendPool, pathPrefix string) ReverseProxyBuilder {
 	builder.ResponseRewrite(func(response *http.Response) {
+		forwardedURL := selectedURL(pool, response.Request)
+		if forwardedURL == nil {
+			return
+		}
+		state := requestStateOf(response.Request)
 		cookies := []*http.Cookie{}
 		for _, c := range response.Cookies() {
 
 			cookies = append(cookies, c)
 
+			// cookies issued by the proxy already have a frontend path
+			if state != nil && state.issuedCookie(c.Name) {
+				continue
+			}
+
 			if !strings.HasPrefix(c.Path, forwardedURL.Path) {
 				continue
 			}
 
-			// remove the path prefix of the backend
-			c.Path = strings.TrimPrefix(c.Path, forwardedURL.Path)
+			// remove the path prefix of the backend and map the rest back
+			rest := strings.TrimPrefix(c.Path, forwardedURL.Path)
+			rest, _ = builder.paths.backward(SingleJoiningSlash("/", rest), "")
 
-			// add the path prefix of the front end
-			c.Path = SingleJoiningSlash(c.Path, pathPrefix)
+			// add the path prefix of the front end, a cookie of the backend root belongs to the prefix itself
+			if rest == "/" {
+				c.Path = SingleJoiningSlash("/", pathPrefix)
+			} else {
+				c.Path = SingleJoiningSlash(pathPrefix, rest)
+			}
 		}
 
 		// remove all cookies, we will add them back one at a time
@@ -320,6 +753,12 @@ func (builder *reverseProxyBuilder) RewriteResponseCookies(forwardedURL *url.URL
 }
 
 func (builder *reverseProxyBuilder) RequestRewrite(rewrite RequestRewrite) ReverseProxyBuilder {
+	return builder.RequestRewriteFunc(rewrite.Func())
+}
+
+// RequestRewriteFunc adds a rewrite that can fail, the rewrites after a failed one do not run and the request
+// is answered by the error handler
+func (builder *reverseProxyBuilder) RequestRewriteFunc(rewrite RequestRewriteFunc) ReverseProxyBuilder {
 	builder.requestRewrites = append(builder.requestRewrites, rewrite)
 	return builder
 }
@@ -367,7 +806,7 @@ func (builder *reverseProxyBuilder) DeleteRequestHeader(name string, value strin
 }
 
 func (builder *reverseProxyBuilder) DeleteRequestHeaderIf(name string, condition RequestCondition) ReverseProxyBuilder {
-	builder.requestRewrites = append(builder.requestRewrites, func(request *http.Request) {
+	builder.RequestRewrite(func(request *http.Request) {
 		if !condition(request) {
 			return
 		}
@@ -381,11 +820,14 @@ func (builder *reverseProxyBuilder) ReplaceRequestHeader(name string, match stri
 }
 
 func (builder *reverseProxyBuilder) ReplaceRequestHeaderIf(name string, match string, replace string, condition RequestCondition) ReverseProxyBuilder {
-	builder.requestRewrites = append(builder.requestRewrites, func(request *http.Request) {
+	re := builder.regex("ReplaceRequestHeader", match)
+	if re == nil {
+		return builder
+	}
+	builder.RequestRewrite(func(request *http.Request) {
 		if !condition(request) {
 			return
 		}
-		re := regexp.MustCompile(match)
 		currentValue := request.Header.Get(name)
 		newValue := re.ReplaceAllString(currentValue, replace)
 		request.Header.Set(name, newValue)
@@ -398,11 +840,14 @@ func (builder *reverseProxyBuilder) ReplaceRequestHeaderValue(name string, match
 }
 
 func (builder *reverseProxyBuilder) ReplaceRequestHeaderValueIf(name string, match string, replace string, condition RequestCondition) ReverseProxyBuilder {
-	builder.requestRewrites = append(builder.requestRewrites, func(request *http.Request) {
+	re := builder.regex("ReplaceRequestHeaderValue", match)
+	if re == nil {
+		return builder
+	}
+	builder.RequestRewrite(func(request *http.Request) {
 		if !condition(request) {
 			return
 		}
-		re := regexp.MustCompile(match)
 		currentValue := request.Header.Get(name)
 		segments := strings.Split(currentValue, ",")
 		newSegments := []string{}
@@ -420,39 +865,153 @@ func (builder *reverseProxyBuilder) ReplaceRequestBody(match, replace string) Re
 }
 
 func (builder *reverseProxyBuilder) ReplaceRequestBodyIf(match, replace string, condition RequestCondition) ReverseProxyBuilder {
+	regex := builder.regex("ReplaceRequestBody", match)
+	if regex == nil {
+		return builder
+	}
 	return builder.RequestRewrite(func(request *http.Request) {
 		if !condition(request) {
 			return
 		}
+		rewriteRequestBody(request, streamRewrite(newRegexMatcher(regex, replace)), builder.body)
+	})
+}
 
-		regex := regexp.MustCompile(match)
-		bodyBytes, _ := ioutil.ReadAll(request.Body)
-		bodyString := string(bodyBytes)
-		bodyString = regex.ReplaceAllString(bodyString, replace)
-		bodyBytes = []byte(bodyString)
-		request.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
+func (builder *reverseProxyBuilder) ReplaceRequestFormField(name, match, replace string) ReverseProxyBuilder {
+	return builder.ReplaceRequestFormFieldIf(name, match, replace, allRequests)
+}
 
-		request.ContentLength = int64(len(bodyBytes))
-		request.Header.Set("Content-Length", strconv.Itoa(len(bodyBytes)))
-		request.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
+// ReplaceRequestFormFieldIf replaces the matches of the regular expression in the decoded value of the named
+// field of url encoded forms and in the named text parts of multipart forms
+func (builder *reverseProxyBuilder) ReplaceRequestFormFieldIf(name, match, replace string, condition RequestCondition) ReverseProxyBuilder {
+	regex := builder.regex("ReplaceRequestFormField", match)
+	if regex == nil {
+		return builder
+	}
+	return builder.RequestRewrite(func(request *http.Request) {
+		if !condition(request) {
+			return
+		}
+		rewriteFormBody(request, func(field string, value string) string {
+			if field != name {
+				return value
+			}
+			return regex.ReplaceAllString(value, replace)
+		}, builder.body)
 	})
 }
 
 func (builder *reverseProxyBuilder) ResponseRewrite(rewrite ResponseRewrite) ReverseProxyBuilder {
+	return builder.ResponseRewriteFunc(rewrite.Func())
+}
+
+// ResponseRewriteFunc adds a rewrite that can fail, the rewrites after a failed one do not run and the response
+// is replaced by the answer of the error handler
+func (builder *reverseProxyBuilder) ResponseRewriteFunc(rewrite ResponseRewriteFunc) ReverseProxyBuilder {
 	builder.responseRewrites = append(builder.responseRewrites, rewrite)
 	return builder
 }
 
+func (builder *reverseProxyBuilder) AddResponseHeader(name string, value string) ReverseProxyBuilder {
+	return builder.AddResponseHeaderIf(name, value, allResponses)
+}
+
+func (builder *reverseProxyBuilder) AddResponseHeaderIf(name string, value string, condition ResponseCondition) ReverseProxyBuilder {
+	return builder.ResponseRewrite(func(response *http.Response) {
+		if condition(response) {
+			response.Header.Add(name, value)
+		}
+	})
+}
+
+func (builder *reverseProxyBuilder) SetResponseHeader(name string, value string) ReverseProxyBuilder {
+	return builder.SetResponseHeaderIf(name, value, allResponses)
+}
+
+func (builder *reverseProxyBuilder) SetResponseHeaderIf(name string, value string, condition ResponseCondition) ReverseProxyBuilder {
+	return builder.ResponseRewrite(func(response *http.Response) {
+		if condition(response) {
+			response.Header.Set(name, value)
+		}
+	})
+}
+
+func (builder *reverseProxyBuilder) CopyResponseHeader(source string, destination string) ReverseProxyBuilder {
+	return builder.CopyResponseHeaderIf(source, destination, allResponses)
+}
+
+// CopyResponseHeaderIf replaces the values of the destination header with the values of the source header,
+// nothing is copied when the source header is missing
+func (builder *reverseProxyBuilder) CopyResponseHeaderIf(source string, destination string, condition ResponseCondition) ReverseProxyBuilder {
+	return builder.ResponseRewrite(func(response *http.Response) {
+		if !condition(response) {
+			return
+		}
+		values := response.Header.Values(source)
+		if len(values) == 0 {
+			return
+		}
+		response.Header[http.CanonicalHeaderKey(destination)] = append([]string{}, values...)
+	})
+}
+
+func (builder *reverseProxyBuilder) DeleteResponseHeader(name string) ReverseProxyBuilder {
+	return builder.DeleteResponseHeaderIf(name, allResponses)
+}
+
+func (builder *reverseProxyBuilder) DeleteResponseHeaderIf(name string, condition ResponseCondition) ReverseProxyBuilder {
+	return builder.ResponseRewrite(func(response *http.Response) {
+		if condition(response) {
+			response.Header.Del(name)
+		}
+	})
+}
+
 func (builder *reverseProxyBuilder) ReplaceResponseHeader(name, match, replace string) ReverseProxyBuilder {
 	return builder.ReplaceResponseHeaderIf(name, match, replace, allResponses)
 }
 
+// ReplaceResponseHeaderIf replaces the matches of the regular expression in every value of the header, like
+// each Set-Cookie line. Missing headers are not added
 func (builder *reverseProxyBuilder) ReplaceResponseHeaderIf(name, match, replace string, condition ResponseCondition) ReverseProxyBuilder {
+	re := builder.regex("ReplaceResponseHeader", match)
+	if re == nil {
+		return builder
+	}
 	return builder.ResponseRewrite(func(response *http.Response) {
 		if !condition(response) {
 			return
 		}
+		values := response.Header[http.CanonicalHeaderKey(name)]
+		for i, value := range values {
+			values[i] = re.ReplaceAllString(value, replace)
+		}
+	})
+}
 
+func (builder *reverseProxyBuilder) ReplaceResponseHeaderValue(name, match, replace string) ReverseProxyBuilder {
+	return builder.ReplaceResponseHeaderValueIf(name, match, replace, allResponses)
+}
+
+// ReplaceResponseHeaderValueIf replaces the matches of the regular expression in each comma separated element
+// of the header values, like the methods of an Allow header
+func (builder *reverseProxyBuilder) ReplaceResponseHeaderValueIf(name, match, replace string, condition ResponseCondition) ReverseProxyBuilder {
+	re := builder.regex("ReplaceResponseHeaderValue", match)
+	if re == nil {
+		return builder
+	}
+	return builder.ResponseRewrite(func(response *http.Response) {
+		if !condition(response) {
+			return
+		}
+		values := response.Header[http.CanonicalHeaderKey(name)]
+		for i, value := range values {
+			segments := strings.Split(value, ",")
+			for j, segment := range segments {
+				segments[j] = re.ReplaceAllString(segment, replace)
+			}
+			values[i] = strings.Join(segments, ",")
+		}
 	})
 }
 
@@ -461,24 +1020,106 @@ func (builder *reverseProxyBuilder) ReplaceResponseBody(match, replace string) R
 }
 
 func (builder *reverseProxyBuilder) ReplaceResponseBodyIf(match, replace string, condition ResponseCondition) ReverseProxyBuilder {
+	regex := builder.regex("ReplaceResponseBody", match)
+	if regex == nil {
+		return builder
+	}
 	builder.ResponseRewrite(func(response *http.Response) {
 		if !condition(response) {
 			return
 		}
-		regex := regexp.MustCompile(match)
-		bodyBytes, _ := ioutil.ReadAll(response.Body)
-		bodyString := string(bodyBytes)
-		bodyString = regex.ReplaceAllString(bodyString, replace)
-		bodyBytes = []byte(bodyString)
-		response.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
+		rewriteResponseBody(response, streamRewrite(newRegexMatcher(regex, replace)), builder.body)
 	})
 	return builder
 }
 
+// RewritePath maps the path below the path prefix of the route before RewriteHost joins it to the path of the
+// backend. The replacement can use the groups of the regular expression like $1 and add a query, for example
+// ^/users/(\d+)/profile$ to /api/v2/profile?id=$1. The first matching rewrite applies. Patterns anchored with ^
+// and $ that contain only literal text and groups are mapped back in redirects, cookie paths and the urls found by
+// the html, css, javascript, json and wsdl rewrites
+func (builder *reverseProxyBuilder) RewritePath(match string, replace string) ReverseProxyBuilder {
+	regex := builder.regex("RewritePath", match)
+	if regex == nil {
+		return builder
+	}
+	builder.paths = append(builder.paths, newPathRewrite(regex, replace))
+	return builder
+}
+
+// urlMapper maps the backend urls found in the response with the path rewrites of the builder
+func (builder *reverseProxyBuilder) urlMapper(pool BackendPool, pathPrefix string, response *http.Response) *urlMapper {
+	mapper := newURLMapper(pool, pathPrefix, response)
+	mapper.paths = builder.paths
+	return mapper
+}
+
+// Mirror copies the percentage of requests to the shadow url once the request rewrites have run. Shadow
+// requests are sent in the background once the primary request has sent its body and their responses are
+// discarded, requests with a body larger than maxBodyBytes are not mirrored
+func (builder *reverseProxyBuilder) Mirror(shadowURL *url.URL, percentage float64, maxBodyBytes int64) ReverseProxyBuilder {
+	if shadowURL == nil || shadowURL.Host == "" {
+		builder.errorf("Mirror", "the shadow url must be an absolute url")
+		return builder
+	}
+	builder.mirrors = append(builder.mirrors, newMirror(shadowURL, percentage, maxBodyBytes))
+	return builder
+}
+
+// RequestBodyLimit sets the largest request body the body rewrites buffer, larger bodies are rejected with
+// 413 Request Entity Too Large unless passthrough is set, then they are sent to the backend without rewrites
+func (builder *reverseProxyBuilder) RequestBodyLimit(maxBytes int64, passthrough bool) ReverseProxyBuilder {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxRequestBodyBytes
+	}
+	builder.body.limit = requestBodyLimit{maxBytes: maxBytes, passthrough: passthrough}
+	return builder
+}
+
+// RewriteContentTypes sets the media types the body rewrites change, bodies of other types like images or
+// archives are sent unchanged. A * matches any subtype, for example text/* or application/*+json. Form bodies
+// are rewritten by default, once the list is set they are only rewritten when it allows them
+func (builder *reverseProxyBuilder) RewriteContentTypes(types ...string) ReverseProxyBuilder {
+	builder.body.types = contentTypes(types)
+	builder.body.forms = contentTypes(types)
+	return builder
+}
+
+// RewrittenBodyEncoding sets whether compressed bodies are compressed again once they are rewritten or sent
+// uncompressed
+func (builder *reverseProxyBuilder) RewrittenBodyEncoding(encoding BodyEncoding) ReverseProxyBuilder {
+	builder.body.encoding = encoding
+	return builder
+}
+
+// ErrorHandler sets the handler that answers requests whose rewrites or backend failed, every failure is logged
+// before the handler runs
+func (builder *reverseProxyBuilder) ErrorHandler(handler ErrorHandler) ReverseProxyBuilder {
+	builder.errorHandler = handler
+	return builder
+}
+
+// setRequestBody replaces the buffered request body, GetBody lets the body be replayed when the request is retried
+func setRequestBody(request *http.Request, bodyBytes []byte) {
+	request.ContentLength = int64(len(bodyBytes))
+	request.TransferEncoding = nil
+	request.Header.Set("Content-Length", strconv.Itoa(len(bodyBytes)))
+	request.Body = ioutil.NopCloser(bytes.NewReader(bodyBytes))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(bodyBytes)), nil
+	}
+}
+
 // NewReverseProxyBuilder creates a reverse proxy builder that performs common rewrite functions with simple interfaces
 func NewReverseProxyBuilder() ReverseProxyBuilder {
 	return &reverseProxyBuilder{
-		requestRewrites:  []RequestRewrite{},
-		responseRewrites: []ResponseRewrite{},
+		requestRewrites:  []RequestRewriteFunc{},
+		responseRewrites: []ResponseRewriteFunc{},
+		errorHandler:     handleError,
+		body: bodyOptions{
+			limit: requestBodyLimit{maxBytes: DefaultMaxRequestBodyBytes},
+			types: contentTypes(DefaultRewriteContentTypes),
+			forms: defaultFormContentTypes,
+		},
 	}
 }
@@ -0,0 +1,193 @@
+package proxies
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// RouteConfiguration configures the rewrite chain of a route for the given forwarded url and path prefix
+type RouteConfiguration func(builder ReverseProxyBuilder, forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder
+
+// DefaultRouteConfiguration applies the host, cookie, body and redirect rewrites for the route
+func DefaultRouteConfiguration(builder ReverseProxyBuilder, forwardedURL *url.URL, pathPrefix string) ReverseProxyBuilder {
+	return builder.
+		RewriteHost(forwardedURL, pathPrefix).
+		RewriteRequestCookies(forwardedURL, pathPrefix).
+		RewriteRequestBody(forwardedURL, pathPrefix).
+		RewriteRedirect(forwardedURL, pathPrefix).
+		RewriteResponseBody(forwardedURL, pathPrefix).
+		RewriteResponseCookies(forwardedURL, pathPrefix)
+}
+
+// DefaultPoolRouteConfiguration applies the host, cookie, body and redirect rewrites for a route to a backend pool
+func DefaultPoolRouteConfiguration(builder ReverseProxyBuilder, pool BackendPool, pathPrefix string) ReverseProxyBuilder {
+	return builder.
+		RewriteHostPool(pool, pathPrefix).
+		RewriteRequestCookiesPool(pool, pathPrefix).
+		RewriteRequestBodyPool(pool, pathPrefix).
+		RewriteRedirectPool(pool, pathPrefix).
+		RewriteResponseBodyPool(pool, pathPrefix).
+		RewriteResponseCookiesPool(pool, pathPrefix)
+}
+
+// RouterBuilder provides a builder interface for creating a router that selects a backend by path prefix
+type RouterBuilder interface {
+	Route(pathPrefix string, forwardedURL *url.URL) RouterBuilder
+	RouteWith(pathPrefix string, forwardedURL *url.URL, configure RouteConfiguration) RouterBuilder
+	Handle(pathPrefix string, handler http.Handler) RouterBuilder
+	NotFound(handler http.Handler) RouterBuilder
+	ToRouter(transport http.RoundTripper) http.Handler
+	Transport(transport http.RoundTripper) RouterBuilder
+	Build() (http.Handler, error)
+}
+
+type routeEntry struct {
+	pathPrefix   string
+	forwardedURL *url.URL
+	configure    RouteConfiguration
+	handler      http.Handler
+}
+
+type routerBuilder struct {
+	routes    []*routeEntry
+	notFound  http.Handler
+	transport http.RoundTripper
+}
+
+type route struct {
+	pathPrefix string
+	handler    http.Handler
+}
+
+type router struct {
+	routes   []*route
+	notFound http.Handler
+}
+
+func (builder *routerBuilder) Route(pathPrefix string, forwardedURL *url.URL) RouterBuilder {
+	return builder.RouteWith(pathPrefix, forwardedURL, DefaultRouteConfiguration)
+}
+
+func (builder *routerBuilder) RouteWith(pathPrefix string, forwardedURL *url.URL, configure RouteConfiguration) RouterBuilder {
+	if configure == nil {
+		configure = DefaultRouteConfiguration
+	}
+	builder.routes = append(builder.routes, &routeEntry{
+		pathPrefix:   pathPrefix,
+		forwardedURL: forwardedURL,
+		configure:    configure,
+	})
+	return builder
+}
+
+func (builder *routerBuilder) Handle(pathPrefix string, handler http.Handler) RouterBuilder {
+	builder.routes = append(builder.routes, &routeEntry{
+		pathPrefix: pathPrefix,
+		handler:    handler,
+	})
+	return builder
+}
+
+func (builder *routerBuilder) NotFound(handler http.Handler) RouterBuilder {
+	builder.notFound = handler
+	return builder
+}
+
+// Transport sets the round tripper the routes of the router returned by Build send requests with
+func (builder *routerBuilder) Transport(transport http.RoundTripper) RouterBuilder {
+	builder.transport = transport
+	return builder
+}
+
+// Build returns the router, or the errors of every route whose configuration called a builder method with an
+// invalid argument
+func (builder *routerBuilder) Build() (http.Handler, error) {
+	return builder.build(builder.transport)
+}
+
+// ToRouter creates the router, it panics when the configuration of a route is invalid. Use Build to get the
+// errors instead
+func (builder *routerBuilder) ToRouter(transport http.RoundTripper) http.Handler {
+	handler, err := builder.build(transport)
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+func (builder *routerBuilder) build(transport http.RoundTripper) (http.Handler, error) {
+	routes := []*route{}
+	errs := BuildErrors{}
+	for _, entry := range builder.routes {
+		handler := entry.handler
+		if handler == nil {
+			var err error
+			handler, err = entry.configure(NewReverseProxyBuilder(), entry.forwardedURL, entry.pathPrefix).
+				Transport(transport).
+				Build()
+			if err != nil {
+				errs = errs.add("route '"+entry.pathPrefix+"'", err)
+				continue
+			}
+		}
+		routes = append(routes, &route{
+			pathPrefix: normalizePathPrefix(entry.pathPrefix),
+			handler:    handler,
+		})
+	}
+
+	// the longest prefix wins, so order the routes from longest to shortest
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].pathPrefix) > len(routes[j].pathPrefix)
+	})
+
+	notFound := builder.notFound
+	if notFound == nil {
+		notFound = http.NotFoundHandler()
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return &router{
+		routes:   routes,
+		notFound: notFound,
+	}, nil
+}
+
+func (r *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, route := range r.routes {
+		if MatchesPathPrefix(req.URL.Path, route.pathPrefix) {
+			route.handler.ServeHTTP(w, req)
+			return
+		}
+	}
+	r.notFound.ServeHTTP(w, req)
+}
+
+// MatchesPathPrefix returns true if the path is equal to the prefix or is below the prefix on a segment boundary
+func MatchesPathPrefix(path string, pathPrefix string) bool {
+	pathPrefix = normalizePathPrefix(pathPrefix)
+	if pathPrefix == "" {
+		return true
+	}
+	return path == pathPrefix || strings.HasPrefix(path, pathPrefix+"/")
+}
+
+func normalizePathPrefix(pathPrefix string) string {
+	pathPrefix = strings.TrimSpace(pathPrefix)
+	pathPrefix = strings.TrimSuffix(pathPrefix, "/")
+	if pathPrefix != "" && !strings.HasPrefix(pathPrefix, "/") {
+		pathPrefix = "/" + pathPrefix
+	}
+	return pathPrefix
+}
+
+// NewRouterBuilder creates a router builder that maps path prefixes to reverse proxies
+func NewRouterBuilder() RouterBuilder {
+	return &routerBuilder{
+		routes: []*routeEntry{},
+	}
+}
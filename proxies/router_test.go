@@ -0,0 +1,103 @@
+package proxies_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	"github.com/gorilla/mux"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Router", func() {
+	var (
+		one      *httptest.Server
+		two      *httptest.Server
+		frontend *httptest.Server
+	)
+	newBackend := func(name string) *httptest.Server {
+		router := mux.NewRouter()
+		router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s", name, r.URL.Path)
+		})
+		return httptest.NewServer(router)
+	}
+	get := func(path string) (int, string) {
+		res, err := http.Get(frontend.URL + path)
+		Expect(err).To(BeNil())
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).To(BeNil())
+		return res.StatusCode, string(body)
+	}
+	BeforeEach(func() {
+		one = newBackend("one")
+		two = newBackend("two")
+
+		oneURL, err := url.Parse(one.URL)
+		Expect(err).To(BeNil())
+
+		twoURL, err := url.Parse(two.URL)
+		Expect(err).To(BeNil())
+
+		router := proxies.NewRouterBuilder().
+			Route("/app", oneURL).
+			Route("/app/api", twoURL).
+			NotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, "no route")
+			})).
+			ToRouter(&http.Transport{})
+		frontend = httptest.NewServer(router)
+	})
+	AfterEach(func() {
+		one.Close()
+		two.Close()
+		frontend.Close()
+	})
+	It("routes to the matching prefix", func() {
+		status, body := get("/app/ok")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("one /ok"))
+	})
+	It("routes to the longest matching prefix", func() {
+		status, body := get("/app/api/users")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("two /users"))
+	})
+	It("matches prefixes on segment boundaries", func() {
+		status, body := get("/application")
+		Expect(status).To(Equal(http.StatusNotFound))
+		Expect(body).To(Equal("no route"))
+	})
+	It("returns not found for unmatched requests", func() {
+		status, body := get("/other")
+		Expect(status).To(Equal(http.StatusNotFound))
+		Expect(body).To(Equal("no route"))
+	})
+	It("returns the errors of invalid routes from build", func() {
+		oneURL, _ := url.Parse(one.URL)
+		invalid := func(builder proxies.ReverseProxyBuilder, forwardedURL *url.URL, pathPrefix string) proxies.ReverseProxyBuilder {
+			return builder.ReplaceRequestHeader("X-Name", "(", "")
+		}
+		builder := proxies.NewRouterBuilder().
+			Route("/app", oneURL).
+			RouteWith("/broken", oneURL, invalid)
+
+		handler, err := builder.Build()
+		Expect(handler).To(BeNil())
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("route '/broken': ReplaceRequestHeader: invalid regular expression '('"))
+		Expect(func() { builder.ToRouter(nil) }).To(Panic())
+
+		handler, err = proxies.NewRouterBuilder().Route("/app", oneURL).Transport(&http.Transport{}).Build()
+		Expect(err).To(BeNil())
+		Expect(handler).ToNot(BeNil())
+	})
+})
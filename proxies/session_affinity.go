@@ -0,0 +1,179 @@
+package proxies
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultAffinityCookieName is the name of the affinity cookie when no name is configured
+const DefaultAffinityCookieName = "proxy-affinity"
+
+// HashKey extracts the value a request is hashed on
+type HashKey func(request *http.Request) string
+
+// HeaderHashKey hashes requests on the value of the header
+func HeaderHashKey(name string) HashKey {
+	return func(request *http.Request) string {
+		return request.Header.Get(name)
+	}
+}
+
+// CookieHashKey hashes requests on the value of the cookie
+func CookieHashKey(name string) HashKey {
+	return func(request *http.Request) string {
+		cookie, err := request.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+}
+
+// ClientIPHashKey hashes requests on the address of the connected client, forwarded headers are ignored
+// because clients can set them to anything
+func ClientIPHashKey() HashKey {
+	return func(request *http.Request) string {
+		host, _, err := net.SplitHostPort(request.RemoteAddr)
+		if err != nil {
+			return request.RemoteAddr
+		}
+		return host
+	}
+}
+
+type consistentHashStrategy struct {
+	key  HashKey
+	next uint64
+}
+
+// Select uses weighted rendezvous hashing so only the keys of an added or removed backend move, requests
+// without a key are selected in round robin order
+func (s *consistentHashStrategy) Select(backends []Backend, request *http.Request) Backend {
+	key := ""
+	if request != nil {
+		key = s.key(request)
+	}
+	if key == "" {
+		next := atomic.AddUint64(&s.next, 1) - 1
+		return backends[next%uint64(len(backends))]
+	}
+
+	keyHash := hashString(key)
+	var selected Backend
+	best := math.Inf(-1)
+	for _, b := range backends {
+		if b.Weight() <= 0 {
+			continue
+		}
+		score := rendezvousScore(keyHash, hashString(b.URL().String()), b.Weight())
+		if selected == nil || score > best {
+			selected = b
+			best = score
+		}
+	}
+	if selected == nil {
+		return backends[0]
+	}
+	return selected
+}
+
+func hashString(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	return h.Sum64()
+}
+
+// rendezvousScore combines the hashes into a uniform value and weights it so heavier backends win
+// proportionally more keys
+func rendezvousScore(keyHash uint64, backendHash uint64, weight int) float64 {
+	// splitmix64 finalizer, fnv alone does not spread similar keys well
+	h := keyHash ^ (backendHash * 0x9e3779b97f4a7c15)
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	h = h ^ (h >> 31)
+
+	uniform := (float64(h>>11) + 0.5) / (1 << 53)
+	return -float64(weight) / math.Log(uniform)
+}
+
+// AffinityCookie describes the cookie the proxy issues to pin a client to a pool member
+type AffinityCookie struct {
+	Name     string
+	Path     string
+	MaxAge   time.Duration
+	Secure   bool
+	SameSite http.SameSite
+}
+
+type affinityPool struct {
+	BackendPool
+	cookie AffinityCookie
+}
+
+// Select returns the backend named by the affinity cookie while it is healthy
+func (pool *affinityPool) Select(request *http.Request) Backend {
+	if cookie, err := request.Cookie(pool.cookie.Name); err == nil {
+		for _, b := range pool.Backends() {
+			if b.Healthy() && affinityID(b) == cookie.Value {
+				return b
+			}
+		}
+	}
+	return pool.BackendPool.Select(request)
+}
+
+// issue adds the affinity cookie to the response when the client is not already pinned to the backend
+func (pool *affinityPool) issue(request *http.Request, response *http.Response, b Backend) {
+	if response.StatusCode >= http.StatusInternalServerError {
+		return
+	}
+	id := affinityID(b)
+	if cookie, err := request.Cookie(pool.cookie.Name); err == nil && cookie.Value == id {
+		return
+	}
+	cookie := &http.Cookie{
+		Name:     pool.cookie.Name,
+		Value:    id,
+		Path:     pool.cookie.Path,
+		Secure:   pool.cookie.Secure,
+		HttpOnly: true,
+		SameSite: pool.cookie.SameSite,
+	}
+	if pool.cookie.MaxAge > 0 {
+		cookie.MaxAge = int(pool.cookie.MaxAge / time.Second)
+	}
+	response.Header.Add("Set-Cookie", cookie.String())
+	if state := requestStateOf(request); state != nil {
+		state.issueCookie(pool.cookie.Name)
+	}
+}
+
+// affinityID identifies the backend without revealing its url, it is stable across restarts and replicas
+func affinityID(b Backend) string {
+	return fmt.Sprintf("%016x", hashString(b.URL().String()))
+}
+
+// NewConsistentHashStrategy creates a strategy that always selects the same backend for the same key
+func NewConsistentHashStrategy(key HashKey) SelectionStrategy {
+	return &consistentHashStrategy{key: key}
+}
+
+// NewAffinityPool wraps the pool so clients are pinned to the first backend selected for them with a cookie
+// issued by the proxy. The cookie is left alone by RewriteResponseCookies
+func NewAffinityPool(pool BackendPool, cookie AffinityCookie) BackendPool {
+	if cookie.Name == "" {
+		cookie.Name = DefaultAffinityCookieName
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	return &affinityPool{
+		BackendPool: pool,
+		cookie:      cookie,
+	}
+}
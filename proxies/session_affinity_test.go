@@ -0,0 +1,140 @@
+package proxies_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SessionAffinity", func() {
+	newBackends := func(count int) []proxies.Backend {
+		backends := []proxies.Backend{}
+		for i := 0; i < count; i++ {
+			backendURL, err := url.Parse(fmt.Sprintf("http://backend-%d", i))
+			Expect(err).To(BeNil())
+			backends = append(backends, proxies.NewBackend(backendURL, 1))
+		}
+		return backends
+	}
+	Context("consistent hashing", func() {
+		request := func(user string) *http.Request {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-User", user)
+			return req
+		}
+		It("selects the same backend for the same key", func() {
+			pool := proxies.NewBackendPool(proxies.NewConsistentHashStrategy(proxies.HeaderHashKey("X-User")), newBackends(3)...)
+			for i := 0; i < 20; i++ {
+				user := fmt.Sprintf("user-%d", i)
+				first := pool.Select(request(user))
+				Expect(pool.Select(request(user))).To(Equal(first))
+			}
+		})
+		It("only moves the keys of a removed backend", func() {
+			backends := newBackends(4)
+			strategy := proxies.NewConsistentHashStrategy(proxies.HeaderHashKey("X-User"))
+			before := proxies.NewBackendPool(strategy, backends...)
+			after := proxies.NewBackendPool(strategy, backends[:3]...)
+
+			used := map[proxies.Backend]int{}
+			for i := 0; i < 200; i++ {
+				user := fmt.Sprintf("user-%d", i)
+				selected := before.Select(request(user))
+				used[selected]++
+				if selected != backends[3] {
+					Expect(after.Select(request(user))).To(Equal(selected))
+				}
+			}
+			Expect(used).To(HaveLen(4))
+		})
+		It("hashes cookies and client addresses", func() {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+			req.RemoteAddr = "10.0.0.1:1234"
+			Expect(proxies.CookieHashKey("session")(req)).To(Equal("abc"))
+			Expect(proxies.CookieHashKey("other")(req)).To(Equal(""))
+			Expect(proxies.ClientIPHashKey()(req)).To(Equal("10.0.0.1"))
+		})
+	})
+	Context("affinity cookie", func() {
+		var (
+			servers  []*httptest.Server
+			frontend *httptest.Server
+		)
+		BeforeEach(func() {
+			backends := []proxies.Backend{}
+			for i := 0; i < 2; i++ {
+				name := fmt.Sprintf("backend-%d", i)
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					http.SetCookie(w, &http.Cookie{Name: "app", Value: name, Path: "/"})
+					fmt.Fprint(w, name)
+				}))
+				servers = append(servers, server)
+				serverURL, err := url.Parse(server.URL)
+				Expect(err).To(BeNil())
+				backends = append(backends, proxies.NewBackend(serverURL, 1))
+			}
+			pool := proxies.NewAffinityPool(proxies.NewBackendPool(nil, backends...), proxies.AffinityCookie{
+				Name: "sticky",
+				Path: "/app",
+			})
+			builder := proxies.NewReverseProxyBuilder().
+				RewriteHostPool(pool, "/app").
+				RewriteResponseCookiesPool(pool, "/app")
+			frontend = httptest.NewServer(builder.ToReverseProxy(nil))
+		})
+		AfterEach(func() {
+			frontend.Close()
+			for _, server := range servers {
+				server.Close()
+			}
+			servers = nil
+		})
+		get := func(cookies ...*http.Cookie) (string, []*http.Cookie) {
+			req, err := http.NewRequest("GET", frontend.URL+"/app/ok", nil)
+			Expect(err).To(BeNil())
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+			defer res.Body.Close()
+			body, err := ioutil.ReadAll(res.Body)
+			Expect(err).To(BeNil())
+			return string(body), res.Cookies()
+		}
+		find := func(cookies []*http.Cookie, name string) *http.Cookie {
+			for _, cookie := range cookies {
+				if cookie.Name == name {
+					return cookie
+				}
+			}
+			return nil
+		}
+		It("pins the client to the first backend", func() {
+			first, cookies := get()
+			sticky := find(cookies, "sticky")
+			Expect(sticky).ToNot(BeNil())
+			Expect(sticky.Path).To(Equal("/app"))
+			Expect(sticky.HttpOnly).To(BeTrue())
+			Expect(find(cookies, "app").Path).To(Equal("/app"))
+
+			for i := 0; i < 3; i++ {
+				body, cookies := get(&http.Cookie{Name: "sticky", Value: sticky.Value})
+				Expect(body).To(Equal(first))
+				Expect(find(cookies, "sticky")).To(BeNil())
+			}
+		})
+		It("issues a new cookie when the pinned backend is unknown", func() {
+			_, cookies := get(&http.Cookie{Name: "sticky", Value: "unknown"})
+			Expect(find(cookies, "sticky")).ToNot(BeNil())
+		})
+	})
+})
@@ -0,0 +1,361 @@
+package proxies
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+)
+
+const (
+	// DefaultStreamWindow is the longest regular expression match the streaming rewriters are guaranteed to
+	// find when it crosses a read boundary
+	DefaultStreamWindow = 4096
+	// streamChunkSize is the amount read from the source at a time
+	streamChunkSize = 32 * 1024
+	// maxStreamPending bounds the input held back while a match is incomplete
+	maxStreamPending = DefaultStreamWindow + streamChunkSize
+)
+
+// streamMatcher finds the replacements in the buffered part of a stream
+type streamMatcher interface {
+	// matches returns the submatch indexes of every match in the buffer, before is the input that precedes the
+	// buffer in the stream and is empty at the start of the stream
+	matches(before []byte, buf []byte) [][]int
+	// expand appends the replacement for the match to dst
+	expand(dst []byte, buf []byte, match []int) []byte
+	// holdBack returns how many bytes at the end of the buffer could be part of a match that continues
+	// in input that has not been read yet
+	holdBack(buf []byte) int
+}
+
+// streamRewriter replaces matches while the body is read so memory use does not depend on the body size,
+// input that could be the start of a match is held back until enough input follows it
+type streamRewriter struct {
+	source       io.ReadCloser
+	matcher      streamMatcher
+	pending      []byte
+	output       []byte
+	chunk        []byte
+	err          error
+	matchedToEnd bool
+	// before is the last byte written so anchors and word boundaries at the start of pending see it
+	before []byte
+}
+
+func (s *streamRewriter) Read(p []byte) (int, error) {
+	for len(s.output) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		s.fill()
+	}
+	n := copy(p, s.output)
+	s.output = s.output[n:]
+	return n, nil
+}
+
+func (s *streamRewriter) Close() error {
+	return s.source.Close()
+}
+
+// fill reads the next chunk of the source and rewrites the part of the pending input that is safe
+func (s *streamRewriter) fill() {
+	n, err := s.source.Read(s.chunk)
+	s.pending = append(s.pending, s.chunk[:n]...)
+	final := err != nil
+
+	safe := len(s.pending)
+	if !final {
+		safe -= s.matcher.holdBack(s.pending)
+		if safe < 0 {
+			safe = 0
+		}
+	}
+
+	matches := s.matcher.matches(s.before, s.pending)
+	committed := make([][]int, 0, len(matches))
+	for _, match := range matches {
+		// an empty match directly after a match at the end of the previous chunk was not a match in the stream
+		if match[0] == 0 && match[1] == 0 && s.matchedToEnd {
+			continue
+		}
+		if !final && (match[1] > safe || (match[0] == match[1] && match[0] == safe)) {
+			if match[0] < safe {
+				safe = match[0]
+			}
+			break
+		}
+		committed = append(committed, match)
+	}
+
+	// an incomplete match that keeps growing is given up on so memory stays bounded
+	if safe == 0 && !final && len(s.pending) >= maxStreamPending {
+		safe = len(s.pending)
+		committed = committed[:0]
+		for _, match := range matches {
+			if match[1] <= safe {
+				committed = append(committed, match)
+			}
+		}
+	}
+
+	output := s.output[:0]
+	last := 0
+	s.matchedToEnd = false
+	for _, match := range committed {
+		if match[1] > safe {
+			break
+		}
+		output = append(output, s.pending[last:match[0]]...)
+		output = s.matcher.expand(output, s.pending, match)
+		last = match[1]
+		s.matchedToEnd = last == safe
+	}
+	output = append(output, s.pending[last:safe]...)
+	s.output = output
+	if safe > 0 {
+		s.before = append(s.before[:0], s.pending[safe-1])
+	}
+	s.pending = append(s.pending[:0], s.pending[safe:]...)
+
+	if final {
+		s.err = err
+	}
+}
+
+// newStreamRewriter wraps the source so matches are replaced while it is read
+func newStreamRewriter(source io.ReadCloser, matcher streamMatcher) io.ReadCloser {
+	return &streamRewriter{
+		source:  source,
+		matcher: matcher,
+		chunk:   make([]byte, streamChunkSize),
+	}
+}
+
+// regexMatcher replaces regular expression matches with a template that may refer to submatches like $1
+type regexMatcher struct {
+	regex     *regexp.Regexp
+	template  []byte
+	multiline bool
+}
+
+func (m *regexMatcher) matches(before []byte, buf []byte) [][]int {
+	if len(before) == 0 {
+		return m.regex.FindAllSubmatchIndex(buf, -1)
+	}
+	// the input before the buffer is searched too so ^, \A and \b do not match at the start of the buffer
+	input := make([]byte, 0, len(before)+len(buf))
+	input = append(append(input, before...), buf...)
+	matches := [][]int{}
+	for _, match := range m.regex.FindAllSubmatchIndex(input, -1) {
+		if match[0] < len(before) {
+			continue
+		}
+		for i := range match {
+			if match[i] >= 0 {
+				match[i] -= len(before)
+			}
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+func (m *regexMatcher) expand(dst []byte, buf []byte, match []int) []byte {
+	return m.regex.Expand(dst, m.template, buf, match)
+}
+
+// holdBack keeps the window, or only the current line when the expression can not match a line break
+func (m *regexMatcher) holdBack(buf []byte) int {
+	held := DefaultStreamWindow
+	if !m.multiline {
+		if i := bytes.LastIndexByte(buf, '\n'); i >= 0 && len(buf)-(i+1) < held {
+			held = len(buf) - (i + 1)
+		}
+	}
+	if held > len(buf) {
+		held = len(buf)
+	}
+	return held
+}
+
+func newRegexMatcher(regex *regexp.Regexp, template string) streamMatcher {
+	return &regexMatcher{
+		regex:     regex,
+		template:  []byte(template),
+		multiline: matchesNewline(regex),
+	}
+}
+
+// matchesNewline reports whether a match of the expression could contain a line break
+func matchesNewline(regex *regexp.Regexp) bool {
+	re, err := syntax.Parse(regex.String(), syntax.Perl)
+	if err != nil {
+		return true
+	}
+	return syntaxMatchesNewline(re)
+}
+
+func syntaxMatchesNewline(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpAnyChar:
+		return true
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if r == '\n' {
+				return true
+			}
+		}
+		// case folded literals never fold to a line break
+		return false
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if re.Rune[i] <= '\n' && '\n' <= re.Rune[i+1] {
+				return true
+			}
+		}
+		return false
+	}
+	for _, sub := range re.Sub {
+		if syntaxMatchesNewline(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// literalMatcher replaces literal strings, the longest literal wins when several start at the same position
+type literalMatcher struct {
+	olds [][]byte
+	news [][]byte
+}
+
+func (m *literalMatcher) matches(before []byte, buf []byte) [][]int {
+	matches := [][]int{}
+	for i := 0; i < len(buf); {
+		start, index := -1, -1
+		for j, old := range m.olds {
+			k := bytes.Index(buf[i:], old)
+			if k >= 0 && (start < 0 || i+k < start) {
+				start, index = i+k, j
+			}
+		}
+		if start < 0 {
+			break
+		}
+		end := start + len(m.olds[index])
+		matches = append(matches, []int{start, end, index})
+		i = end
+	}
+	return matches
+}
+
+func (m *literalMatcher) expand(dst []byte, buf []byte, match []int) []byte {
+	return append(dst, m.news[match[2]]...)
+}
+
+// holdBack keeps the longest end of the buffer that is the start of a literal
+func (m *literalMatcher) holdBack(buf []byte) int {
+	longest := 0
+	for _, old := range m.olds {
+		if len(old) > longest {
+			longest = len(old)
+		}
+	}
+	for k := longest; k > 0; k-- {
+		if k > len(buf) {
+			continue
+		}
+		suffix := buf[len(buf)-k:]
+		for _, old := range m.olds {
+			if len(old) > k && bytes.HasPrefix(old, suffix) {
+				return k
+			}
+		}
+	}
+	return 0
+}
+
+// newLiteralMatcher creates a matcher that replaces each old string with the new string of the same index
+func newLiteralMatcher(olds []string, news []string) streamMatcher {
+	m := &literalMatcher{}
+	indexes := []int{}
+	for i, old := range olds {
+		if old != "" {
+			indexes = append(indexes, i)
+		}
+	}
+	// longer literals first so a literal that is a prefix of another does not hide it
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return len(olds[indexes[a]]) > len(olds[indexes[b]])
+	})
+	for _, i := range indexes {
+		m.olds = append(m.olds, []byte(olds[i]))
+		m.news = append(m.news, []byte(news[i]))
+	}
+	return m
+}
+
+// bodyOptions are the settings shared by the body rewrites of a builder
+type bodyOptions struct {
+	limit requestBodyLimit
+	types contentTypes
+	// forms are the form media types the form rewrites change, the allow list replaces them once it is set
+	forms    contentTypes
+	encoding BodyEncoding
+}
+
+// streamRewrite replaces the matches of the matcher while the body is read
+func streamRewrite(matcher streamMatcher) func(io.ReadCloser) io.ReadCloser {
+	return func(body io.ReadCloser) io.ReadCloser {
+		return newStreamRewriter(body, matcher)
+	}
+}
+
+// rewriteResponseBody rewrites the response body while it is read when its content type is in the allow
+// list, compressed bodies are decompressed first. The length of the rewritten body is unknown so it is sent
+// chunked
+func rewriteResponseBody(response *http.Response, rewrite func(io.ReadCloser) io.ReadCloser, options bodyOptions) {
+	if response.Body == nil || response.Body == http.NoBody {
+		return
+	}
+	coding, ok := lookupContentCoding(response.Header)
+	if !ok {
+		return
+	}
+	if coding == nil {
+		body, encode, ok := options.types.textBody(response.Header, response.Body)
+		response.Body = body
+		if !ok {
+			return
+		}
+		response.Body = encode(rewrite(body))
+		response.ContentLength = -1
+		response.Header.Del("Content-Length")
+		return
+	}
+
+	// binary bodies are not decompressed at all
+	if contentType := response.Header.Get("Content-Type"); contentType != "" {
+		if _, ok := options.types.textEncoding(contentType); !ok {
+			return
+		}
+	}
+	body, encode, ok := options.types.textBody(response.Header, coding.decode(response.Body))
+	if ok {
+		body = encode(rewrite(body))
+	}
+	if options.encoding == DecodeBody {
+		response.Header.Del("Content-Encoding")
+		response.Body = body
+	} else {
+		response.Body = coding.encode(body)
+	}
+	// the backend chose the encoding from the Accept-Encoding request header
+	addVary(response.Header, "Accept-Encoding")
+	response.ContentLength = -1
+	response.Header.Del("Content-Length")
+}
@@ -0,0 +1,226 @@
+package proxies_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// repeatReader returns the pattern over and over until size bytes have been read
+type repeatReader struct {
+	pattern []byte
+	offset  int
+	size    int64
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	if r.size <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.size {
+		p = p[:r.size]
+	}
+	n := 0
+	for n < len(p) {
+		copied := copy(p[n:], r.pattern[r.offset:])
+		n += copied
+		r.offset = (r.offset + copied) % len(r.pattern)
+	}
+	r.size -= int64(n)
+	return n, nil
+}
+
+// modify runs the response rewrites of the builder on a response with the body
+func modify(builder proxies.ReverseProxyBuilder, body io.Reader) *http.Response {
+	request := httptest.NewRequest("GET", "http://frontend/page", nil)
+	response := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Length": []string{"100"}, "Content-Type": []string{"text/plain"}},
+		ContentLength: 100,
+		Body:          ioutil.NopCloser(body),
+		Request:       request,
+	}
+	Expect(builder.ToReverseProxy(nil).ModifyResponse(response)).To(Succeed())
+	return response
+}
+
+func readAll(response *http.Response) string {
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(response.Body)
+	Expect(err).To(BeNil())
+	return string(content)
+}
+
+var _ = Describe("StreamRewriter", func() {
+	backendURL, _ := url.Parse("http://backend:8080")
+	It("replaces urls that cross read boundaries", func() {
+		body := "<a href=\"http://backend:8080/a\">http://backend:8080</a> http://backend:80"
+		response := modify(proxies.NewReverseProxyBuilder().RewriteResponseBody(backendURL, "/"),
+			iotest.OneByteReader(strings.NewReader(body)))
+		Expect(readAll(response)).To(Equal("<a href=\"http://frontend/a\">http://frontend</a> http://backend:80"))
+	})
+	It("sends the rewritten body chunked", func() {
+		response := modify(proxies.NewReverseProxyBuilder().ReplaceResponseBody("a", "bb"), strings.NewReader("aaa"))
+		Expect(response.ContentLength).To(Equal(int64(-1)))
+		Expect(response.Header.Get("Content-Length")).To(BeEmpty())
+		Expect(readAll(response)).To(Equal("bbbbbb"))
+	})
+	It("expands submatches of matches that cross read boundaries", func() {
+		response := modify(proxies.NewReverseProxyBuilder().ReplaceResponseBody(`id=(\d+);`, "id=[$1];"),
+			iotest.OneByteReader(strings.NewReader("id=12345; id=6; id=7")))
+		Expect(readAll(response)).To(Equal("id=[12345]; id=[6]; id=7"))
+	})
+	It("does not match empty strings twice at a read boundary", func() {
+		response := modify(proxies.NewReverseProxyBuilder().ReplaceResponseBody(`x*`, "-"),
+			iotest.OneByteReader(strings.NewReader("axxb")))
+		Expect(readAll(response)).To(Equal(regexp.MustCompile(`x*`).ReplaceAllString("axxb", "-")))
+	})
+	It("matches anchors and word boundaries at read boundaries like in the whole body", func() {
+		bodies := []string{
+			strings.Repeat("b", 40000) + strings.Repeat("a", 40000),
+			strings.Repeat("b", 32767) + " " + strings.Repeat("a ", 20000),
+			strings.Repeat("b\n", 20000) + strings.Repeat("a", 40000),
+		}
+		for _, pattern := range []string{`^a`, `\Aa`, `\ba`, `\Ba`, `(?m)^a`} {
+			for _, body := range bodies {
+				response := modify(proxies.NewReverseProxyBuilder().ReplaceResponseBody(pattern, "X"), strings.NewReader(body))
+				Expect(readAll(response) == regexp.MustCompile(pattern).ReplaceAllString(body, "X")).To(BeTrue(), pattern)
+			}
+		}
+	})
+	It("writes complete lines before the body ends", func() {
+		reader, writer := io.Pipe()
+		response := modify(proxies.NewReverseProxyBuilder().ReplaceResponseBody("backend", "frontend"), reader)
+		go func() {
+			writer.Write([]byte("data: backend\n"))
+		}()
+
+		line := make(chan string)
+		go func() {
+			defer GinkgoRecover()
+			buffer := make([]byte, 64)
+			n, err := response.Body.Read(buffer)
+			Expect(err).To(BeNil())
+			line <- string(buffer[:n])
+		}()
+		Eventually(line, time.Second).Should(Receive(Equal("data: frontend\n")))
+		writer.Close()
+	})
+	It("proxies rewritten responses with chunked transfer encoding", func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "11")
+			w.Write([]byte("hello world"))
+		}))
+		defer backend.Close()
+		target, err := url.Parse(backend.URL)
+		Expect(err).To(BeNil())
+		frontend := httptest.NewServer(proxies.NewReverseProxyBuilder().
+			RewriteHost(target, "/").
+			ReplaceResponseBody("world", "everyone").
+			ToReverseProxy(nil))
+		defer frontend.Close()
+
+		res, err := http.Get(frontend.URL)
+		Expect(err).To(BeNil())
+		Expect(res.TransferEncoding).To(Equal([]string{"chunked"}))
+		Expect(res.ContentLength).To(Equal(int64(-1)))
+		Expect(readAll(res)).To(Equal("hello everyone"))
+	})
+	It("keeps memory flat for large bodies", func() {
+		pattern := "see http://backend:8080/path for details\n"
+		const size = 64 << 20
+		repeats := size / len(pattern)
+		response := modify(proxies.NewReverseProxyBuilder().RewriteResponseBody(backendURL, "/"),
+			&repeatReader{pattern: []byte(pattern), size: int64(repeats * len(pattern))})
+		defer response.Body.Close()
+
+		runtime.GC()
+		var before, during runtime.MemStats
+		runtime.ReadMemStats(&before)
+
+		buffer := make([]byte, 32*1024)
+		total := 0
+		var peak uint64
+		for i := 0; ; i++ {
+			n, err := response.Body.Read(buffer)
+			total += n
+			if i%256 == 0 {
+				runtime.ReadMemStats(&during)
+				if during.HeapInuse > peak {
+					peak = during.HeapInuse
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			Expect(err).To(BeNil())
+		}
+		Expect(total).To(Equal(repeats * len("see http://frontend/path for details\n")))
+		Expect(peak).To(BeNumerically("<", before.HeapInuse+16<<20))
+	})
+})
+
+func benchmarkResponseRewrite(b *testing.B, builder proxies.ReverseProxyBuilder, pattern string) {
+	const size = 256 << 20
+	proxy := builder.ToReverseProxy(nil)
+	request := httptest.NewRequest("GET", "http://frontend/page", nil)
+	buffer := make([]byte, 32*1024)
+
+	b.SetBytes(size)
+	b.ReportAllocs()
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	var peak uint64
+	for i := 0; i < b.N; i++ {
+		response := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/plain"}},
+			Body:       ioutil.NopCloser(&repeatReader{pattern: []byte(pattern), size: size}),
+			Request:    request,
+		}
+		proxy.ModifyResponse(response)
+		for j := 0; ; j++ {
+			_, err := response.Body.Read(buffer)
+			if j%1024 == 0 {
+				runtime.ReadMemStats(&after)
+				if after.HeapInuse > peak {
+					peak = after.HeapInuse
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		response.Body.Close()
+	}
+	growth := float64(0)
+	if peak > before.HeapInuse {
+		growth = float64(peak - before.HeapInuse)
+	}
+	b.ReportMetric(growth, "heap-growth-bytes")
+}
+
+func BenchmarkRewriteResponseBody(b *testing.B) {
+	backendURL, _ := url.Parse("http://backend:8080")
+	benchmarkResponseRewrite(b, proxies.NewReverseProxyBuilder().RewriteResponseBody(backendURL, "/"),
+		"see http://backend:8080/path for details\n")
+}
+
+func BenchmarkReplaceResponseBody(b *testing.B) {
+	benchmarkResponseRewrite(b, proxies.NewReverseProxyBuilder().ReplaceResponseBody(`id=(\d+)`, "id=[$1]"),
+		"id=12345 "+strings.Repeat("x", 100)+"\n")
+}
@@ -0,0 +1,269 @@
+package proxies
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// DefaultVariantHeader is the response header that names the variant that served a request
+const DefaultVariantHeader = "X-Variant"
+
+// TrafficSplitBuilder provides a builder interface for splitting the traffic of a route between variants,
+// for example a stable and a canary release, that each have their own handler
+type TrafficSplitBuilder interface {
+	Variant(name string, weight int, handler http.Handler) TrafficSplitBuilder
+	VariantIf(name string, weight int, condition RequestCondition, handler http.Handler) TrafficSplitBuilder
+	Header(name string) TrafficSplitBuilder
+	ToTrafficSplit() TrafficSplit
+}
+
+// TrafficSplit sends requests that match the condition of a variant to that variant and spreads the rest
+// between the variants in proportion to their weights. The weights can be changed while serving
+type TrafficSplit interface {
+	http.Handler
+	Name() string
+	Weights() map[string]int
+	SetWeights(weights map[string]int) error
+}
+
+type variant struct {
+	name      string
+	weight    int
+	condition RequestCondition
+	handler   http.Handler
+}
+
+type trafficSplitBuilder struct {
+	name     string
+	header   string
+	variants []*variant
+}
+
+type trafficSplit struct {
+	name     string
+	header   string
+	variants []*variant
+	lock     sync.RWMutex
+	weights  map[string]int
+}
+
+func (builder *trafficSplitBuilder) Variant(name string, weight int, handler http.Handler) TrafficSplitBuilder {
+	return builder.VariantIf(name, weight, nil, handler)
+}
+
+func (builder *trafficSplitBuilder) VariantIf(name string, weight int, condition RequestCondition, handler http.Handler) TrafficSplitBuilder {
+	builder.variants = append(builder.variants, &variant{
+		name:      name,
+		weight:    weight,
+		condition: condition,
+		handler:   handler,
+	})
+	return builder
+}
+
+func (builder *trafficSplitBuilder) Header(name string) TrafficSplitBuilder {
+	builder.header = name
+	return builder
+}
+
+func (builder *trafficSplitBuilder) ToTrafficSplit() TrafficSplit {
+	weights := map[string]int{}
+	for _, v := range builder.variants {
+		weights[v.name] = v.weight
+	}
+	return &trafficSplit{
+		name:     builder.name,
+		header:   builder.header,
+		variants: builder.variants,
+		weights:  weights,
+	}
+}
+
+func (split *trafficSplit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v := split.choose(r)
+	if v == nil {
+		http.NotFound(w, r)
+		return
+	}
+	log.Printf("%s %s%s served by variant '%s' of '%s'", r.Method, r.Host, r.URL.Path, v.name, split.name)
+	if split.header != "" {
+		w = &variantWriter{ResponseWriter: w, name: split.header, value: v.name}
+	}
+	v.handler.ServeHTTP(w, r)
+}
+
+// variantWriter sets the variant header when the handler writes the response header, after a reverse proxy
+// copied the headers of the backend, so it replaces a header of the same name sent by the backend
+type variantWriter struct {
+	http.ResponseWriter
+	name    string
+	value   string
+	written bool
+}
+
+func (w *variantWriter) setHeader() {
+	if !w.written {
+		w.written = true
+		w.Header().Set(w.name, w.value)
+	}
+}
+
+func (w *variantWriter) WriteHeader(status int) {
+	w.setHeader()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *variantWriter) Write(p []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *variantWriter) Flush() {
+	w.setHeader()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection of an upgraded request to the handler
+func (w *variantWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the response writer of variant '%s' can not be hijacked", w.value)
+	}
+	return hijacker.Hijack()
+}
+
+// choose returns the first variant whose condition matches, otherwise a variant picked by weight
+func (split *trafficSplit) choose(r *http.Request) *variant {
+	for _, v := range split.variants {
+		if v.condition != nil && v.condition(r) {
+			return v
+		}
+	}
+
+	split.lock.RLock()
+	defer split.lock.RUnlock()
+	total := 0
+	for _, v := range split.variants {
+		total += split.weights[v.name]
+	}
+	if total <= 0 {
+		if len(split.variants) == 0 {
+			return nil
+		}
+		return split.variants[0]
+	}
+
+	pick := rand.Intn(total)
+	for _, v := range split.variants {
+		pick -= split.weights[v.name]
+		if pick < 0 {
+			return v
+		}
+	}
+	return split.variants[len(split.variants)-1]
+}
+
+func (split *trafficSplit) Name() string {
+	return split.name
+}
+
+func (split *trafficSplit) Weights() map[string]int {
+	split.lock.RLock()
+	defer split.lock.RUnlock()
+	weights := map[string]int{}
+	for name, weight := range split.weights {
+		weights[name] = weight
+	}
+	return weights
+}
+
+// SetWeights changes the weights of the named variants, variants that are not named keep their weight
+func (split *trafficSplit) SetWeights(weights map[string]int) error {
+	split.lock.Lock()
+	defer split.lock.Unlock()
+	for name, weight := range weights {
+		if _, ok := split.weights[name]; !ok {
+			return fmt.Errorf("unknown variant '%s'", name)
+		}
+		if weight < 0 {
+			return fmt.Errorf("weight of variant '%s' must not be negative", name)
+		}
+	}
+	for name, weight := range weights {
+		split.weights[name] = weight
+	}
+	log.Printf("traffic split '%s' weights changed to %v", split.name, split.weights)
+	return nil
+}
+
+type trafficSplitStatus struct {
+	Name    string         `json:"name"`
+	Weights map[string]int `json:"weights"`
+}
+
+// TrafficSplitHandler serves the weights of the splits as json on GET and changes the weights of the split
+// named in the json body on PUT or POST
+func TrafficSplitHandler(splits ...TrafficSplit) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			status := &trafficSplitStatus{}
+			if err := json.NewDecoder(r.Body).Decode(status); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			split := findSplit(splits, status.Name)
+			if split == nil {
+				http.Error(w, fmt.Sprintf("unknown traffic split '%s'", status.Name), http.StatusNotFound)
+				return
+			}
+			if err := split.SetWeights(status.Weights); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		statuses := []trafficSplitStatus{}
+		for _, split := range splits {
+			statuses = append(statuses, trafficSplitStatus{Name: split.Name(), Weights: split.Weights()})
+		}
+		sort.SliceStable(statuses, func(i, j int) bool {
+			return statuses[i].Name < statuses[j].Name
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+	})
+}
+
+func findSplit(splits []TrafficSplit, name string) TrafficSplit {
+	for _, split := range splits {
+		if split.Name() == name {
+			return split
+		}
+	}
+	return nil
+}
+
+// NewTrafficSplitBuilder creates a traffic split builder, the name identifies the split in logs and the
+// admin api
+func NewTrafficSplitBuilder(name string) TrafficSplitBuilder {
+	return &trafficSplitBuilder{
+		name:     name,
+		header:   DefaultVariantHeader,
+		variants: []*variant{},
+	}
+}
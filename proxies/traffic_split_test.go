@@ -0,0 +1,115 @@
+package proxies_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TrafficSplit", func() {
+	respond := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, name)
+		})
+	}
+	isCanary := func(r *http.Request) bool {
+		return r.Header.Get("X-Canary") == "true"
+	}
+	var (
+		split   proxies.TrafficSplit
+		backend *httptest.Server
+	)
+	BeforeEach(func() {
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(proxies.DefaultVariantHeader, "backend")
+			fmt.Fprint(w, "proxied")
+		}))
+		split = proxies.NewTrafficSplitBuilder("app").
+			Variant("stable", 100, respond("stable")).
+			VariantIf("canary", 0, isCanary, respond("canary")).
+			ToTrafficSplit()
+	})
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		recorder := httptest.NewRecorder()
+		split.ServeHTTP(recorder, req)
+		return recorder
+	}
+	AfterEach(func() {
+		backend.Close()
+	})
+	proxy := func() http.Handler {
+		backendURL, err := url.Parse(backend.URL)
+		Expect(err).To(BeNil())
+		return proxies.NewReverseProxyBuilder().
+			RewriteHost(backendURL, "/").
+			ToReverseProxy(nil)
+	}
+	It("records the variant in a response header", func() {
+		recorder := serve(nil)
+		Expect(recorder.Body.String()).To(Equal("stable"))
+		Expect(recorder.Header().Get(proxies.DefaultVariantHeader)).To(Equal("stable"))
+	})
+	It("replaces the variant header of the backend", func() {
+		split = proxies.NewTrafficSplitBuilder("app").
+			Variant("stable", 100, proxy()).
+			ToTrafficSplit()
+		recorder := serve(nil)
+		Expect(recorder.Body.String()).To(Equal("proxied"))
+		Expect(recorder.Header().Values(proxies.DefaultVariantHeader)).To(Equal([]string{"stable"}))
+
+		backend.Close()
+		recorder = serve(nil)
+		Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+		Expect(recorder.Header().Values(proxies.DefaultVariantHeader)).To(Equal([]string{"stable"}))
+	})
+	It("routes matching requests to the variant", func() {
+		recorder := serve(http.Header{"X-Canary": []string{"true"}})
+		Expect(recorder.Body.String()).To(Equal("canary"))
+		Expect(recorder.Header().Get(proxies.DefaultVariantHeader)).To(Equal("canary"))
+	})
+	It("splits by weight", func() {
+		Expect(split.SetWeights(map[string]int{"stable": 75, "canary": 25})).To(Succeed())
+		counts := map[string]int{}
+		for i := 0; i < 1000; i++ {
+			counts[serve(nil).Body.String()]++
+		}
+		Expect(counts["canary"]).To(BeNumerically("~", 250, 80))
+		Expect(counts["stable"]).To(BeNumerically("~", 750, 80))
+	})
+	It("rejects unknown variants and negative weights", func() {
+		Expect(split.SetWeights(map[string]int{"other": 1})).ToNot(Succeed())
+		Expect(split.SetWeights(map[string]int{"canary": -1})).ToNot(Succeed())
+		Expect(split.Weights()).To(Equal(map[string]int{"stable": 100, "canary": 0}))
+	})
+	It("changes weights through the admin handler", func() {
+		handler := proxies.TrafficSplitHandler(split)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("PUT", "/splits", strings.NewReader(`{"name":"app","weights":{"stable":0,"canary":100}}`)))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(serve(nil).Body.String()).To(Equal("canary"))
+
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/splits", nil))
+		statuses := []map[string]interface{}{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &statuses)).To(Succeed())
+		Expect(statuses).To(HaveLen(1))
+		Expect(statuses[0]["weights"]).To(Equal(map[string]interface{}{"stable": 0.0, "canary": 100.0}))
+
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("PUT", "/splits", strings.NewReader(`{"name":"missing"}`)))
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+	})
+})
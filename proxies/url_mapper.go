@@ -0,0 +1,137 @@
+package proxies
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// urlMapper maps urls of the backend pool found in a response body to the urls the client uses
+type urlMapper struct {
+	pool BackendPool
+	// base is the path of the backend that served the response, root relative urls are resolved against it
+	base string
+	// origin is the scheme and host of the frontend
+	origin string
+	host   string
+	// prefix is the frontend path prefix of the route without a trailing slash
+	prefix string
+	// paths map the backend paths below the prefix back to frontend paths
+	paths pathRewrites
+}
+
+func newURLMapper(pool BackendPool, pathPrefix string, response *http.Response) *urlMapper {
+	request := response.Request
+	m := &urlMapper{
+		pool:   pool,
+		prefix: strings.TrimSuffix(pathPrefix, "/"),
+	}
+	if forwardedURL := selectedURL(pool, request); forwardedURL != nil {
+		m.base = strings.TrimSuffix(forwardedURL.Path, "/")
+	}
+	m.host = request.Header.Get(HeaderXForwardedHost)
+	if strings.TrimSpace(m.host) == "" {
+		m.host = request.Host
+	}
+	scheme := request.Header.Get(HeaderXForwardedProto)
+	if strings.TrimSpace(scheme) == "" {
+		scheme = request.URL.Scheme
+	}
+	m.origin = scheme + "://" + m.host
+	return m
+}
+
+// mapURL returns the frontend url of a backend url, urls of other hosts, relative paths and other schemes
+// like data: are returned unchanged
+func (m *urlMapper) mapURL(value string) string {
+	trimmed := strings.TrimSpace(value)
+	lower := strings.ToLower(trimmed)
+	switch {
+	case strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://"):
+		start := strings.Index(trimmed, "//") + 2
+		host, rest := splitHost(trimmed[start:])
+		b := m.pool.Find(host)
+		if b == nil {
+			return value
+		}
+		rest, ok := trimBase(rest, strings.TrimSuffix(b.URL().Path, "/"))
+		if !ok {
+			return value
+		}
+		return m.origin + m.prefix + m.mapPath(rest)
+	case strings.HasPrefix(trimmed, "//"):
+		host, rest := splitHost(trimmed[2:])
+		b := m.pool.Find(host)
+		if b == nil {
+			return value
+		}
+		rest, ok := trimBase(rest, strings.TrimSuffix(b.URL().Path, "/"))
+		if !ok {
+			return value
+		}
+		return "//" + m.host + m.prefix + m.mapPath(rest)
+	case strings.HasPrefix(trimmed, "/"):
+		rest, ok := trimBase(trimmed, m.base)
+		if !ok {
+			return value
+		}
+		return m.prefix + m.mapPath(rest)
+	}
+	return value
+}
+
+// mapPath maps the backend path, query and fragment below the base back to the frontend path with the path rewrites
+func (m *urlMapper) mapPath(rest string) string {
+	if len(m.paths) == 0 || rest == "" || rest[0] != '/' {
+		return rest
+	}
+	fragment := ""
+	if i := strings.Index(rest, "#"); i >= 0 {
+		rest, fragment = rest[:i], rest[i:]
+	}
+	path, query := rest, ""
+	if i := strings.Index(rest, "?"); i >= 0 {
+		path, query = rest[:i], rest[i+1:]
+	}
+	mapped, mappedQuery := m.paths.backward(path, query)
+	if mapped == path && mappedQuery == query {
+		return rest + fragment
+	}
+	if mappedQuery != "" {
+		mapped += "?" + mappedQuery
+	}
+	return mapped + fragment
+}
+
+// splitHost splits the host from the path, query and fragment that follow it
+func splitHost(s string) (string, string) {
+	end := strings.IndexAny(s, "/?#")
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+// trimBase removes the backend base path from the start of the path, ok is false when the path is not below it
+func trimBase(rest string, base string) (string, bool) {
+	if !strings.HasPrefix(rest, base) {
+		return rest, false
+	}
+	rest = rest[len(base):]
+	if rest != "" && !strings.ContainsAny(rest[:1], "/?#") {
+		return rest, false
+	}
+	return rest, true
+}
+
+// mapAbsoluteURLs maps the absolute backend urls found anywhere in text like a script
+func (m *urlMapper) mapAbsoluteURLs(text string) string {
+	return absoluteURLRegex.ReplaceAllStringFunc(text, m.mapURL)
+}
+
+var absoluteURLRegex = regexp.MustCompile(`(?i)https?://[^\s"'<>()\\]+`)
+
+// mapCSSURLs maps the urls of url() functions and @import rules in css
+func (m *urlMapper) mapCSSURLs(css string) string {
+	return string(replaceAll(newCSSMatcher(m), []byte(css)))
+}
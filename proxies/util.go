@@ -1,6 +1,15 @@
 package proxies
 
-import "strings"
+import (
+	"io"
+	"strings"
+)
+
+// readCloser reads from the reader and closes the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
 
 func SingleJoiningSlash(a, b string) string {
 	aslash := strings.HasSuffix(a, "/")
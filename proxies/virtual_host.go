@@ -0,0 +1,202 @@
+package proxies
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// VirtualHostBuilder provides a builder interface for creating a handler that selects a backend by the incoming host
+type VirtualHostBuilder interface {
+	Host(hostPattern string, forwardedURL *url.URL, pathPrefix string) VirtualHostBuilder
+	HostWith(hostPattern string, forwardedURL *url.URL, pathPrefix string, configure RouteConfiguration) VirtualHostBuilder
+	HandleHost(hostPattern string, handler http.Handler) VirtualHostBuilder
+	DefaultHost(hostPattern string) VirtualHostBuilder
+	NotFound(handler http.Handler) VirtualHostBuilder
+	ToHandler(transport http.RoundTripper) http.Handler
+	Transport(transport http.RoundTripper) VirtualHostBuilder
+	Build() (http.Handler, error)
+}
+
+type virtualHostEntry struct {
+	hostPattern  string
+	forwardedURL *url.URL
+	pathPrefix   string
+	configure    RouteConfiguration
+	handler      http.Handler
+}
+
+type virtualHostBuilder struct {
+	hosts       []*virtualHostEntry
+	defaultHost string
+	notFound    http.Handler
+	transport   http.RoundTripper
+}
+
+type virtualHost struct {
+	pattern string
+	handler http.Handler
+}
+
+type virtualHostHandler struct {
+	exact     map[string]http.Handler
+	wildcards []*virtualHost
+	fallback  http.Handler
+}
+
+func (builder *virtualHostBuilder) Host(hostPattern string, forwardedURL *url.URL, pathPrefix string) VirtualHostBuilder {
+	return builder.HostWith(hostPattern, forwardedURL, pathPrefix, DefaultRouteConfiguration)
+}
+
+func (builder *virtualHostBuilder) HostWith(hostPattern string, forwardedURL *url.URL, pathPrefix string, configure RouteConfiguration) VirtualHostBuilder {
+	if configure == nil {
+		configure = DefaultRouteConfiguration
+	}
+	builder.hosts = append(builder.hosts, &virtualHostEntry{
+		hostPattern:  hostPattern,
+		forwardedURL: forwardedURL,
+		pathPrefix:   pathPrefix,
+		configure:    configure,
+	})
+	return builder
+}
+
+func (builder *virtualHostBuilder) HandleHost(hostPattern string, handler http.Handler) VirtualHostBuilder {
+	builder.hosts = append(builder.hosts, &virtualHostEntry{
+		hostPattern: hostPattern,
+		handler:     handler,
+	})
+	return builder
+}
+
+func (builder *virtualHostBuilder) DefaultHost(hostPattern string) VirtualHostBuilder {
+	builder.defaultHost = hostPattern
+	return builder
+}
+
+func (builder *virtualHostBuilder) NotFound(handler http.Handler) VirtualHostBuilder {
+	builder.notFound = handler
+	return builder
+}
+
+// Transport sets the round tripper the hosts of the handler returned by Build send requests with
+func (builder *virtualHostBuilder) Transport(transport http.RoundTripper) VirtualHostBuilder {
+	builder.transport = transport
+	return builder
+}
+
+// Build returns the handler, or the errors of every host whose configuration called a builder method with an
+// invalid argument
+func (builder *virtualHostBuilder) Build() (http.Handler, error) {
+	return builder.build(builder.transport)
+}
+
+// ToHandler creates the handler, it panics when the configuration of a host is invalid. Use Build to get the
+// errors instead
+func (builder *virtualHostBuilder) ToHandler(transport http.RoundTripper) http.Handler {
+	handler, err := builder.build(transport)
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+func (builder *virtualHostBuilder) build(transport http.RoundTripper) (http.Handler, error) {
+	handler := &virtualHostHandler{
+		exact:     map[string]http.Handler{},
+		wildcards: []*virtualHost{},
+	}
+	errs := BuildErrors{}
+
+	defaultHost := normalizeHost(builder.defaultHost)
+	for _, entry := range builder.hosts {
+		hostHandler := entry.handler
+		if hostHandler == nil {
+			var err error
+			hostHandler, err = entry.configure(NewReverseProxyBuilder(), entry.forwardedURL, entry.pathPrefix).
+				Transport(transport).
+				Build()
+			if err != nil {
+				errs = errs.add("host '"+entry.hostPattern+"'", err)
+				continue
+			}
+		}
+
+		pattern := normalizeHost(entry.hostPattern)
+		if pattern == defaultHost && handler.fallback == nil {
+			handler.fallback = hostHandler
+		}
+
+		if strings.HasPrefix(pattern, "*.") {
+			handler.wildcards = append(handler.wildcards, &virtualHost{
+				pattern: strings.TrimPrefix(pattern, "*"),
+				handler: hostHandler,
+			})
+			continue
+		}
+
+		if _, ok := handler.exact[pattern]; !ok {
+			handler.exact[pattern] = hostHandler
+		}
+	}
+
+	// the most specific wildcard wins, so order the wildcards from longest to shortest suffix
+	sort.SliceStable(handler.wildcards, func(i, j int) bool {
+		return len(handler.wildcards[i].pattern) > len(handler.wildcards[j].pattern)
+	})
+
+	if handler.fallback == nil {
+		handler.fallback = builder.notFound
+	}
+	if handler.fallback == nil {
+		handler.fallback = http.NotFoundHandler()
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return handler, nil
+}
+
+func (handler *virtualHostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := RequestHost(r)
+	if hostHandler, ok := handler.exact[host]; ok {
+		hostHandler.ServeHTTP(w, r)
+		return
+	}
+	for _, wildcard := range handler.wildcards {
+		if strings.HasSuffix(host, wildcard.pattern) {
+			wildcard.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	handler.fallback.ServeHTTP(w, r)
+}
+
+// RequestHost returns the original host of the request without the port, preferring the X-Forwarded-Host header
+func RequestHost(r *http.Request) string {
+	host := r.Header.Get(HeaderXForwardedHost)
+	if strings.TrimSpace(host) != "" {
+		// proxies may append hosts, the first one is the original
+		host = strings.Split(host, ",")[0]
+	} else {
+		host = r.Host
+	}
+	return normalizeHost(host)
+}
+
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// NewVirtualHostBuilder creates a virtual host builder that maps host names to reverse proxies
+func NewVirtualHostBuilder() VirtualHostBuilder {
+	return &virtualHostBuilder{
+		hosts: []*virtualHostEntry{},
+	}
+}
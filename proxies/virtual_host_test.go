@@ -0,0 +1,116 @@
+package proxies_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VirtualHost", func() {
+	var (
+		backends []*httptest.Server
+		frontend *httptest.Server
+		builder  proxies.VirtualHostBuilder
+	)
+	newBackend := func(name string) *url.URL {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s", name, r.URL.Path)
+		}))
+		backends = append(backends, backend)
+		backendURL, err := url.Parse(backend.URL)
+		Expect(err).To(BeNil())
+		return backendURL
+	}
+	get := func(host string, path string) (int, string) {
+		req, err := http.NewRequest("GET", frontend.URL+path, nil)
+		Expect(err).To(BeNil())
+		req.Host = host
+
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).To(BeNil())
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).To(BeNil())
+		return res.StatusCode, string(body)
+	}
+	BeforeEach(func() {
+		backends = []*httptest.Server{}
+		builder = proxies.NewVirtualHostBuilder().
+			Host("www.example.com", newBackend("www"), "").
+			Host("*.apps.example.com", newBackend("apps"), "/app").
+			Host("api.apps.example.com:8080", newBackend("api"), "")
+	})
+	JustBeforeEach(func() {
+		frontend = httptest.NewServer(builder.ToHandler(&http.Transport{}))
+	})
+	AfterEach(func() {
+		for _, backend := range backends {
+			backend.Close()
+		}
+		frontend.Close()
+	})
+	It("selects the backend by host", func() {
+		status, body := get("www.example.com", "/ok")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("www /ok"))
+	})
+	It("selects the backend by wildcard host", func() {
+		status, body := get("one.apps.example.com", "/app/ok")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("apps /ok"))
+	})
+	It("prefers exact hosts over wildcard hosts", func() {
+		status, body := get("api.apps.example.com", "/ok")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("api /ok"))
+	})
+	It("selects the backend by x-forwarded-host", func() {
+		req, err := http.NewRequest("GET", frontend.URL+"/ok", nil)
+		Expect(err).To(BeNil())
+		req.Header.Set(proxies.HeaderXForwardedHost, "WWW.example.com:443")
+
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).To(BeNil())
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).To(BeNil())
+		Expect(string(body)).To(Equal("www /ok"))
+	})
+	It("returns not found for unknown hosts", func() {
+		status, _ := get("unknown.example.com", "/ok")
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+	It("returns the errors of invalid hosts from build", func() {
+		invalid := func(builder proxies.ReverseProxyBuilder, forwardedURL *url.URL, pathPrefix string) proxies.ReverseProxyBuilder {
+			return builder.ReplaceResponseBody("[", "")
+		}
+		forwardedURL, _ := url.Parse("http://backend")
+		hosts := proxies.NewVirtualHostBuilder().
+			HostWith("broken.example.com", forwardedURL, "", invalid)
+
+		handler, err := hosts.Build()
+		Expect(handler).To(BeNil())
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("host 'broken.example.com': ReplaceResponseBody: invalid regular expression '['"))
+		Expect(func() { hosts.ToHandler(nil) }).To(Panic())
+	})
+	Context("default host", func() {
+		BeforeEach(func() {
+			builder.DefaultHost("www.example.com")
+		})
+		It("falls back to the default host", func() {
+			status, body := get("unknown.example.com", "/ok")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("www /ok"))
+		})
+	})
+})
@@ -0,0 +1,328 @@
+package proxies
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// xmlContentTypes are the media types the xml rewrites parse, including soap+xml and wsdl+xml
+var xmlContentTypes = contentTypes{"application/xml", "text/xml", "application/*+xml"}
+
+// xmlStep is a step of an xml location, an empty prefix matches elements with any prefix and * matches any name
+type xmlStep struct {
+	prefix     string
+	local      string
+	descendant bool
+}
+
+func (step xmlStep) matches(name xml.Name) bool {
+	if step.prefix != "" && step.prefix != name.Space {
+		return false
+	}
+	return step.local == "*" || step.local == name.Local
+}
+
+// xmlPath selects the text or an attribute of elements, like /Envelope/Body//name or //soap:address/@location
+type xmlPath struct {
+	steps []xmlStep
+	// attribute is the selected attribute, the text of the element is selected when it is empty
+	attribute xmlStep
+}
+
+// parseXMLPath parses an XPath like location made of element names separated by / or // that ends with an
+// element, text() or an @attribute. Names are compared as written in the document so a prefix like soap:
+// only matches elements with that prefix
+func parseXMLPath(path string) (*xmlPath, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("xml location '%s' must start with /", path)
+	}
+	parsed := &xmlPath{}
+	rest := path
+	for rest != "" {
+		descendant := strings.HasPrefix(rest, "//")
+		rest = strings.TrimPrefix(strings.TrimPrefix(rest, "/"), "/")
+		end := strings.Index(rest, "/")
+		if end < 0 {
+			end = len(rest)
+		}
+		name := rest[:end]
+		rest = rest[end:]
+		last := rest == ""
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("xml location '%s' has an empty step", path)
+		case strings.HasPrefix(name, "@") || name == "text()":
+			if !last || descendant {
+				return nil, fmt.Errorf("xml location '%s' may only end with %s", path, name)
+			}
+			if name != "text()" {
+				parsed.attribute = parseXMLName(name[1:])
+			}
+		default:
+			step := parseXMLName(name)
+			step.descendant = descendant
+			parsed.steps = append(parsed.steps, step)
+		}
+	}
+	if len(parsed.steps) == 0 {
+		return nil, fmt.Errorf("xml location '%s' does not select an element", path)
+	}
+	return parsed, nil
+}
+
+// ValidateXMLPath returns an error when the xml location can not be parsed
+func ValidateXMLPath(path string) error {
+	_, err := parseXMLPath(path)
+	return err
+}
+
+func mustParseXMLPath(path string) *xmlPath {
+	parsed, err := parseXMLPath(path)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func parseXMLName(name string) xmlStep {
+	if i := strings.Index(name, ":"); i >= 0 {
+		return xmlStep{prefix: name[:i], local: name[i+1:]}
+	}
+	return xmlStep{local: name}
+}
+
+// selects reports whether the location selects the element at the end of the stack of open elements
+func (path *xmlPath) selects(stack []xml.Name) bool {
+	return matchXMLSteps(stack, path.steps)
+}
+
+func matchXMLSteps(stack []xml.Name, steps []xmlStep) bool {
+	if len(steps) == 0 {
+		return len(stack) == 0
+	}
+	step := steps[0]
+	if !step.descendant {
+		return len(stack) > 0 && step.matches(stack[0]) && matchXMLSteps(stack[1:], steps[1:])
+	}
+	for i := range stack {
+		if step.matches(stack[i]) && matchXMLSteps(stack[i+1:], steps[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// xmlEdit rewrites the text or attribute the location selects
+type xmlEdit struct {
+	path    *xmlPath
+	rewrite func(value string) string
+}
+
+// replaceXML replaces the matches of the regular expression in the text or attribute at the location
+func replaceXML(path *xmlPath, regex *regexp.Regexp, replace string) []xmlEdit {
+	return []xmlEdit{{path: path, rewrite: func(value string) string {
+		return regex.ReplaceAllString(value, replace)
+	}}}
+}
+
+// wsdlLocations are the attributes of WSDL and XSD documents that point at services and other documents
+var wsdlLocations = []*xmlPath{
+	mustParseXMLPath("//address/@location"),
+	mustParseXMLPath("//endpoint/@address"),
+	mustParseXMLPath("//import/@location"),
+	mustParseXMLPath("//include/@location"),
+	mustParseXMLPath("//import/@schemaLocation"),
+	mustParseXMLPath("//include/@schemaLocation"),
+	mustParseXMLPath("//redefine/@schemaLocation"),
+}
+
+// mapWSDLLocations maps the service addresses and import locations of WSDL and XSD documents to the frontend
+func mapWSDLLocations(mapper *urlMapper) []xmlEdit {
+	edits := []xmlEdit{}
+	for _, path := range wsdlLocations {
+		edits = append(edits, xmlEdit{path: path, rewrite: mapper.mapURL})
+	}
+	return edits
+}
+
+// xmlRecorder keeps the bytes the decoder reads so unchanged tokens are written exactly as they were read
+type xmlRecorder struct {
+	reader   *bufio.Reader
+	recorded []byte
+}
+
+func (r *xmlRecorder) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.recorded = append(r.recorded, p[:n]...)
+	return n, err
+}
+
+func (r *xmlRecorder) ReadByte() (byte, error) {
+	b, err := r.reader.ReadByte()
+	if err == nil {
+		r.recorded = append(r.recorded, b)
+	}
+	return b, err
+}
+
+// xmlRewriter rewrites an xml document token by token while it is read, tokens that do not change keep their
+// bytes so prefixes, quotes and whitespace are preserved. Documents that fail to parse are an error when strict
+// is set, otherwise the rest of the document is sent unchanged
+type xmlRewriter struct {
+	source   io.ReadCloser
+	recorder *xmlRecorder
+	decoder  *xml.Decoder
+	edits    []xmlEdit
+	strict   bool
+	offset   int64
+	stack    []xml.Name
+	output   []byte
+	// rest is read once the document can no longer be parsed
+	rest io.Reader
+	err  error
+}
+
+func (x *xmlRewriter) Read(p []byte) (int, error) {
+	for len(x.output) == 0 {
+		if x.rest != nil {
+			return x.rest.Read(p)
+		}
+		if x.err != nil {
+			return 0, x.err
+		}
+		x.next()
+	}
+	n := copy(p, x.output)
+	x.output = x.output[n:]
+	return n, nil
+}
+
+func (x *xmlRewriter) Close() error {
+	return x.source.Close()
+}
+
+// next rewrites the next token of the document
+func (x *xmlRewriter) next() {
+	token, err := x.decoder.RawToken()
+	if err != nil {
+		x.output = append(x.output[:0], x.recorder.recorded...)
+		x.recorder.recorded = x.recorder.recorded[:0]
+		switch {
+		case err == io.EOF:
+			x.err = io.EOF
+		case x.strict:
+			x.err = fmt.Errorf("invalid xml body: %v", err)
+		default:
+			x.rest = x.recorder.reader
+		}
+		return
+	}
+	offset := x.decoder.InputOffset()
+	size := int(offset - x.offset)
+	x.offset = offset
+	raw := x.recorder.recorded[:size]
+	x.output = append(x.output[:0], x.rewriteToken(token, raw)...)
+	x.recorder.recorded = append(x.recorder.recorded[:0], x.recorder.recorded[size:]...)
+}
+
+func (x *xmlRewriter) rewriteToken(token xml.Token, raw []byte) []byte {
+	switch t := token.(type) {
+	case xml.StartElement:
+		x.stack = append(x.stack, t.Name)
+		if x.rewriteAttributes(&t) {
+			return startElementBytes(t, bytes.HasSuffix(raw, []byte("/>")))
+		}
+	case xml.EndElement:
+		if len(x.stack) > 0 {
+			x.stack = x.stack[:len(x.stack)-1]
+		}
+	case xml.CharData:
+		text := string(t)
+		for _, edit := range x.edits {
+			if edit.path.attribute.local == "" && edit.path.selects(x.stack) {
+				text = edit.rewrite(text)
+			}
+		}
+		if text != string(t) {
+			return charDataBytes(text, bytes.HasPrefix(raw, []byte("<![CDATA[")))
+		}
+	}
+	return raw
+}
+
+// rewriteAttributes rewrites the selected attributes of the element and reports whether any changed
+func (x *xmlRewriter) rewriteAttributes(start *xml.StartElement) bool {
+	changed := false
+	for _, edit := range x.edits {
+		attribute := edit.path.attribute
+		if attribute.local == "" || !edit.path.selects(x.stack) {
+			continue
+		}
+		for i := range start.Attr {
+			attr := &start.Attr[i]
+			if !attribute.matches(attr.Name) || attribute.prefix == "" && attr.Name.Space == "xmlns" {
+				continue
+			}
+			if value := edit.rewrite(attr.Value); value != attr.Value {
+				attr.Value = value
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// startElementBytes writes the start tag with the prefixes of the element and its attributes as they were read
+func startElementBytes(start xml.StartElement, selfClosing bool) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("<" + qualifiedName(start.Name))
+	for _, attr := range start.Attr {
+		buf.WriteString(" " + qualifiedName(attr.Name) + `="` + xmlAttributeEscaper.Replace(attr.Value) + `"`)
+	}
+	if selfClosing {
+		buf.WriteString("/>")
+	} else {
+		buf.WriteString(">")
+	}
+	return buf.Bytes()
+}
+
+func charDataBytes(text string, cdata bool) []byte {
+	if cdata {
+		return []byte("<![CDATA[" + strings.Replace(text, "]]>", "]]]]><![CDATA[>", -1) + "]]>")
+	}
+	return []byte(xmlTextEscaper.Replace(text))
+}
+
+var (
+	xmlTextEscaper      = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	xmlAttributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func newXMLRewriter(source io.ReadCloser, edits []xmlEdit, strict bool) io.ReadCloser {
+	recorder := &xmlRecorder{reader: bufio.NewReader(source)}
+	decoder := xml.NewDecoder(recorder)
+	// the body is already decoded from the charset of the content type, other encodings are kept as they are
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return &xmlRewriter{
+		source:   source,
+		recorder: recorder,
+		decoder:  decoder,
+		edits:    edits,
+		strict:   strict,
+	}
+}
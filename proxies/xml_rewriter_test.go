@@ -0,0 +1,88 @@
+package proxies_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing/iotest"
+
+	"github.com/patrickhuber/go-reverse-proxy/proxies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("XMLRewriter", func() {
+	backendURL, _ := url.Parse("http://backend:8080/base")
+	respond := func(builder proxies.ReverseProxyBuilder, contentType string, body string) string {
+		response := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{contentType}},
+			Body:       ioutil.NopCloser(iotest.OneByteReader(strings.NewReader(body))),
+			Request:    httptest.NewRequest("GET", "http://frontend/app/Service.svc?wsdl", nil),
+		}
+		Expect(builder.ToReverseProxy(nil).ModifyResponse(response)).To(Succeed())
+		return readAll(response)
+	}
+	It("maps wsdl service and import locations", func() {
+		wsdl := `<?xml version="1.0" encoding="utf-8"?>
+<wsdl:definitions xmlns:wsdl="http://schemas.xmlsoap.org/wsdl/" xmlns:soap='http://schemas.xmlsoap.org/wsdl/soap/'>
+  <wsdl:import namespace="urn:a" location="http://backend:8080/base/Service.svc?wsdl=wsdl0"/>
+  <wsdl:types><xsd:schema><xsd:import schemaLocation="http://backend:8080/base/Service.svc?xsd=xsd0" namespace="urn:b"/></xsd:schema></wsdl:types>
+  <!-- <soap:address location="http://backend:8080/base/comment"/> -->
+  <wsdl:service name="Service">
+    <wsdl:port name="Port" binding="tns:Binding">
+      <soap:address location="http://backend:8080/base/Service.svc" />
+    </wsdl:port>
+    <wsdl:port name="Other"><soap:address location="http://elsewhere/Service.svc"/></wsdl:port>
+  </wsdl:service>
+</wsdl:definitions>`
+		builder := proxies.NewReverseProxyBuilder().RewriteWSDL(backendURL, "/app")
+		Expect(respond(builder, "text/xml; charset=utf-8", wsdl)).To(Equal(`<?xml version="1.0" encoding="utf-8"?>
+<wsdl:definitions xmlns:wsdl="http://schemas.xmlsoap.org/wsdl/" xmlns:soap='http://schemas.xmlsoap.org/wsdl/soap/'>
+  <wsdl:import namespace="urn:a" location="http://frontend/app/Service.svc?wsdl=wsdl0"/>
+  <wsdl:types><xsd:schema><xsd:import schemaLocation="http://frontend/app/Service.svc?xsd=xsd0" namespace="urn:b"/></xsd:schema></wsdl:types>
+  <!-- <soap:address location="http://backend:8080/base/comment"/> -->
+  <wsdl:service name="Service">
+    <wsdl:port name="Port" binding="tns:Binding">
+      <soap:address location="http://frontend/app/Service.svc"/>
+    </wsdl:port>
+    <wsdl:port name="Other"><soap:address location="http://elsewhere/Service.svc"/></wsdl:port>
+  </wsdl:service>
+</wsdl:definitions>`))
+	})
+	It("replaces selected text and attributes", func() {
+		envelope := `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body>` +
+			`<m:GetUser xmlns:m="urn:users" m:tenant="internal-1"><m:Name>a &amp; b</m:Name><m:Note><![CDATA[internal <x>]]></m:Note></m:GetUser>` +
+			`<Name>internal</Name></s:Body></s:Envelope>`
+		builder := proxies.NewReverseProxyBuilder().
+			ReplaceResponseXML("/Envelope/Body/GetUser/@m:tenant", "internal", "public").
+			ReplaceResponseXML("//m:Name", "&", "<and>").
+			ReplaceResponseXML("//GetUser/Note/text()", "internal", "public")
+		Expect(respond(builder, "application/soap+xml", envelope)).To(Equal(
+			`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body>` +
+				`<m:GetUser xmlns:m="urn:users" m:tenant="public-1"><m:Name>a &lt;and&gt; b</m:Name><m:Note><![CDATA[public <x>]]></m:Note></m:GetUser>` +
+				`<Name>internal</Name></s:Body></s:Envelope>`))
+	})
+	It("sends the rest of invalid xml unchanged", func() {
+		builder := proxies.NewReverseProxyBuilder().ReplaceResponseXML("//a", "x", "y")
+		Expect(respond(builder, "application/xml", `<a>x</a><a b=>x</a>`)).To(Equal(`<a>y</a><a b=>x</a>`))
+	})
+	It("rejects invalid locations", func() {
+		_, err := proxies.NewReverseProxyBuilder().
+			ReplaceResponseXML("a", "x", "y").
+			ReplaceResponseXML("/a/@b/c", "x", "y").
+			Build()
+		Expect(err).To(HaveLen(2))
+	})
+	It("rejects invalid request bodies", func() {
+		proxy := proxies.NewReverseProxyBuilder().ReplaceRequestXML("//user", "x", "y").ToReverseProxy(nil)
+		request := httptest.NewRequest("POST", "http://frontend/soap", strings.NewReader(`<user b=>x</user>`))
+		request.Header.Set("Content-Type", "text/xml")
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+})